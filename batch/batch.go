@@ -0,0 +1,215 @@
+// Package batch runs the prompts of a JSONL file concurrently and writes the answers
+// as JSONL, so that an interrupted run can be resumed from its output.
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hirosassa/sgpt/handler"
+)
+
+// Roles are the values of the role field of an item; empty selects the role of the flags.
+var Roles = []string{"default", "shell", "code", "describe-shell"}
+
+// Item is a line of the input.
+type Item struct {
+	// ID identifies the answer in the output; the line number by default.
+	ID     string `json:"id"`
+	Prompt string `json:"prompt"`
+	// Role is one of Roles.
+	Role  string `json:"role"`
+	Model string `json:"model"`
+	// Vars are template and role variables, on top of --var.
+	Vars map[string]string `json:"vars"`
+}
+
+// Result is a line of the output: the response to an item, or why it failed.
+type Result struct {
+	ID string `json:"id"`
+	*handler.Response
+	Error string `json:"error,omitempty"`
+}
+
+// Read parses JSONL items, skipping blank lines.
+func Read(r io.Reader) ([]Item, error) {
+	var items []Item
+	seen := map[string]int{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var item Item
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&item); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if strings.TrimSpace(item.Prompt) == "" {
+			return nil, fmt.Errorf("line %d: the prompt is empty", n)
+		}
+		if item.Role != "" && !isRole(item.Role) {
+			return nil, fmt.Errorf("line %d: unknown role %q, must be one of: %s", n, item.Role, strings.Join(Roles, ", "))
+		}
+		if item.ID == "" {
+			item.ID = strconv.Itoa(n)
+		}
+		if first, ok := seen[item.ID]; ok {
+			return nil, fmt.Errorf("line %d: id %q is already used on line %d", n, item.ID, first)
+		}
+		seen[item.ID] = n
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
+
+func isRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenOutput opens the output file for appending and returns the IDs answered by an
+// earlier run. Failed items are not answered, so they are run again. A line cut by
+// an interruption is removed.
+func OpenOutput(path string) (*os.File, map[string]bool, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if complete := bytes.LastIndexByte(data, '\n') + 1; complete < len(data) {
+		data = data[:complete]
+		if err := f.Truncate(int64(complete)); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	done := map[string]bool{}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var r Result
+		if json.Unmarshal(line, &r) == nil && r.ID != "" && r.Error == "" && r.Response != nil {
+			done[r.ID] = true
+		}
+	}
+	return f, done, nil
+}
+
+// Config bounds the requests of a run.
+type Config struct {
+	// Concurrency is the number of items answered at the same time.
+	Concurrency int
+	// Rate is the maximum number of requests started per minute; zero is unlimited.
+	Rate int
+}
+
+// Stats counts the items of a run.
+type Stats struct {
+	Answered, Failed int
+}
+
+// Run answers the items with do and passes every result to emit, in the order they
+// are answered. emit is never called concurrently. When ctx is cancelled, the items
+// not answered yet are left out, so that a later run picks them up, and the error of
+// ctx is returned.
+func Run(ctx context.Context, items []Item, config Config, do func(context.Context, Item) (*handler.Response, error), emit func(Result) error) (Stats, error) {
+	if config.Concurrency < 1 {
+		return Stats{}, fmt.Errorf("concurrency must be at least 1, got %d", config.Concurrency)
+	}
+	if config.Rate < 0 {
+		return Stats{}, fmt.Errorf("rate must not be negative, got %d", config.Rate)
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan Item)
+	go func() {
+		defer close(jobs)
+		var tick <-chan time.Time
+		if config.Rate > 0 {
+			ticker := time.NewTicker(time.Minute / time.Duration(config.Rate))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for i, item := range items {
+			if tick != nil && i > 0 {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case jobs <- item:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		mu      sync.Mutex
+		stats   Stats
+		emitErr error
+		wg      sync.WaitGroup
+	)
+	for range min(config.Concurrency, len(items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				res, err := do(ctx, item)
+				if ctx.Err() != nil {
+					// interrupted: left for the next run
+					continue
+				}
+				result := Result{ID: item.ID, Response: res}
+				if err != nil {
+					result = Result{ID: item.ID, Error: err.Error()}
+				}
+
+				mu.Lock()
+				if emitErr == nil {
+					if emitErr = emit(result); emitErr != nil {
+						cancel()
+					} else if err != nil {
+						stats.Failed++
+					} else {
+						stats.Answered++
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if emitErr != nil {
+		return stats, emitErr
+	}
+	return stats, parent.Err()
+}
@@ -0,0 +1,133 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hirosassa/sgpt/handler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRead(t *testing.T) {
+	t.Parallel()
+	items, err := Read(strings.NewReader(`{"id": "T-1", "prompt": "list files", "role": "shell", "model": "gpt-4o-mini", "vars": {"OS": "Linux"}}
+
+{"prompt": "What is Go?"}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []Item{
+		{ID: "T-1", Prompt: "list files", Role: "shell", Model: "gpt-4o-mini", Vars: map[string]string{"OS": "Linux"}},
+		{ID: "3", Prompt: "What is Go?"},
+	}, items)
+
+	for input, want := range map[string]string{
+		`{"prompt": " "}`:                                           "line 1: the prompt is empty",
+		`{"prompt": "hi", "role": "poet"}`:                          `line 1: unknown role "poet"`,
+		`{"prompt": "hi", "temperature": 2}`:                        `line 1: json: unknown field "temperature"`,
+		"{\"prompt\": \"hi\"}\n{\"id\": \"1\", \"prompt\": \"hi\"}": `line 2: id "1" is already used on line 1`,
+		`not json`: "line 1: invalid character",
+	} {
+		_, err := Read(strings.NewReader(input))
+		assert.ErrorContains(t, err, want, input)
+	}
+}
+
+func TestOpenOutput(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(`{"id": "1", "content": "ls"}
+{"id": "2", "error": "rate limited"}
+{"id": "3", "cont`), 0o600))
+
+	f, done, err := OpenOutput(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"1": true}, done)
+	_, err = f.WriteString(`{"id": "2", "content": "pwd"}` + "\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id": "1", "content": "ls"}
+{"id": "2", "error": "rate limited"}
+{"id": "2", "content": "pwd"}
+`, string(data), "the cut line is removed")
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	items := []Item{{ID: "1", Prompt: "a"}, {ID: "2", Prompt: "fail"}, {ID: "3", Prompt: "c"}, {ID: "4", Prompt: "d"}}
+	var running, peak atomic.Int32
+	do := func(ctx context.Context, item Item) (*handler.Response, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		if item.Prompt == "fail" {
+			return nil, errors.New("rate limited")
+		}
+		return &handler.Response{Content: strings.ToUpper(item.Prompt)}, nil
+	}
+
+	var mu sync.Mutex
+	results := map[string]Result{}
+	stats, err := Run(context.Background(), items, Config{Concurrency: 2}, do, func(r Result) error {
+		mu.Lock()
+		defer mu.Unlock()
+		results[r.ID] = r
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Answered: 3, Failed: 1}, stats)
+	assert.Equal(t, int32(2), peak.Load())
+	assert.Equal(t, "D", results["4"].Content)
+	assert.Equal(t, Result{ID: "2", Error: "rate limited"}, results["2"])
+
+	_, err = Run(context.Background(), items, Config{}, do, func(Result) error { return nil })
+	assert.ErrorContains(t, err, "concurrency must be at least 1")
+}
+
+func TestRunRate(t *testing.T) {
+	t.Parallel()
+	items := []Item{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	do := func(ctx context.Context, item Item) (*handler.Response, error) { return &handler.Response{}, nil }
+	start := time.Now()
+	// one request every 20ms
+	_, err := Run(context.Background(), items, Config{Concurrency: 3, Rate: 3000}, do, func(Result) error { return nil })
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
+
+func TestRunInterrupted(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	items := []Item{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	do := func(ctx context.Context, item Item) (*handler.Response, error) {
+		if item.ID == "2" {
+			cancel()
+			return nil, ctx.Err()
+		}
+		return &handler.Response{}, nil
+	}
+	var emitted []string
+	stats, err := Run(ctx, items, Config{Concurrency: 1}, do, func(r Result) error {
+		emitted = append(emitted, r.ID)
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, Stats{Answered: 1}, stats)
+	assert.Equal(t, []string{"1"}, emitted, "interrupted items are left for the next run")
+}
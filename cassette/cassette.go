@@ -0,0 +1,378 @@
+// Package cassette records provider HTTP traffic to files and replays it, so that
+// sgpt can be tested and demonstrated without network access or API keys.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces secrets in recorded interactions.
+const Redacted = "REDACTED"
+
+// redactedHeaders are the headers that carry credentials.
+var redactedHeaders = []string{
+	"Authorization",
+	"Api-Key",
+	"Cookie",
+	"Set-Cookie",
+	"X-Goog-Api-Key",
+	"Openai-Organization",
+	"Openai-Project",
+}
+
+// redactedParams are the query parameters that carry credentials.
+var redactedParams = []string{"key", "api_key", "access_token"}
+
+// Interaction is a recorded request and its response. It is stored as one JSON file.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is a recorded request.
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+// Response is a recorded response.
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       Body        `json:"body,omitempty"`
+}
+
+// Body is stored as a JSON value when it is valid JSON and as a string otherwise,
+// so that cassettes stay readable and can be edited by hand.
+type Body []byte
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	if len(b) == 0 {
+		return []byte(`""`), nil
+	}
+	if json.Valid(b) && !bytes.HasPrefix(bytes.TrimSpace(b), []byte(`"`)) {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, b); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return json.Marshal(string(b))
+}
+
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = Body(s)
+		return nil
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+// Transport is an http.RoundTripper that records or replays interactions in a directory.
+type Transport struct {
+	dir     string
+	next    http.RoundTripper
+	secrets []string
+
+	mu           sync.Mutex
+	recording    bool
+	seq          int
+	interactions []*Interaction
+	used         []bool
+}
+
+// NewRecorder returns a transport that sends requests with next and records them in dir.
+// Interactions are appended to those already in dir. Known credential headers and query
+// parameters as well as every occurrence of the secrets are redacted.
+func NewRecorder(dir string, next http.RoundTripper, secrets ...string) (*Transport, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	files, err := interactionFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Transport{dir: dir, next: next, secrets: nonEmpty(secrets), recording: true, seq: len(files)}, nil
+}
+
+// NewReplayer returns a transport that answers requests from the interactions recorded in dir
+// and never sends them. A request matches an interaction with the same method, URL and
+// body, where JSON bodies are compared regardless of formatting and key order. Each
+// interaction is replayed once in recording order; the last match is reused after that.
+func NewReplayer(dir string) (*Transport, error) {
+	files, err := interactionFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recorded interactions in %s", dir)
+	}
+	t := &Transport{dir: dir}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var i Interaction
+		if err := json.Unmarshal(data, &i); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", f, err)
+		}
+		t.interactions = append(t.interactions, &i)
+	}
+	t.used = make([]bool, len(t.interactions))
+	return t, nil
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if t.recording {
+		return t.record(req, body)
+	}
+	return t.replay(req, body)
+}
+
+func (t *Transport) record(req *http.Request, body []byte) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	request := Request{
+		Method: req.Method,
+		URL:    redactURL(req.URL),
+		Header: t.redactHeader(req.Header),
+		Body:   t.redact(body),
+	}
+	response := Response{
+		StatusCode: res.StatusCode,
+		Header:     t.redactHeader(res.Header),
+	}
+	response.Header.Del("Content-Length")
+	response.Header.Del("Date")
+	// The body is passed through as it arrives, so that streamed answers are not held
+	// back until the end, and recorded once it is read.
+	res.Body = &recordingBody{ReadCloser: res.Body, save: func(resBody []byte) error {
+		response.Body = t.redact(resBody)
+		return t.store(&Interaction{Request: request, Response: response})
+	}}
+	return res, nil
+}
+
+// store writes the interaction to the next free file of the directory.
+func (t *Transport) store(i *Interaction) error {
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(i); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Other processes may record into the same directory, so never overwrite a file.
+	for {
+		t.seq++
+		f, err := os.OpenFile(filepath.Join(t.dir, fmt.Sprintf("%04d.json", t.seq)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(data.Bytes()); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
+
+// recordingBody copies a response body while it is read and saves the copy once,
+// at the end of the body or when it is closed.
+type recordingBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	save func(body []byte) error
+	once sync.Once
+	err  error
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		if serr := b.finish(); serr != nil {
+			return n, serr
+		}
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if serr := b.finish(); serr != nil {
+		return serr
+	}
+	return err
+}
+
+func (b *recordingBody) finish() error {
+	b.once.Do(func() {
+		b.err = b.save(b.buf.Bytes())
+	})
+	return b.err
+}
+
+func (t *Transport) replay(req *http.Request, body []byte) (*http.Response, error) {
+	key := matchKey(req.Method, redactURL(req.URL), t.redact(body))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	match := -1
+	for n, i := range t.interactions {
+		if matchKey(i.Request.Method, i.Request.URL, i.Request.Body) != key {
+			continue
+		}
+		match = n
+		if !t.used[n] {
+			break
+		}
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("no interaction recorded in %s matches %s %s", t.dir, req.Method, redactURL(req.URL))
+	}
+	t.used[match] = true
+
+	recorded := t.interactions[match].Response
+	header := recorded.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (t *Transport) redact(data []byte) []byte {
+	for _, s := range t.secrets {
+		data = bytes.ReplaceAll(data, []byte(s), []byte(Redacted))
+	}
+	return data
+}
+
+func (t *Transport) redactHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for _, name := range redactedHeaders {
+		if _, ok := out[name]; ok {
+			out.Set(name, Redacted)
+		}
+	}
+	for name, values := range out {
+		for n, v := range values {
+			out[name][n] = string(t.redact([]byte(v)))
+		}
+	}
+	return out
+}
+
+func redactURL(u *url.URL) string {
+	redacted := *u
+	query := redacted.Query()
+	for _, name := range redactedParams {
+		if query.Has(name) {
+			query.Set(name, Redacted)
+		}
+	}
+	// Encode sorts the parameters, which makes the URL independent of their order.
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+// matchKey normalizes a request for comparison.
+func matchKey(method, rawURL string, body []byte) string {
+	return method + " " + rawURL + "\n" + normalize(body)
+}
+
+// normalize returns JSON bodies in a canonical form, with sorted keys and no whitespace.
+func normalize(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return string(body)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return string(body)
+	}
+	return string(data)
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// interactionFiles lists the interaction files of dir in recording order.
+func interactionFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("cassette directory %s does not exist", dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func nonEmpty(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
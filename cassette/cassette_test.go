@@ -0,0 +1,130 @@
+package cassette
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func send(t *testing.T, client *http.Client, url, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer sk-secret")
+	res, err := client.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res.StatusCode, string(data)
+}
+
+func TestRecordReplay(t *testing.T) {
+	t.Parallel()
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		data, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=abc")
+		_, _ = io.WriteString(w, `{"answer": `+string(data)+`, "call": `+string(rune('0'+calls))+`}`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	recorder, err := NewRecorder(dir, nil, "sk-secret")
+	require.NoError(t, err)
+	client := &http.Client{Transport: recorder}
+
+	status, body := send(t, client, srv.URL+"/v1/chat?key=sk-secret&b=2&a=1", `{"prompt": "hi sk-secret", "n": 1}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"answer": {"prompt": "hi sk-secret", "n": 1}, "call": 1}`, body)
+	_, _ = send(t, client, srv.URL+"/v1/chat?key=sk-secret&b=2&a=1", `{"prompt": "hi sk-secret", "n": 1}`)
+	require.Equal(t, 2, calls)
+
+	data, err := os.ReadFile(filepath.Join(dir, "0001.json"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "sk-secret")
+	assert.NotContains(t, string(data), "session=abc")
+	assert.Contains(t, string(data), "a=1&b=2&key=REDACTED")
+	assert.Contains(t, string(data), `"prompt": "hi REDACTED"`)
+
+	replayer, err := NewReplayer(dir)
+	require.NoError(t, err)
+	client = &http.Client{Transport: replayer}
+
+	// Key order, whitespace, query order and the key value do not matter.
+	url := srv.URL + "/v1/chat?a=1&b=2&key=other"
+	_, body = send(t, client, url, `{"n":1,"prompt":"hi REDACTED"}`)
+	assert.JSONEq(t, `{"answer": {"prompt": "hi REDACTED", "n": 1}, "call": 1}`, body)
+	_, body = send(t, client, url, `{"n":1,"prompt":"hi REDACTED"}`)
+	assert.JSONEq(t, `{"answer": {"prompt": "hi REDACTED", "n": 1}, "call": 2}`, body)
+	_, body = send(t, client, url, `{"n":1,"prompt":"hi REDACTED"}`)
+	assert.JSONEq(t, `{"answer": {"prompt": "hi REDACTED", "n": 1}, "call": 2}`, body)
+	assert.Equal(t, 2, calls)
+
+	_, err = client.Post(url, "application/json", strings.NewReader(`{"n": 2}`))
+	assert.ErrorContains(t, err, "no interaction recorded")
+}
+
+func TestRecordStream(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	recorder, err := NewRecorder(dir, nil)
+	require.NoError(t, err)
+	res, err := (&http.Client{Transport: recorder}).Get(srv.URL)
+	require.NoError(t, err)
+
+	// the first event arrives while the server still holds back the rest
+	buf := make([]byte, len("data: first\n\n"))
+	_, err = io.ReadFull(res.Body, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "data: first\n\n", string(buf))
+	close(release)
+	rest, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "data: [DONE]\n\n", string(rest))
+	require.NoError(t, res.Body.Close())
+
+	data, err := os.ReadFile(filepath.Join(dir, "0001.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"body": "data: first\n\ndata: [DONE]\n\n"`)
+	_, err = os.Stat(filepath.Join(dir, "0002.json"))
+	assert.ErrorIs(t, err, os.ErrNotExist, "the interaction is recorded once")
+}
+
+func TestNewReplayer(t *testing.T) {
+	t.Parallel()
+	_, err := NewReplayer(filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorContains(t, err, "does not exist")
+	_, err = NewReplayer(t.TempDir())
+	assert.ErrorContains(t, err, "no recorded interactions")
+}
+
+func TestBody(t *testing.T) {
+	t.Parallel()
+	for _, raw := range []string{`{"a": [1, 2]}`, "data: {\"a\":1}\n\ndata: [DONE]\n\n", `"quoted"`, ""} {
+		data, err := Body(raw).MarshalJSON()
+		require.NoError(t, err)
+		var got Body
+		require.NoError(t, got.UnmarshalJSON(data))
+		assert.Equal(t, normalize([]byte(raw)), normalize(got))
+	}
+}
@@ -0,0 +1,137 @@
+// Package chatlog renders stored conversations for sharing and reads the chat caches
+// of shell_gpt, the Python tool sgpt mirrors.
+package chatlog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// Formats lists the formats Write supports.
+var Formats = []string{"md", "html", "json"}
+
+// Message is a message of a conversation.
+type Message struct {
+	// Role is one of "system", "user" or "assistant".
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Chat is a stored conversation.
+type Chat struct {
+	ID       string    `json:"id"`
+	Messages []Message `json:"messages"`
+}
+
+// ValidateFormat reports whether Write supports the format.
+func ValidateFormat(format string) error {
+	switch format {
+	case "md", "html", "json":
+		return nil
+	default:
+		return fmt.Errorf("unsupported chat format %q, must be one of: %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+// Write writes the chat in the given format: md, html or json. The Markdown and HTML
+// renderings omit system messages repeating the previous one, as sgpt sends the system
+// message again with every prompt; json keeps every message.
+func Write(w io.Writer, format string, c *Chat) error {
+	if err := ValidateFormat(format); err != nil {
+		return err
+	}
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(c)
+	case "html":
+		return htmlTemplate.Execute(w, &Chat{ID: c.ID, Messages: readable(c.Messages)})
+	default:
+		return writeMarkdown(w, c)
+	}
+}
+
+func writeMarkdown(w io.Writer, c *Chat) error {
+	var b strings.Builder
+	b.WriteString("# " + c.ID + "\n")
+	for _, m := range readable(c.Messages) {
+		fmt.Fprintf(&b, "\n## %s\n\n%s\n", title(m.Role), strings.TrimSpace(m.Content))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// readable drops the system messages repeating the previous system message.
+func readable(messages []Message) []Message {
+	var kept []Message
+	var system string
+	for _, m := range messages {
+		if m.Role == "system" {
+			if m.Content == system {
+				continue
+			}
+			system = m.Content
+		}
+		kept = append(kept, m)
+	}
+	return kept
+}
+
+func title(role string) string {
+	if role == "" {
+		return ""
+	}
+	return strings.ToUpper(role[:1]) + role[1:]
+}
+
+var htmlTemplate = template.Must(template.New("chat").Funcs(template.FuncMap{"title": title}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{ .ID }}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 50rem; margin: 2rem auto; padding: 0 1rem; }
+.message { border-radius: 0.5rem; margin: 1rem 0; padding: 0.5rem 1rem; }
+.system { background: #f3f3f3; }
+.user { background: #e8f0fe; }
+.assistant { background: #e6f4ea; }
+h2 { font-size: 0.9rem; margin: 0.5rem 0; }
+pre { white-space: pre-wrap; font-family: inherit; margin: 0.5rem 0; }
+</style>
+</head>
+<body>
+<h1>{{ .ID }}</h1>
+{{- range .Messages }}
+<div class="message {{ .Role }}">
+<h2>{{ title .Role }}</h2>
+<pre>{{ .Content }}</pre>
+</div>
+{{- end }}
+</body>
+</html>
+`))
+
+// ParseShellGPT parses a chat cache file of shell_gpt, a JSON list of messages.
+func ParseShellGPT(data []byte) ([]Message, error) {
+	var messages []Message
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, fmt.Errorf("not a shell_gpt chat: %w", err)
+	}
+	if len(messages) == 0 {
+		return nil, errors.New("not a shell_gpt chat: it has no messages")
+	}
+	for i, m := range messages {
+		switch m.Role {
+		case "system", "user", "assistant":
+		default:
+			return nil, fmt.Errorf("not a shell_gpt chat: message %d has the unknown role %q", i+1, m.Role)
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,64 @@
+package chatlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var chat = &Chat{
+	ID: "work",
+	Messages: []Message{
+		{Role: "user", Content: "What is Go?"},
+		{Role: "assistant", Content: "A language."},
+		{Role: "system", Content: "You are ShellGPT"},
+		{Role: "user", Content: "Is <T> generic?"},
+		{Role: "assistant", Content: "Yes & no."},
+		{Role: "system", Content: "You are ShellGPT"},
+		{Role: "user", Content: "Thanks"},
+	},
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+	var b bytes.Buffer
+	require.NoError(t, Write(&b, "md", chat))
+	assert.Equal(t, "# work\n"+
+		"\n## User\n\nWhat is Go?\n"+
+		"\n## Assistant\n\nA language.\n"+
+		"\n## System\n\nYou are ShellGPT\n"+
+		"\n## User\n\nIs <T> generic?\n"+
+		"\n## Assistant\n\nYes & no.\n"+
+		"\n## User\n\nThanks\n", b.String())
+
+	b.Reset()
+	require.NoError(t, Write(&b, "html", chat))
+	assert.Contains(t, b.String(), "<title>work</title>")
+	assert.Contains(t, b.String(), "<div class=\"message user\">\n<h2>User</h2>\n<pre>Is &lt;T&gt; generic?</pre>")
+	assert.Equal(t, 1, bytes.Count(b.Bytes(), []byte("You are ShellGPT")))
+
+	b.Reset()
+	require.NoError(t, Write(&b, "json", chat))
+	var got Chat
+	require.NoError(t, json.Unmarshal(b.Bytes(), &got))
+	assert.Equal(t, *chat, got)
+
+	assert.ErrorContains(t, Write(&b, "pdf", chat), `unsupported chat format "pdf"`)
+}
+
+func TestParseShellGPT(t *testing.T) {
+	t.Parallel()
+	messages, err := ParseShellGPT([]byte(`[{"role": "system", "content": "You are ShellGPT"}, {"role": "user", "content": "hi"}, {"role": "assistant", "content": "hello"}]`))
+	require.NoError(t, err)
+	assert.Equal(t, []Message{{Role: "system", Content: "You are ShellGPT"}, {Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}}, messages)
+
+	_, err = ParseShellGPT([]byte(`{"messages": [], "model": "gpt-4o"}`))
+	assert.ErrorContains(t, err, "not a shell_gpt chat")
+	_, err = ParseShellGPT([]byte(`[]`))
+	assert.ErrorContains(t, err, "no messages")
+	_, err = ParseShellGPT([]byte(`[{"role": "tool", "content": "{}"}]`))
+	assert.ErrorContains(t, err, `unknown role "tool"`)
+}
@@ -0,0 +1,262 @@
+// Package chatsearch finds messages in the stored chats. The parsed messages are kept in
+// a cache that is updated incrementally from the chat cache and can be saved to disk.
+package chatsearch
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hirosassa/sgpt/chatlog"
+)
+
+const (
+	// phraseBonus is added to the score of messages containing the whole query.
+	phraseBonus = 3.0
+	// recencyHalfLife is the age at which the recency bonus of a chat is halved.
+	recencyHalfLife = 30 * 24 * time.Hour
+	// snippetRadius is the number of characters shown on each side of a match.
+	snippetRadius = 60
+)
+
+// Chat identifies a stored chat and its version.
+type Chat struct {
+	ID      string
+	ModTime time.Time
+	Size    int64
+}
+
+// Cache holds the parsed messages of the stored chats, so that unchanged chats are not
+// read again. It is not an index: Search scans every cached message.
+type Cache struct {
+	Chats map[string]*CachedChat `json:"chats"`
+}
+
+// CachedChat is a chat as of its modification time and size.
+type CachedChat struct {
+	ModTime  time.Time         `json:"mod_time"`
+	Size     int64             `json:"size"`
+	Messages []chatlog.Message `json:"messages"`
+}
+
+// Query selects and ranks messages.
+type Query struct {
+	// Text is the words every message must contain, in any order and case. The last
+	// letters of a word may be left out, e.g. "rebas" finds "rebase" and "rebasing".
+	Text string
+	// Role, when set, selects messages of this role only.
+	Role string
+	// Since, when set, selects chats continued since then only.
+	Since time.Time
+	// Now is the time the recency is computed from.
+	Now time.Time
+}
+
+// Result is a message matching a query.
+type Result struct {
+	ChatID string
+	// Turn is the number of the prompt the message belongs to, starting at 1.
+	Turn    int
+	Role    string
+	Snippet string
+	ModTime time.Time
+	Score   float64
+}
+
+// NewCache returns an empty cache.
+func NewCache() *Cache {
+	return &Cache{Chats: map[string]*CachedChat{}}
+}
+
+// LoadCache reads the cache saved at path. A missing or unreadable cache is empty,
+// so that it is filled again.
+func LoadCache(path string) (*Cache, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return NewCache(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := NewCache()
+	if err := json.Unmarshal(data, c); err != nil || c.Chats == nil {
+		return NewCache(), nil
+	}
+	return c, nil
+}
+
+// Save writes the cache to path, readable only by the user like the chats.
+func (c *Cache) Save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Update brings the cache in line with the chats, loading only the chats that changed
+// since they were cached. Chats that cannot be loaded are left out. It reports whether
+// the cache changed.
+func (c *Cache) Update(chats []Chat, load func(id string) ([]chatlog.Message, error)) bool {
+	changed := false
+	current := make(map[string]bool, len(chats))
+	for _, chat := range chats {
+		current[chat.ID] = true
+		if cached, ok := c.Chats[chat.ID]; ok && cached.ModTime.Equal(chat.ModTime) && cached.Size == chat.Size {
+			continue
+		}
+		messages, err := load(chat.ID)
+		if err != nil {
+			// e.g. a shell_gpt chat not imported yet
+			if _, ok := c.Chats[chat.ID]; ok {
+				delete(c.Chats, chat.ID)
+				changed = true
+			}
+			continue
+		}
+		c.Chats[chat.ID] = &CachedChat{ModTime: chat.ModTime, Size: chat.Size, Messages: messages}
+		changed = true
+	}
+	for id := range c.Chats {
+		if !current[id] {
+			delete(c.Chats, id)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Search returns the messages containing every word of the query, best first. Messages
+// score by the number of matches, containing the whole query as a phrase, and the
+// recency of their chat.
+func (c *Cache) Search(q Query) []Result {
+	terms := words(q.Text)
+	if len(terms) == 0 {
+		return nil
+	}
+	phrase := " " + strings.Join(terms, " ")
+
+	var results []Result
+	for id, chat := range c.Chats {
+		if !q.Since.IsZero() && chat.ModTime.Before(q.Since) {
+			continue
+		}
+		recency := math.Exp2(-float64(q.Now.Sub(chat.ModTime)) / float64(recencyHalfLife))
+		turn := 0
+		for _, m := range chat.Messages {
+			switch m.Role {
+			case "user":
+				turn++
+			}
+			if q.Role != "" && m.Role != q.Role {
+				continue
+			}
+			normalized := " " + strings.Join(words(m.Content), " ")
+			score, ok := termScore(normalized, terms)
+			if !ok {
+				continue
+			}
+			if len(terms) > 1 && strings.Contains(normalized, phrase) {
+				score += phraseBonus
+			}
+			results = append(results, Result{
+				ChatID:  id,
+				Turn:    messageTurn(m.Role, turn),
+				Role:    m.Role,
+				Snippet: snippet(m.Content, terms),
+				ModTime: chat.ModTime,
+				Score:   score + recency,
+			})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].ChatID != results[j].ChatID {
+			return results[i].ChatID < results[j].ChatID
+		}
+		return results[i].Turn < results[j].Turn
+	})
+	return results
+}
+
+// messageTurn returns the turn of a message given the number of prompts seen so far.
+// System messages are sent before the prompt of their turn.
+func messageTurn(role string, prompts int) int {
+	if role == "system" || prompts == 0 {
+		return prompts + 1
+	}
+	return prompts
+}
+
+// termScore scores a normalized text by the words starting with every term, and
+// reports whether every term matches.
+func termScore(text string, terms []string) (float64, bool) {
+	score := 0.0
+	for _, t := range terms {
+		n := strings.Count(text, " "+t)
+		if n == 0 {
+			return 0, false
+		}
+		score += 1 + math.Log(float64(n))
+	}
+	return score, true
+}
+
+// words returns the lowercase words of s.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !isWord(r) })
+}
+
+func isWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// snippet returns the text around the first word starting with a term, on a single line.
+func snippet(content string, terms []string) string {
+	text := strings.Join(strings.Fields(content), " ")
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	prefixes := make([][]rune, len(terms))
+	for i, t := range terms {
+		prefixes[i] = []rune(t)
+	}
+
+	at := 0
+search:
+	for i := range lower {
+		if !isWord(lower[i]) || i > 0 && isWord(lower[i-1]) {
+			continue
+		}
+		for _, p := range prefixes {
+			if len(lower)-i >= len(p) && slices.Equal(lower[i:i+len(p)], p) {
+				at = i
+				break search
+			}
+		}
+	}
+	start, end := max(0, at-snippetRadius), min(len(runes), at+snippetRadius)
+	s := string(runes[start:end])
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(runes) {
+		s += "…"
+	}
+	return s
+}
@@ -0,0 +1,123 @@
+package chatsearch
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hirosassa/sgpt/chatlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+var stored = map[string][]chatlog.Message{
+	"old": {
+		{Role: "user", Content: "How do I rebase a branch?"},
+		{Role: "assistant", Content: "Run git rebase main on the branch."},
+	},
+	"work": {
+		{Role: "user", Content: "What is Go?"},
+		{Role: "assistant", Content: "A language."},
+		{Role: "system", Content: "You are ShellGPT"},
+		{Role: "user", Content: "How do I squash commits?"},
+		{Role: "assistant", Content: "Use an interactive rebase with git and mark the commits with squash."},
+	},
+}
+
+func load(id string) ([]chatlog.Message, error) {
+	messages, ok := stored[id]
+	if !ok {
+		return nil, errors.New("not a chat")
+	}
+	return messages, nil
+}
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+	cache := NewCache()
+	require.True(t, cache.Update([]Chat{
+		{ID: "old", ModTime: now.AddDate(0, -6, 0)},
+		{ID: "work", ModTime: now.AddDate(0, 0, -1)},
+		{ID: "notes.txt", ModTime: now},
+	}, load))
+	assert.NotContains(t, cache.Chats, "notes.txt")
+
+	results := cache.Search(Query{Text: "Rebasing", Now: now})
+	assert.Empty(t, results)
+	results = cache.Search(Query{Text: "rebas", Now: now})
+	require.Len(t, results, 3)
+	assert.Equal(t, "work", results[0].ChatID, "the recent chat ranks first")
+	assert.Equal(t, 2, results[0].Turn)
+	assert.Equal(t, "assistant", results[0].Role)
+	assert.Equal(t, "Use an interactive rebase with git and mark the commits with squash.", results[0].Snippet)
+
+	// Matching the phrase outweighs the recency.
+	results = cache.Search(Query{Text: "Git rebase", Now: now})
+	require.Len(t, results, 2)
+	assert.Equal(t, Result{ChatID: "old", Turn: 1, Role: "assistant", Snippet: "Run git rebase main on the branch.", ModTime: results[0].ModTime, Score: results[0].Score}, results[0])
+	assert.Equal(t, "work", results[1].ChatID)
+	assert.Len(t, cache.Search(Query{Text: "lang", Now: now}), 1)
+	assert.Empty(t, cache.Search(Query{Text: "uage", Now: now}), "terms match the start of words")
+
+	results = cache.Search(Query{Text: "squash", Role: "user", Now: now})
+	require.Len(t, results, 1)
+	assert.Equal(t, "How do I squash commits?", results[0].Snippet)
+	results = cache.Search(Query{Text: "shellgpt", Now: now})
+	require.Len(t, results, 1)
+	assert.Equal(t, 2, results[0].Turn, "the system message belongs to the next prompt")
+
+	assert.Empty(t, cache.Search(Query{Text: "rebase", Since: now.AddDate(0, 0, -7), Role: "user", Now: now}))
+	assert.Empty(t, cache.Search(Query{Text: "rebase python", Now: now}))
+	assert.Empty(t, cache.Search(Query{Text: " ?! ", Now: now}))
+}
+
+func TestSnippet(t *testing.T) {
+	t.Parallel()
+	long := strings.Repeat("lorem ipsum ", 20) + "needle\nin a\thaystack " + strings.Repeat("dolor sit ", 20)
+	s := snippet(long, []string{"needle"})
+	assert.True(t, strings.HasPrefix(s, "…"))
+	assert.True(t, strings.HasSuffix(s, "…"))
+	assert.Contains(t, s, "needle in a haystack")
+	assert.Equal(t, "résumé of the café", snippet("résumé of the\ncafé", []string{"café"}))
+	assert.Equal(t, "a branch and a tree", snippet("a branch and a tree", []string{"tree", "and"}))
+	assert.Equal(t, "a go", snippet("a go", []string{"golang"}), "a term longer than the rest of the text")
+}
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+	loads := 0
+	counting := func(id string) ([]chatlog.Message, error) {
+		loads++
+		return load(id)
+	}
+	chats := []Chat{{ID: "old", ModTime: now, Size: 10}, {ID: "work", ModTime: now, Size: 20}}
+
+	cache := NewCache()
+	assert.True(t, cache.Update(chats, counting))
+	assert.False(t, cache.Update(chats, counting))
+	assert.Equal(t, 2, loads, "unchanged chats are not read again")
+
+	chats[1].Size = 30
+	assert.True(t, cache.Update(chats[1:], counting))
+	assert.Equal(t, 3, loads)
+	assert.NotContains(t, cache.Chats, "old", "removed chats are dropped")
+}
+
+func TestSaveLoad(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "shell_gpt", "chat_search_cache.json")
+	cache, err := LoadCache(path)
+	require.NoError(t, err)
+	assert.Empty(t, cache.Chats)
+
+	cache.Update([]Chat{{ID: "work", ModTime: now, Size: 20}}, load)
+	require.NoError(t, cache.Save(path))
+	loaded, err := LoadCache(path)
+	require.NoError(t, err)
+	assert.Equal(t, cache.Chats["work"].Messages, loaded.Chats["work"].Messages)
+	assert.True(t, loaded.Chats["work"].ModTime.Equal(now))
+}
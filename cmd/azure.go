@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hirosassa/sgpt/handler"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	azureAuthAPIKey = "api-key"
+	azureAuthBearer = "bearer"
+)
+
+// azureOptions sets the Azure resource and deployment of the flags on opts.
+// --model names the deployment and defaults to --azure-deployment.
+func azureOptions(cmd *cli.Command, opts handler.Options) (handler.Options, error) {
+	endpoint := cmd.String("azure-endpoint")
+	if endpoint == "" {
+		return opts, errors.New("azure needs the endpoint of the resource: pass --azure-endpoint or set AZURE_OPENAI_ENDPOINT")
+	}
+	if opts.Model == "" {
+		opts.Model = cmd.String("azure-deployment")
+	}
+	if opts.Model == "" {
+		return opts, errors.New("azure needs a deployment: pass --model or --azure-deployment, or set AZURE_OPENAI_DEPLOYMENT")
+	}
+
+	var bearer bool
+	switch auth := cmd.String("azure-auth"); auth {
+	case azureAuthAPIKey:
+	case azureAuthBearer:
+		bearer = true
+	default:
+		return opts, fmt.Errorf("unknown azure authentication %q, must be one of: %s, %s", auth, azureAuthAPIKey, azureAuthBearer)
+	}
+	opts.Azure = handler.Azure{
+		Endpoint:   endpoint,
+		APIVersion: cmd.String("azure-api-version"),
+		Bearer:     bearer,
+	}
+	return opts, nil
+}
@@ -0,0 +1,347 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hirosassa/sgpt/batch"
+	"github.com/hirosassa/sgpt/handler"
+	sgptrole "github.com/hirosassa/sgpt/role"
+	"github.com/urfave/cli/v3"
+)
+
+func newBatchCmd() *cli.Command {
+	return &cli.Command{
+		Name: "batch",
+		Usage: "Answer the prompts of a JSONL file, one {\"id\", \"prompt\", \"role\", \"model\", \"vars\"} per line, " +
+			"and print the answers as JSONL.",
+		ArgsUsage: "FILE",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:      "out",
+				Usage:     "Append the answers to FILE instead of printing them, skipping the prompts it already answers.",
+				TakesFile: true,
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "Number of prompts answered at the same time.",
+				Value: 4,
+			},
+			&cli.IntFlag{
+				Name:  "rate",
+				Usage: "Maximum number of requests per minute; no limit by default.",
+			},
+			&cli.BoolFlag{
+				Name:  "openai-batch",
+				Usage: "Send the prompts through the OpenAI Batch API, which is cheaper but answers within 24 hours.",
+			},
+			&cli.StringFlag{
+				Name:  "batch-id",
+				Usage: "Wait for the results of a batch submitted earlier with --openai-batch instead of submitting one.",
+			},
+			&cli.DurationFlag{
+				Name:  "poll",
+				Usage: "Interval at which --openai-batch checks for the results.",
+				Value: 30 * time.Second,
+			},
+		},
+		Action: runBatch,
+	}
+}
+
+func runBatch(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() != 1 {
+		return errors.New("usage: sgpt batch FILE")
+	}
+	if cmd.String("chat") != "" {
+		return errors.New("--chat cannot be combined with batch, the prompts are answered independently")
+	}
+	f, err := os.Open(cmd.Args().First())
+	if err != nil {
+		return err
+	}
+	items, err := batch.Read(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", cmd.Args().First(), err)
+	}
+
+	var w io.Writer = cmd.Root().Writer
+	if path := cmd.String("out"); path != "" {
+		out, done, err := batch.OpenOutput(path)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		w = out
+		pending := items[:0]
+		for _, item := range items {
+			if !done[item.ID] {
+				pending = append(pending, item)
+			}
+		}
+		if skipped := len(items) - len(pending); skipped > 0 {
+			fmt.Fprintf(cmd.Root().ErrWriter, "skipped %d prompts answered in %s\n", skipped, path)
+		}
+		items = pending
+	}
+	enc := json.NewEncoder(w)
+	emit := func(r batch.Result) error { return enc.Encode(r) }
+
+	var stats batch.Stats
+	if cmd.Bool("openai-batch") || cmd.String("batch-id") != "" {
+		stats, err = runOpenAIBatch(ctx, cmd, items, emit)
+	} else {
+		stats, err = batch.Run(ctx, items, batch.Config{
+			Concurrency: int(cmd.Int("concurrency")),
+			Rate:        int(cmd.Int("rate")),
+		}, batchAnswerer(cmd), emit)
+		if errors.Is(err, context.Canceled) {
+			return fmt.Errorf("interrupted after %d prompts; run again with --out to resume", stats.Answered+stats.Failed)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.Root().ErrWriter, "answered %d prompts, %d failed\n", stats.Answered, stats.Failed)
+	if stats.Failed > 0 {
+		return fmt.Errorf("%d of %d prompts failed; run again with --out to retry them", stats.Failed, len(items))
+	}
+	return nil
+}
+
+// batchAnswerer returns a function answering an item like sgpt would answer its prompt
+// with the flags of the command. The API key and the HTTP client, with its --record
+// cassette, are set up once for all items, and the handler is built once and shared by
+// the workers; items overriding the role or model get a handler of their own.
+func batchAnswerer(cmd *cli.Command) func(context.Context, batch.Item) (*handler.Response, error) {
+	var once sync.Once
+	var key string
+	var client *http.Client
+	var setupErr error
+	var mu sync.Mutex
+	handlers := map[batchTarget]handler.Handler{}
+	completePrompt := batchPrompter(cmd)
+	return func(ctx context.Context, item batch.Item) (*handler.Response, error) {
+		once.Do(func() {
+			key, setupErr = apiKey(ctx, cmd, cmd.String("platform"))
+			if setupErr == nil {
+				client, setupErr = httpClient(cmd, key)
+			}
+		})
+		if setupErr != nil {
+			return nil, setupErr
+		}
+		opts, err := batchOptions(cmd, item)
+		if err != nil {
+			return nil, err
+		}
+		opts.APIKey, opts.HTTPClient = key, client
+		prompt, err := completePrompt(ctx, item)
+		if err != nil {
+			return nil, err
+		}
+
+		target := batchTarget{role: opts.Role.Name, content: opts.Role.Role, model: opts.Model}
+		mu.Lock()
+		h, ok := handlers[target]
+		if !ok {
+			h, err = newHandler(ctx, cmd, opts)
+			if err == nil {
+				handlers[target] = h
+			}
+		}
+		mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		res, err := h.Handle(ctx, cmd, prompt)
+		if err != nil {
+			return nil, err
+		}
+		if opts.JSON || opts.Schema != nil {
+			return conform(ctx, cmd, h, opts.Schema, prompt, res)
+		}
+		return res, nil
+	}
+}
+
+// batchTarget identifies the options of the items a handler answers: the role, as
+// rendered with the variables of the item, and the model.
+type batchTarget struct {
+	role, content, model string
+}
+
+// batchPrompter returns a function completing the prompt of an item like readPrompt
+// completes the prompt of the arguments: rendered with --template and the variables of
+// the item over --var, after the passages of --rag and the --context sections. The
+// sections are built once for all items.
+func batchPrompter(cmd *cli.Command) func(context.Context, batch.Item) (string, error) {
+	var once sync.Once
+	var sections string
+	var sectionsErr error
+	return func(ctx context.Context, item batch.Item) (string, error) {
+		prompt := item.Prompt
+		var err error
+		if cmd.String("template") != "" {
+			prompt, err = renderTemplate(cmd, item.Prompt, "", item.Vars)
+			if err != nil {
+				return "", err
+			}
+		}
+		if cmd.String("rag") != "" {
+			prompt, err = withRAG(ctx, cmd, prompt)
+			if err != nil {
+				return "", err
+			}
+		}
+		if names := cmd.StringSlice("context"); len(names) > 0 {
+			once.Do(func() { sections, sectionsErr = withContext(ctx, cmd, names, "") })
+			if sectionsErr != nil {
+				return "", sectionsErr
+			}
+			prompt = sections + prompt
+		}
+		return prompt, nil
+	}
+}
+
+// batchOptions returns the options of the command flags with the role, model and
+// variables of the item.
+func batchOptions(cmd *cli.Command, item batch.Item) (handler.Options, error) {
+	variables := map[string]string{}
+	if name := cmd.String("shell-name"); name != "" {
+		variables["Shell"] = name
+	}
+	for k, v := range cmd.StringMap("var") {
+		variables[k] = v
+	}
+	for k, v := range item.Vars {
+		variables[k] = v
+	}
+	shell, describe, code := cmd.Bool("shell"), cmd.Bool("describe-shell"), cmd.Bool("code")
+	if item.Role != "" {
+		shell, describe, code = item.Role == "shell", item.Role == "describe-shell", item.Role == "code"
+	}
+	role, err := sgptrole.CheckGet(shell, describe, code, variables)
+	if err != nil {
+		return handler.Options{}, err
+	}
+	opts, err := newOptions(cmd, role)
+	if err != nil {
+		return handler.Options{}, err
+	}
+	if opts.N > 1 {
+		return handler.Options{}, errors.New("--n cannot be combined with batch")
+	}
+	if item.Model != "" {
+		opts.Model = item.Model
+	}
+	return opts, nil
+}
+
+// runOpenAIBatch submits the items as an OpenAI batch, or resumes the batch of
+// --batch-id, and emits the results once the batch has ended.
+func runOpenAIBatch(ctx context.Context, cmd *cli.Command, items []batch.Item, emit func(batch.Result) error) (batch.Stats, error) {
+	if platform := cmd.String("platform"); platform != handler.ProviderOpenAI {
+		return batch.Stats{}, fmt.Errorf("--openai-batch needs the openai platform, not %s", platform)
+	}
+	key, err := apiKey(ctx, cmd, handler.ProviderOpenAI)
+	if err != nil {
+		return batch.Stats{}, err
+	}
+	client, err := httpClient(cmd, key)
+	if err != nil {
+		return batch.Stats{}, err
+	}
+	b, err := handler.NewOpenAIBatch(handler.Options{APIKey: key, HTTPClient: client})
+	if err != nil {
+		return batch.Stats{}, err
+	}
+
+	roles := map[string]string{}
+	requests := make([][]byte, 0, len(items))
+	completePrompt := batchPrompter(cmd)
+	redactor, err := newRedactor(cmd)
+	if err != nil {
+		return batch.Stats{}, err
+	}
+	for _, item := range items {
+		opts, err := batchOptions(cmd, item)
+		if err != nil {
+			return batch.Stats{}, fmt.Errorf("%s: %w", item.ID, err)
+		}
+		opts.APIKey = key
+		h, err := handler.NewDefaultHandler(cmd, opts)
+		if err != nil {
+			return batch.Stats{}, fmt.Errorf("%s: %w", item.ID, err)
+		}
+		prompt, err := completePrompt(ctx, item)
+		if err != nil {
+			return batch.Stats{}, fmt.Errorf("%s: %w", item.ID, err)
+		}
+		if redactor != nil {
+			prompt = redactor.Redact(prompt)
+		}
+		request, err := h.BatchRequest(item.ID, prompt)
+		if err != nil {
+			return batch.Stats{}, err
+		}
+		requests = append(requests, request)
+		roles[item.ID] = opts.Role.Name
+	}
+
+	w := cmd.Root().ErrWriter
+	id := cmd.String("batch-id")
+	if id == "" {
+		if len(requests) == 0 {
+			return batch.Stats{}, nil
+		}
+		id, err = b.Submit(ctx, requests)
+		if err != nil {
+			return batch.Stats{}, err
+		}
+		fmt.Fprintf(w, "submitted batch %s; if interrupted, wait for it with --batch-id %s\n", id, id)
+	}
+	last := ""
+	responses, errs, err := b.Wait(ctx, id, cmd.Duration("poll"), func(status string, done, total int64) {
+		if progress := fmt.Sprintf("batch %s: %s, %d/%d requests done", id, status, done, total); progress != last {
+			fmt.Fprintln(w, progress)
+			last = progress
+		}
+	})
+	if err != nil {
+		return batch.Stats{}, fmt.Errorf("failed to wait for batch %s, wait again with --batch-id %s: %w", id, id, err)
+	}
+
+	var stats batch.Stats
+	for _, item := range items {
+		result := batch.Result{ID: item.ID, Response: responses[item.ID]}
+		switch {
+		case result.Response != nil:
+			result.Response.Role = roles[item.ID]
+			if redactor != nil {
+				handler.RestoreResponse(result.Response, redactor.Restore)
+			}
+			stats.Answered++
+		case errs[item.ID] != nil:
+			result.Error = errs[item.ID].Error()
+			stats.Failed++
+		default:
+			// e.g. an expired batch, or an item not part of --batch-id
+			result.Error = "no result in batch " + id
+			stats.Failed++
+		}
+		if err := emit(result); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
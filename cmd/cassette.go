@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/hirosassa/sgpt/cassette"
+	"github.com/hirosassa/sgpt/transport"
+	"github.com/urfave/cli/v3"
+)
+
+// httpClient returns the HTTP client for the provider configured by the network flags,
+// recording or replaying its traffic as selected by --record and --replay, or nil to use
+// the default client of the provider.
+func httpClient(cmd *cli.Command, apiKey string) (*http.Client, error) {
+	config := transportConfig(cmd)
+	record, replay := cmd.String("record"), cmd.String("replay")
+	switch {
+	case record != "" && replay != "":
+		return nil, errors.New("--record cannot be combined with --replay")
+	case replay != "":
+		t, err := cassette.NewReplayer(replay)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{Transport: t}, nil
+	case record != "":
+		base, err := config.RoundTripper()
+		if err != nil {
+			return nil, err
+		}
+		t, err := cassette.NewRecorder(record, base, apiKey)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{Transport: t, Timeout: config.Timeout}, nil
+	case !config.IsZero():
+		return config.Client()
+	default:
+		return nil, nil
+	}
+}
+
+// transportConfig returns the proxy, TLS, header and timeout settings of the flags.
+func transportConfig(cmd *cli.Command) transport.Config {
+	config := transport.Config{
+		CAFile:   cmd.String("ca-bundle"),
+		CertFile: cmd.String("client-cert"),
+		KeyFile:  cmd.String("client-key"),
+		Timeout:  cmd.Duration("timeout"),
+	}
+	if headers := cmd.StringMap("header"); len(headers) > 0 {
+		config.Header = http.Header{}
+		for name, value := range headers {
+			config.Header.Set(name, value)
+		}
+	}
+	return config
+}
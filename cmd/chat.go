@@ -0,0 +1,369 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hirosassa/sgpt/chatlog"
+	"github.com/hirosassa/sgpt/chatsearch"
+	"github.com/hirosassa/sgpt/handler"
+	"github.com/urfave/cli/v3"
+)
+
+func newChatCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "chat",
+		Usage: "Manage the chats stored in ~/.config/shell_gpt/chat_cache.",
+		Commands: []*cli.Command{
+			{
+				Name:      "export",
+				Usage:     "Print a chat for sharing.",
+				ArgsUsage: "ID",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:      "format",
+						Usage:     "Output format, one of: " + strings.Join(chatlog.Formats, ", "),
+						Value:     "md",
+						Validator: chatlog.ValidateFormat,
+					},
+				},
+				Action: exportChat,
+			},
+			{
+				Name:      "import",
+				Usage:     "Import the chats of shell_gpt (Python), all of them or the given ones.",
+				ArgsUsage: "[ID...]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:      "from",
+						Usage:     "Directory of the shell_gpt chats, e.g. a copy of its chat cache.",
+						TakesFile: true,
+						Required:  true,
+					},
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "Replace sgpt chats with the same ID.",
+					},
+				},
+				Action: importChats,
+			},
+			{
+				Name:      "search",
+				Usage:     "Find messages in the stored chats, best matches first.",
+				ArgsUsage: "QUERY",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "role",
+						Usage: "Only search the messages of this role, one of: system, user, assistant",
+						Validator: func(role string) error {
+							switch role {
+							case "system", "user", "assistant":
+								return nil
+							}
+							return fmt.Errorf("unknown role %q, use one of: system, user, assistant", role)
+						},
+					},
+					&cli.StringFlag{
+						Name:  "since",
+						Usage: "Only search the chats continued since a date (2006-01-02) or for a duration (12h, 7d).",
+					},
+					&cli.IntFlag{
+						Name:  "limit",
+						Usage: "Maximum number of matches to print; 0 prints all of them.",
+						Value: 20,
+					},
+					&cli.BoolFlag{
+						Name:  "cache",
+						Usage: "Keep the parsed chats in ~/.config/shell_gpt/chat_search_cache.json, so only changed chats are read again.",
+					},
+				},
+				Action: searchChats,
+			},
+		},
+	}
+}
+
+func exportChat(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() != 1 {
+		return errors.New("usage: sgpt chat export ID")
+	}
+	chatID := cmd.Args().First()
+	turns, err := handler.ChatTurns(chatID)
+	if err != nil {
+		return err
+	}
+	chat := &chatlog.Chat{ID: chatID}
+	for _, t := range turns {
+		chat.Messages = append(chat.Messages, chatlog.Message{Role: t.Role, Content: t.Content})
+	}
+	return chatlog.Write(cmd.Root().Writer, cmd.String("format"), chat)
+}
+
+// importChats converts shell_gpt chat cache files into sgpt chats. Without arguments,
+// every shell_gpt chat of the directory is imported and other files are skipped. The
+// chats are read from a directory other than the sgpt chat cache, which shell_gpt
+// shares: converting its chats in place would leave shell_gpt unable to read them.
+func importChats(ctx context.Context, cmd *cli.Command) error {
+	from := cmd.String("from")
+	if same, err := sameDir(from, handler.ChatCachePath()); err != nil {
+		return err
+	} else if same {
+		return errors.New("--from must not be the sgpt chat cache, whose chats shell_gpt would no longer read; import from a copy of it")
+	}
+	ids := cmd.Args().Slice()
+	all := len(ids) == 0
+	if all {
+		entries, err := os.ReadDir(from)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				ids = append(ids, e.Name())
+			}
+		}
+	}
+
+	w := cmd.Root().ErrWriter
+	imported := 0
+	for _, id := range ids {
+		data, err := os.ReadFile(filepath.Join(from, id))
+		if err != nil {
+			return err
+		}
+		messages, err := chatlog.ParseShellGPT(data)
+		if err != nil {
+			if all {
+				// e.g. a chat written by sgpt
+				continue
+			}
+			return fmt.Errorf("failed to import chat %s: %w", id, err)
+		}
+		// a chat of shell_gpt in the shared cache is kept as well
+		if _, err := os.Stat(filepath.Join(handler.ChatCachePath(), id)); err == nil && !cmd.Bool("force") {
+			fmt.Fprintf(w, "skipped chat %s: it already exists, use --force to replace it\n", id)
+			continue
+		}
+
+		turns := make([]handler.Turn, 0, len(messages))
+		for _, m := range messages {
+			turns = append(turns, handler.Turn{Role: m.Role, Content: m.Content})
+		}
+		if err := handler.SaveChat(id, turns); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "imported chat %s (%d messages)\n", id, len(turns))
+		imported++
+	}
+	_, err := fmt.Fprintf(w, "imported %d chats\n", imported)
+	return err
+}
+
+// sameDir reports whether the directories a and b are the same; b may not exist.
+func sameDir(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(infoA, infoB), nil
+}
+
+func searchChats(ctx context.Context, cmd *cli.Command) error {
+	query := strings.Join(cmd.Args().Slice(), " ")
+	if strings.TrimSpace(query) == "" {
+		return errors.New("usage: sgpt chat search QUERY")
+	}
+	now := time.Now()
+	since, err := parseSince(cmd.String("since"), now)
+	if err != nil {
+		return err
+	}
+
+	chats, err := handler.ListChats()
+	if err != nil {
+		return err
+	}
+	cache := chatsearch.NewCache()
+	if cmd.Bool("cache") {
+		if cache, err = chatsearch.LoadCache(chatSearchCachePath()); err != nil {
+			return err
+		}
+	}
+	stored := make([]chatsearch.Chat, 0, len(chats))
+	for _, c := range chats {
+		stored = append(stored, chatsearch.Chat{ID: c.ID, ModTime: c.ModTime, Size: c.Size})
+	}
+	changed := cache.Update(stored, func(id string) ([]chatlog.Message, error) {
+		turns, err := handler.ChatTurns(id)
+		if err != nil {
+			return nil, err
+		}
+		messages := make([]chatlog.Message, 0, len(turns))
+		for _, t := range turns {
+			messages = append(messages, chatlog.Message{Role: t.Role, Content: t.Content})
+		}
+		return messages, nil
+	})
+	if changed && cmd.Bool("cache") {
+		if err := cache.Save(chatSearchCachePath()); err != nil {
+			return err
+		}
+	}
+
+	results := cache.Search(chatsearch.Query{Text: query, Role: cmd.String("role"), Since: since, Now: now})
+	if len(results) == 0 {
+		return fmt.Errorf("no messages match %q", query)
+	}
+	if limit := cmd.Int("limit"); limit > 0 && len(results) > int(limit) {
+		results = results[:limit]
+	}
+	w := cmd.Root().Writer
+	for _, r := range results {
+		if _, err := fmt.Fprintf(w, "%s#%d [%s] %s  %s\n", r.ChatID, r.Turn, r.Role, r.ModTime.Format(time.DateOnly), r.Snippet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func chatSearchCachePath() string {
+	return os.ExpandEnv("$HOME/.config/shell_gpt/chat_search_cache.json")
+}
+
+// parseSince parses a date such as 2006-01-02 or a duration before now such as 12h or 7d.
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, since, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(since, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(since); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q, use a date such as 2006-01-02 or a duration such as 12h or 7d", since)
+}
+
+// forkChat copies the chat of the first argument to the chat of the second argument.
+func forkChat(cmd *cli.Command) error {
+	if cmd.Args().Len() != 2 {
+		return errors.New("--fork needs the chat to copy and the new chat, e.g. sgpt --fork work work-alt")
+	}
+	src, dst := cmd.Args().Get(0), cmd.Args().Get(1)
+	if err := handler.ForkChat(src, dst); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(cmd.Root().ErrWriter, "forked chat %s to %s\n", src, dst)
+	return err
+}
+
+// undoChat removes the last prompt and answer of --chat.
+func undoChat(cmd *cli.Command) error {
+	chatID, err := chatToChange(cmd, "undo")
+	if err != nil {
+		return err
+	}
+	prompt, err := handler.UndoChat(chatID)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(cmd.Root().ErrWriter, "removed the last turn of chat %s: %s\n", chatID, firstLine(prompt))
+	return err
+}
+
+// resendPrompt returns the last prompt of --chat, edited in $EDITOR with --edit-last. The
+// answer to it replaces the last turn, which is kept when no answer is stored.
+func resendPrompt(ctx context.Context, cmd *cli.Command) (string, error) {
+	flag := "regenerate"
+	if cmd.Bool("edit-last") {
+		flag = "edit-last"
+	}
+	chatID, err := chatToChange(cmd, flag)
+	if err != nil {
+		return "", err
+	}
+	prompt, err := handler.LastChatPrompt(chatID)
+	if err != nil {
+		return "", err
+	}
+	if cmd.Bool("edit-last") {
+		prompt, err = editText(ctx, prompt)
+		if err != nil {
+			return "", err
+		}
+		if strings.TrimSpace(prompt) == "" {
+			return "", errors.New("the edited prompt is empty, the chat was left unchanged")
+		}
+	}
+	return prompt, nil
+}
+
+// chatToChange returns the chat given with --chat for the history changing flag.
+func chatToChange(cmd *cli.Command, flag string) (string, error) {
+	chatID := cmd.String("chat")
+	if chatID == "" {
+		return "", fmt.Errorf("--%s needs the chat to change with --chat", flag)
+	}
+	if platform := cmd.String("platform"); !keepsChats(platform) {
+		return "", fmt.Errorf("--%s cannot be used with %s, which keeps no chats", flag, platform)
+	}
+	return chatID, nil
+}
+
+// editText lets the user edit text in $EDITOR, vi by default, and returns the result.
+func editText(ctx context.Context, text string) (string, error) {
+	f, err := os.CreateTemp("", "sgpt-prompt-*.md")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(text); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	// The editor may come with arguments, e.g. "code --wait".
+	c := exec.CommandContext(ctx, "sh", "-c", editor+` "$1"`, "sh", f.Name())
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return "", fmt.Errorf("editor %q failed: %w", editor, err)
+	}
+	edited, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(edited)), nil
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/hirosassa/sgpt/gitcommit"
+	sgptrole "github.com/hirosassa/sgpt/role"
+	"github.com/urfave/cli/v3"
+)
+
+func newCommitCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "commit",
+		Usage: "Generate a commit message for the staged changes, edit it and commit.",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "conventional",
+				Usage: "Use the Conventional Commits format.",
+			},
+			&cli.BoolFlag{
+				Name:  "no-edit",
+				Usage: "Commit without opening the message in the editor.",
+			},
+			&cli.StringFlag{
+				Name:      "write",
+				Usage:     "Prepend the message to FILE instead of committing; used by the prepare-commit-msg hook.",
+				TakesFile: true,
+			},
+			&cli.IntFlag{
+				Name:  "chunk-size",
+				Usage: "Diff size in bytes above which the diff is summarized in parts first.",
+				Value: gitcommit.DefaultChunkSize,
+			},
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "install-hook",
+				Usage: "Install a prepare-commit-msg hook that fills commit messages with sgpt.",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "Replace an existing hook.",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					path, err := gitcommit.InstallHook(ctx, ".", cmd.Bool("force"))
+					if err != nil {
+						return err
+					}
+					fmt.Println("installed", path)
+					return nil
+				},
+			},
+		},
+		Action: commit,
+	}
+}
+
+func commit(ctx context.Context, cmd *cli.Command) error {
+	// the summaries of a chunked diff would end up in the chat too
+	if cmd.String("chat") != "" {
+		return errors.New("--chat cannot be used with sgpt commit")
+	}
+
+	diff, err := gitcommit.StagedDiff(ctx, ".")
+	if err != nil {
+		return err
+	}
+
+	// a dedicated role, since the default one asks for Markdown
+	role, err := sgptrole.GetCommit(cmd.Bool("conventional"), cmd.StringMap("var"))
+	if err != nil {
+		return err
+	}
+	opts, err := newOptions(cmd, role)
+	if err != nil {
+		return err
+	}
+	h, err := newHandler(ctx, cmd, opts)
+	if err != nil {
+		return err
+	}
+
+	g := &gitcommit.Generator{
+		Complete: func(ctx context.Context, prompt string) (string, error) {
+			res, err := h.Handle(ctx, cmd, prompt)
+			if err != nil {
+				return "", err
+			}
+			return res.Content, nil
+		},
+		Variables: cmd.StringMap("var"),
+		ChunkSize: cmd.Int("chunk-size"),
+	}
+	if name := cmd.String("template"); name != "" {
+		g.Template, err = templateLibrary().Get(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	message, err := g.Generate(ctx, diff)
+	if err != nil {
+		return err
+	}
+
+	if path := cmd.String("write"); path != "" {
+		return gitcommit.WriteMessage(path, message)
+	}
+	edit := !cmd.Bool("no-edit")
+	if edit && !isTerminal(os.Stdin) {
+		fmt.Fprintln(cmd.Root().ErrWriter, "stdin is not a terminal, committing without opening the editor")
+		edit = false
+	}
+	return gitcommit.Commit(ctx, ".", message, edit)
+}
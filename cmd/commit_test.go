@@ -0,0 +1,14 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitRejectsChat(t *testing.T) {
+	t.Parallel()
+	err := newCmd().Run(context.Background(), []string{"sgpt", "--chat", "work", "commit"})
+	assert.EqualError(t, err, "--chat cannot be used with sgpt commit")
+}
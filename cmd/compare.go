@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/hirosassa/sgpt/compare"
+	"github.com/hirosassa/sgpt/handler"
+	"github.com/urfave/cli/v3"
+)
+
+// Layouts of --compare.
+const (
+	compareSequential = "sequential"
+	compareSideBySide = "side-by-side"
+)
+
+// defaultColumns is the width of the side-by-side layout when $COLUMNS is not set.
+const defaultColumns = 120
+
+// runCompare answers the prompt with every model of --compare at the same time, with
+// the same role and options, and prints their answers.
+func runCompare(ctx context.Context, cmd *cli.Command, opts handler.Options, prompt string) error {
+	targets, err := compare.ParseTargets(cmd.StringSlice("compare"))
+	if err != nil {
+		return err
+	}
+	layout := cmd.String("compare-layout")
+	if layout != compareSequential && layout != compareSideBySide {
+		return fmt.Errorf("unknown layout %q, must be one of: %s, %s", layout, compareSequential, compareSideBySide)
+	}
+	if opts.N > 1 {
+		return errors.New("--n cannot be combined with --compare")
+	}
+	// the key of these flags would be sent to every platform compared
+	for _, flag := range []string{"api-key-file", "api-key-command"} {
+		if cmd.String(flag) != "" {
+			return fmt.Errorf("--%s cannot be combined with --compare; set the key of each platform, e.g. with $SGPT_<PLATFORM>_API_KEY_FILE", flag)
+		}
+	}
+
+	// looked up one after the other, since a missing key is asked for on the terminal
+	keys := map[string]string{}
+	for _, t := range targets {
+		if _, ok := keys[t.Platform]; ok {
+			continue
+		}
+		keys[t.Platform], err = apiKey(ctx, cmd, t.Platform)
+		if err != nil {
+			return err
+		}
+	}
+	results := compare.Run(ctx, targets, func(ctx context.Context, t compare.Target) (*handler.Response, error) {
+		opts := opts
+		opts.APIKey = keys[t.Platform]
+		opts.Model = t.Model
+		h, err := newPlatformHandler(ctx, cmd, t.Platform, opts)
+		if err != nil {
+			return nil, err
+		}
+		res, err := h.Handle(ctx, cmd, prompt)
+		if err != nil {
+			return nil, err
+		}
+		if opts.JSON || opts.Schema != nil {
+			return conform(ctx, cmd, h, opts.Schema, prompt, res)
+		}
+		return res, nil
+	})
+
+	if err := printComparison(cmd, layout, results); err != nil {
+		return err
+	}
+	var failed int
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d models failed", failed, len(results))
+	}
+	return nil
+}
+
+// printComparison writes the results in the --output format, laid out by layout for text.
+func printComparison(cmd *cli.Command, layout string, results []compare.Result) error {
+	w := cmd.Root().Writer
+	switch cmd.String("output") {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	case outputJSONL:
+		enc := json.NewEncoder(w)
+		for _, r := range results {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if layout == compareSideBySide {
+		width, err := strconv.Atoi(os.Getenv("COLUMNS"))
+		if err != nil || width <= 0 {
+			width = defaultColumns
+		}
+		return compare.SideBySide(w, results, width)
+	}
+	return compare.Sequential(w, results)
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/hirosassa/sgpt/contextprovider"
+	"github.com/hirosassa/sgpt/handler"
+	"github.com/urfave/cli/v3"
+)
+
+// withContext prepends the sections gathered by the named context providers to the prompt.
+func withContext(ctx context.Context, cmd *cli.Command, names []string, prompt string) (string, error) {
+	budgets := map[string]int{}
+	for name, v := range cmd.StringMap("context-budget") {
+		tokens, err := strconv.Atoi(v)
+		if err != nil || tokens <= 0 {
+			return "", fmt.Errorf("invalid context budget %s=%s, must be a positive number of tokens", name, v)
+		}
+		budgets[name] = tokens
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	sections, err := contextprovider.Build(ctx, dir, names, budgets)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range sections {
+		if s.Truncated {
+			fmt.Fprintf(os.Stderr, "warning: %s context was truncated to its budget\n", s.Name)
+		}
+	}
+	return contextprovider.Render(sections) + prompt, nil
+}
+
+// printDryRun writes the messages that would be sent to the provider, including the
+// history of --chat.
+func printDryRun(w io.Writer, cmd *cli.Command, opts handler.Options, prompt string) error {
+	turns, err := handler.Preview(cmd.String("platform"), cmd.String("chat"), opts, prompt)
+	if err != nil {
+		return err
+	}
+	for _, t := range turns {
+		if _, err := fmt.Fprintf(w, "--- %s ---\n%s\n", t.Role, t.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
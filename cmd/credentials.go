@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/hirosassa/sgpt/credential"
+	"github.com/hirosassa/sgpt/handler"
+	"github.com/urfave/cli/v3"
+)
+
+// replayAPIKey stands in for the API key when replaying, since no request is sent.
+const replayAPIKey = "replay"
+
+// configPath returns the sgpt configuration file.
+func configPath() string {
+	return os.ExpandEnv("$HOME/.config/shell_gpt/config")
+}
+
+type nonInteractiveKey struct{}
+
+// nonInteractive marks ctx as serving requests, where sgpt must never wait for terminal input.
+func nonInteractive(ctx context.Context) context.Context {
+	return context.WithValue(ctx, nonInteractiveKey{}, true)
+}
+
+func interactive(ctx context.Context) bool {
+	if v, _ := ctx.Value(nonInteractiveKey{}).(bool); v {
+		return false
+	}
+	return isTerminal(os.Stdin) && isTerminal(os.Stderr)
+}
+
+// apiKey returns the API key of the platform from, in order: --api-key-file, --api-key-command,
+// $SGPT_<PLATFORM>_API_KEY, $SGPT_<PLATFORM>_API_KEY_FILE, $SGPT_<PLATFORM>_API_KEY_COMMAND and
+// the <platform>_api_key, <platform>_api_key_file and <platform>_api_key_command settings of the
+// configuration file. When there is none, it asks for the key on a terminal and saves it.
+func apiKey(ctx context.Context, cmd *cli.Command, platform string) (string, error) {
+	switch platform {
+	case handler.ProviderMock, handler.ProviderVertex:
+		// vertex authenticates with Google Cloud credentials instead of an API key
+		return "", nil
+	}
+	env := "SGPT_" + strings.ToUpper(platform) + "_API_KEY"
+	setting := platform + "_api_key"
+	path := configPath()
+	config, err := credential.LoadConfig(path)
+	if err != nil {
+		return "", err
+	}
+
+	key, _, err := credential.Resolve(ctx,
+		credential.File("--api-key-file", cmd.String("api-key-file")),
+		credential.Command("--api-key-command", cmd.String("api-key-command")),
+		credential.Env(env),
+		credential.File("$"+env+"_FILE", os.Getenv(env+"_FILE")),
+		credential.Command("$"+env+"_COMMAND", os.Getenv(env+"_COMMAND")),
+		credential.Value(setting+" in "+path, config[setting]),
+		credential.File(setting+"_file in "+path, config[setting+"_file"]),
+		credential.Command(setting+"_command in "+path, config[setting+"_command"]),
+	)
+	if !errors.Is(err, credential.ErrNotFound) {
+		return key, err
+	}
+
+	switch {
+	case cmd.String("replay") != "":
+		return replayAPIKey, nil
+	case interactive(ctx):
+		return askAPIKey(ctx, os.Stdin, cmd.Root().ErrWriter, platform, path, setting)
+	default:
+		return "", fmt.Errorf("no api key for %s: set %s, pass --api-key-file or --api-key-command, "+
+			"or set %s, %s_file or %s_command in %s", platform, env, setting, setting, setting, path)
+	}
+}
+
+// askAPIKey asks for the API key on the terminal and saves it to the configuration file.
+func askAPIKey(ctx context.Context, in *os.File, out io.Writer, platform, path, setting string) (string, error) {
+	fmt.Fprintf(out, "No api key for %s is configured.\nEnter it to save it to %s: ", platform, path)
+	// hide the key while it is typed; if stty is missing the key is echoed
+	if err := stty(ctx, in, "-echo"); err == nil {
+		defer func() {
+			_ = stty(context.WithoutCancel(ctx), in, "echo")
+			fmt.Fprintln(out)
+		}()
+	}
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	key := strings.TrimSpace(line)
+	if err := credential.Validate(key); err != nil {
+		return "", fmt.Errorf("invalid api key: %w", err)
+	}
+	if err := credential.SetConfig(path, setting, key); err != nil {
+		return "", fmt.Errorf("failed to save the api key: %w", err)
+	}
+	return key, nil
+}
+
+func stty(ctx context.Context, tty *os.File, arg string) error {
+	c := exec.CommandContext(ctx, "stty", arg)
+	c.Stdin = tty
+	return c.Run()
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hirosassa/sgpt/handler"
+)
+
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputJSONL = "jsonl"
+)
+
+func validateOutputFormat(format string) error {
+	switch format {
+	case outputText, outputJSON, outputJSONL:
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format %q, must be one of: text, json, jsonl", format)
+	}
+}
+
+// printResponse writes the response in the given output format.
+// text prints only the content, json and jsonl print the whole envelope.
+func printResponse(w io.Writer, format string, res *handler.Response) error {
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	case outputJSONL:
+		return json.NewEncoder(w).Encode(res)
+	default:
+		_, err := fmt.Fprintln(w, res.Content)
+		return err
+	}
+}
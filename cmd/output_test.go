@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hirosassa/sgpt/handler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintResponse(t *testing.T) {
+	t.Parallel()
+	res := &handler.Response{Content: "Paris.", Provider: "openai", Model: "gpt-4o", FinishReason: "stop", LatencyMS: 612, Role: "ShellGPT"}
+	for format, want := range map[string]string{
+		outputText:  "Paris.\n",
+		outputJSONL: `{"content":"Paris.","provider":"openai","model":"gpt-4o","finish_reason":"stop","usage":{"prompt_tokens":0,"completion_tokens":0,"total_tokens":0},"latency_ms":612,"role":"ShellGPT"}` + "\n",
+		outputJSON: `{
+  "content": "Paris.",
+  "provider": "openai",
+  "model": "gpt-4o",
+  "finish_reason": "stop",
+  "usage": {
+    "prompt_tokens": 0,
+    "completion_tokens": 0,
+    "total_tokens": 0
+  },
+  "latency_ms": 612,
+  "role": "ShellGPT"
+}
+`,
+	} {
+		var out bytes.Buffer
+		require.NoError(t, printResponse(&out, format, res))
+		assert.Equal(t, want, out.String(), format)
+	}
+
+	assert.NoError(t, validateOutputFormat(outputJSONL))
+	assert.ErrorContains(t, validateOutputFormat("yaml"), `unsupported output format "yaml"`)
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/hirosassa/sgpt/handler"
+	"github.com/urfave/cli/v3"
+)
+
+// isTerminal reports whether f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// printCandidates writes every candidate numbered, for non-interactive use.
+func printCandidates(w io.Writer, candidates []string) error {
+	for i, c := range candidates {
+		if _, err := fmt.Fprintf(w, "%d) %s\n", i+1, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pick lets the user choose one of the candidates and what to do with it. A chosen
+// command is executed with shell. Prompts are written to stderr so that stdout only
+// carries the chosen answer.
+func pick(ctx context.Context, cmd *cli.Command, h handler.Handler, res *handler.Response, shell string) error {
+	in := bufio.NewReader(cmd.Root().Reader)
+	w := cmd.Root().ErrWriter
+	if err := printCandidates(w, res.Candidates); err != nil {
+		return err
+	}
+
+	index, err := ask(in, w, fmt.Sprintf("Select a candidate [1-%d]: ", len(res.Candidates)), func(answer string) (int, error) {
+		i, err := strconv.Atoi(answer)
+		if err != nil || i < 1 || i > len(res.Candidates) {
+			return 0, fmt.Errorf("please enter a number between 1 and %d", len(res.Candidates))
+		}
+		return i - 1, nil
+	})
+	if err != nil {
+		return err
+	}
+	chosen := res.Candidates[index]
+
+	recorder, canRecord := h.(handler.Recorder)
+	canRecord = canRecord && cmd.String("chat") != ""
+	actions := "[p]rint"
+	if cmd.Bool("shell") {
+		actions += ", [e]xecute"
+	}
+	if canRecord {
+		actions += ", [s]ave to chat"
+	}
+
+	action, err := ask(in, w, "Action "+actions+": ", func(answer string) (string, error) {
+		switch {
+		case answer == "" || answer == "p":
+			return "p", nil
+		case answer == "e" && cmd.Bool("shell"):
+			return answer, nil
+		case answer == "s" && canRecord:
+			return answer, nil
+		default:
+			return "", errors.New("please choose one of " + actions)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "e":
+		return execute(ctx, cmd, shell, chosen)
+	case "s":
+		if err := recorder.Record(chosen); err != nil {
+			return fmt.Errorf("failed to save the candidate: %w", err)
+		}
+	}
+	_, err = fmt.Fprintln(cmd.Root().Writer, chosen)
+	return err
+}
+
+// ask prompts until parse accepts the answer or the input ends.
+func ask[T any](in *bufio.Reader, w io.Writer, prompt string, parse func(answer string) (T, error)) (T, error) {
+	for {
+		fmt.Fprint(w, prompt)
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			var zero T
+			return zero, fmt.Errorf("failed to read the selection: %w", err)
+		}
+		v, perr := parse(strings.TrimSpace(line))
+		if perr == nil {
+			return v, nil
+		}
+		fmt.Fprintln(w, perr)
+		if err != nil {
+			var zero T
+			return zero, perr
+		}
+	}
+}
+
+// execute runs a generated command with the shell it was generated for, falling back
+// to the user's login shell.
+func execute(ctx context.Context, cmd *cli.Command, shell string, command string) error {
+	if shell == "" {
+		shell = os.Getenv("SHELL")
+	}
+	if shell == "" {
+		shell = "sh"
+	}
+	c := exec.CommandContext(ctx, shell, "-c", command)
+	c.Stdin = cmd.Root().Reader
+	c.Stdout = cmd.Root().Writer
+	c.Stderr = cmd.Root().ErrWriter
+	return c.Run()
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/hirosassa/sgpt/handler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+)
+
+// recorderFunc records the chosen candidate of a chat.
+type recorderFunc func(content string) error
+
+func (f recorderFunc) Handle(context.Context, *cli.Command, string) (*handler.Response, error) {
+	return nil, nil
+}
+
+func (f recorderFunc) Record(content string) error { return f(content) }
+
+func TestPrintCandidates(t *testing.T) {
+	t.Parallel()
+	var out bytes.Buffer
+	require.NoError(t, printCandidates(&out, []string{"ls", "ls -a"}))
+	assert.Equal(t, "1) ls\n2) ls -a\n", out.String())
+}
+
+func TestCandidatesWithoutTerminal(t *testing.T) {
+	t.Parallel()
+	out, err := runCmd(t, "--platform", "mock", "--n", "2", "list files")
+	require.NoError(t, err)
+	assert.Equal(t, "1) list files\n2) list files\n", out)
+}
+
+func TestPick(t *testing.T) {
+	t.Parallel()
+	res := &handler.Response{Content: "ls", Candidates: []string{"ls", "ls -a"}}
+
+	var out, prompts bytes.Buffer
+	cmd := newCmd()
+	cmd.Reader = strings.NewReader("3\n2\n\n")
+	cmd.Writer, cmd.ErrWriter = &out, &prompts
+	require.NoError(t, pick(context.Background(), cmd, nil, res, ""))
+	assert.Equal(t, "ls -a\n", out.String())
+	assert.Contains(t, prompts.String(), "please enter a number between 1 and 2")
+	assert.NotContains(t, prompts.String(), "[s]ave to chat", "only a chat can be saved to")
+
+	var recorded string
+	h := recorderFunc(func(content string) error {
+		recorded = content
+		return nil
+	})
+	out.Reset()
+	cmd = newCmd()
+	require.NoError(t, cmd.Set("chat", "work"))
+	cmd.Reader = strings.NewReader("2\ns\n")
+	cmd.Writer, cmd.ErrWriter = &out, &prompts
+	require.NoError(t, pick(context.Background(), cmd, h, res, ""))
+	assert.Equal(t, "ls -a", recorded)
+	assert.Equal(t, "ls -a\n", out.String())
+
+	cmd = newCmd()
+	cmd.Reader = strings.NewReader("1\n")
+	cmd.Writer, cmd.ErrWriter = &out, &prompts
+	assert.ErrorContains(t, pick(context.Background(), cmd, nil, res, ""), "failed to read the selection")
+}
+
+func TestPickExecutesWithShellName(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+	cmd := newCmd()
+	require.NoError(t, cmd.Set("shell", "true"))
+	require.NoError(t, cmd.Set("shell-name", "bash"))
+	role, err := handler.RoleFromCommand(cmd)
+	require.NoError(t, err)
+
+	var out, prompts bytes.Buffer
+	cmd.Reader = strings.NewReader("2\ne\n")
+	cmd.Writer, cmd.ErrWriter = &out, &prompts
+	res := &handler.Response{Content: "echo a", Candidates: []string{"echo a", "echo $0"}}
+	require.NoError(t, pick(context.Background(), cmd, nil, res, role.Shell))
+	assert.Equal(t, "bash\n", out.String(), "the command runs with the shell of --shell-name")
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/hirosassa/sgpt/contextprovider"
+	"github.com/hirosassa/sgpt/handler"
+	"github.com/hirosassa/sgpt/rag"
+	"github.com/urfave/cli/v3"
+)
+
+func newIndexCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "index",
+		Usage:     "Index the files of a directory for --rag, with the embeddings of --platform.",
+		ArgsUsage: "DIR",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:      "out",
+				Usage:     "File of the index; DIR/" + rag.DefaultFile + " by default.",
+				TakesFile: true,
+			},
+			&cli.StringFlag{
+				Name: "embedding-model",
+				Usage: fmt.Sprintf("Embedding model, or the deployment on azure. Defaults to %s on openai and %s on gemini.",
+					handler.DefaultOpenAIEmbeddingModel, handler.DefaultGeminiEmbeddingModel),
+			},
+		},
+		Action: indexDir,
+	}
+}
+
+// indexDir embeds the files of a directory that changed since the last indexing.
+func indexDir(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() != 1 {
+		return errors.New("usage: sgpt index DIR")
+	}
+	dir := cmd.Args().First()
+	out := cmd.String("out")
+	if out == "" {
+		out = filepath.Join(dir, rag.DefaultFile)
+	}
+
+	platform := cmd.String("platform")
+	embedder, err := newEmbedder(ctx, cmd, platform, cmd.String("embedding-model"), handler.RetrievalDocument)
+	if err != nil {
+		return err
+	}
+	ix, err := rag.Load(out)
+	if err != nil || ix.Platform != platform || ix.Model != embedder.Model() {
+		// vectors of different models cannot be compared, so the index starts over
+		ix = rag.New(platform, embedder.Model())
+	}
+
+	paths, err := contextprovider.Files(ctx, dir)
+	if err != nil {
+		return err
+	}
+	paths, err = withoutFile(dir, paths, out)
+	if err != nil {
+		return err
+	}
+	embed, err := redactingEmbed(cmd, embedder)
+	if err != nil {
+		return err
+	}
+	stats, err := ix.Update(ctx, dir, paths, embed)
+	if err != nil {
+		// keep the files embedded before the failure, so that running again resumes
+		if saveErr := ix.Save(out); saveErr != nil {
+			return errors.Join(err, saveErr)
+		}
+		return fmt.Errorf("%w; indexed %d files before the failure, run sgpt index again to resume", err, stats.Embedded)
+	}
+	if err := ix.Save(out); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(cmd.Root().ErrWriter, "indexed %d files into %d chunks in %s (%d unchanged, %d removed, %d skipped)\n",
+		stats.Embedded, stats.Chunks, out, stats.Unchanged, stats.Removed, stats.Skipped)
+	return err
+}
+
+// withoutFile removes the index file, and its temporary file, from the paths of dir.
+func withoutFile(dir string, paths []string, file string) ([]string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	kept := make([]string, 0, len(paths))
+	for _, p := range paths {
+		path, err := filepath.Abs(filepath.Join(dir, filepath.FromSlash(p)))
+		if err != nil {
+			return nil, err
+		}
+		if path != abs && path != abs+".tmp" {
+			kept = append(kept, p)
+		}
+	}
+	return kept, nil
+}
+
+// withRAG prepends the passages of the --rag index most related to the prompt.
+func withRAG(ctx context.Context, cmd *cli.Command, prompt string) (string, error) {
+	k := cmd.Int64("rag-top-k")
+	if k < 1 {
+		return "", fmt.Errorf("rag-top-k must be at least 1, got %d", k)
+	}
+	ix, err := rag.Load(cmd.String("rag"))
+	if err != nil {
+		return "", err
+	}
+	// the prompt is embedded like the indexed files, whatever the platform answering it
+	embedder, err := newEmbedder(ctx, cmd, ix.Platform, ix.Model, handler.RetrievalQuery)
+	if err != nil {
+		return "", err
+	}
+	embed, err := redactingEmbed(cmd, embedder)
+	if err != nil {
+		return "", err
+	}
+	vectors, err := embed(ctx, []string{prompt})
+	if err != nil {
+		return "", fmt.Errorf("failed to embed the prompt: %w", err)
+	}
+	if len(vectors) != 1 {
+		return "", fmt.Errorf("got %d embeddings for the prompt", len(vectors))
+	}
+	return rag.Render(ix.Search(vectors[0], int(k))) + prompt, nil
+}
+
+// newEmbedder creates the embedder of the model on the platform, the platform default
+// when model is empty, for texts used in the task.
+func newEmbedder(ctx context.Context, cmd *cli.Command, platform, model string, task handler.EmbeddingTask) (handler.Embedder, error) {
+	switch platform {
+	case handler.ProviderMock:
+		return handler.NewMockEmbedder(), nil
+	case handler.ProviderAzure:
+		if model == "" {
+			// --azure-deployment names the chat deployment, which cannot embed
+			return nil, errors.New("azure needs the deployment of an embedding model: pass --embedding-model")
+		}
+	case handler.ProviderOpenAI, handler.ProviderGemini:
+	default:
+		return nil, fmt.Errorf("embeddings are not supported on %s, use one of: openai, azure, gemini, mock", platform)
+	}
+
+	key, err := apiKey(ctx, cmd, platform)
+	if err != nil {
+		return nil, err
+	}
+	client, err := httpClient(cmd, key)
+	if err != nil {
+		return nil, err
+	}
+	opts := handler.Options{APIKey: key, HTTPClient: client, Model: model}
+	switch platform {
+	case handler.ProviderGemini:
+		return handler.NewGeminiEmbedder(ctx, opts, task)
+	case handler.ProviderAzure:
+		opts, err = azureOptions(cmd, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return handler.NewOpenAIEmbedder(opts)
+}
+
+// redactingEmbed embeds texts with e after removing their secrets, unless --no-redact.
+func redactingEmbed(cmd *cli.Command, e handler.Embedder) (rag.Embed, error) {
+	redactor, err := newRedactor(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, texts []string) ([][]float32, error) {
+		if redactor != nil {
+			redacted := make([]string, len(texts))
+			for i, text := range texts {
+				redacted[i] = redactor.Redact(text)
+			}
+			texts = redacted
+		}
+		return e.Embed(ctx, texts)
+	}, nil
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hirosassa/sgpt/handler"
+	"github.com/hirosassa/sgpt/redact"
+	"github.com/urfave/cli/v3"
+)
+
+// redactRulesPath returns the file of user-configured redaction patterns, one per line.
+func redactRulesPath() string {
+	return os.ExpandEnv("$HOME/.config/shell_gpt/redact")
+}
+
+// redactKeyPath returns the file of the key redaction placeholders are derived with.
+func redactKeyPath() string {
+	return os.ExpandEnv("$HOME/.config/shell_gpt/redact.key")
+}
+
+// newRedactor returns the redactor selected by the command flags, or nil with --no-redact.
+func newRedactor(cmd *cli.Command) (*redact.Redactor, error) {
+	if cmd.Bool("no-redact") {
+		return nil, nil
+	}
+	rules := redact.Builtin()
+	custom, err := redact.LoadRules(redactRulesPath())
+	if err != nil {
+		return nil, err
+	}
+	rules = append(rules, custom...)
+	if pattern := cmd.String("redact-pattern"); pattern != "" {
+		custom, err := redact.ParseRules([]string{pattern})
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, custom...)
+	}
+
+	r := redact.New(rules, func() ([]byte, error) {
+		return redact.LoadKey(redactKeyPath())
+	})
+	if cmd.Bool("show-redactions") {
+		w := cmd.Root().ErrWriter
+		r.OnFinding = func(f redact.Finding) {
+			fmt.Fprintf(w, "redacted %s %s as %s\n", f.Rule, f.Preview, f.Placeholder)
+		}
+	}
+	return r, nil
+}
+
+// redactHistory removes secrets from the turns of a conversation.
+func redactHistory(r *redact.Redactor, history []handler.Turn) []handler.Turn {
+	redacted := make([]handler.Turn, len(history))
+	for i, t := range history {
+		redacted[i] = handler.Turn{Role: t.Role, Content: r.Redact(t.Content)}
+	}
+	return redacted
+}
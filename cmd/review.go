@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hirosassa/sgpt/handler"
+	"github.com/hirosassa/sgpt/review"
+	"github.com/hirosassa/sgpt/schema"
+	"github.com/urfave/cli/v3"
+)
+
+func newReviewCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "review",
+		Usage: "Review a diff from stdin, the changes since --base, or the staged changes.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "base",
+				Usage: "Review the changes of HEAD since its merge base with this ref, e.g. origin/main.",
+			},
+			&cli.StringFlag{
+				Name:      "format",
+				Usage:     "Output format, one of: text, json, sarif, github",
+				Value:     "text",
+				Validator: review.ValidateFormat,
+			},
+			&cli.IntFlag{
+				Name:  "chunk-size",
+				Usage: "Diff size in bytes above which the diff is reviewed in parts.",
+				Value: review.DefaultChunkSize,
+			},
+		},
+		Action: reviewDiff,
+	}
+}
+
+func reviewDiff(ctx context.Context, cmd *cli.Command) error {
+	diff, err := reviewInput(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(diff) == "" {
+		return errors.New("nothing to review, the diff is empty")
+	}
+
+	role, err := handler.RoleFromCommand(cmd)
+	if err != nil {
+		return err
+	}
+	opts, err := newOptions(cmd, role)
+	if err != nil {
+		return err
+	}
+	opts.Schema, err = schema.Parse(review.SchemaName, []byte(review.Schema))
+	if err != nil {
+		return err
+	}
+	h, err := newHandler(ctx, cmd, opts)
+	if err != nil {
+		return err
+	}
+
+	prompts := review.Prompts(diff, cmd.Int("chunk-size"))
+	reports := make([]*review.Report, len(prompts))
+	for i, prompt := range prompts {
+		res, err := h.Handle(ctx, cmd, prompt)
+		if err != nil {
+			return fmt.Errorf("failed to review the diff: %w", err)
+		}
+		res, err = conform(ctx, cmd, h, opts.Schema, prompt, res)
+		if err != nil {
+			return err
+		}
+		reports[i], err = review.Parse(res.Content)
+		if err != nil {
+			return err
+		}
+	}
+	return review.Write(cmd.Root().Writer, cmd.String("format"), review.Merge(reports))
+}
+
+// reviewInput reads the diff from stdin when it is piped, and from git otherwise.
+func reviewInput(ctx context.Context, cmd *cli.Command) (string, error) {
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return "", err
+	}
+	if stat.Mode()&(os.ModeNamedPipe|os.ModeCharDevice) == os.ModeNamedPipe || stat.Mode().IsRegular() {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("failed to read from stdin: %w", err)
+		}
+		if len(data) > 0 {
+			return string(data), nil
+		}
+	}
+	return review.Diff(ctx, ".", cmd.String("base"))
+}
@@ -0,0 +1,289 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hirosassa/sgpt/handler"
+	"github.com/hirosassa/sgpt/schema"
+	"github.com/hirosassa/sgpt/server"
+	"github.com/urfave/cli/v3"
+)
+
+func newServeCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "Serve an OpenAI compatible API on localhost, answered through sgpt.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "addr",
+				Usage: "Address to listen on.",
+				Value: "127.0.0.1:8080",
+			},
+			&cli.StringFlag{
+				Name:    "token",
+				Usage:   "Bearer token clients must send; no authentication when empty.",
+				Sources: cli.EnvVars("SGPT_SERVE_TOKEN"),
+			},
+		},
+		Action: serve,
+	}
+}
+
+func serve(ctx context.Context, cmd *cli.Command) error {
+	backend := &serveBackend{
+		platform: cmd.String("platform"),
+		model:    cmd.String("model"),
+		flags:    serveFlags(cmd),
+	}
+	// The key is resolved before listening, where it can still be asked for on the terminal,
+	// so that a missing key fails sgpt serve instead of every request.
+	if _, err := backend.apiKey(ctx, cmd, backend.platform); err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Addr:              cmd.String("addr"),
+		Handler:           server.New(backend, cmd.String("token")),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("listening", slog.String("addr", srv.Addr))
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
+
+// serveBackend answers server requests like the sgpt command line would, with the
+// request fields mapped to the equivalent flags.
+type serveBackend struct {
+	platform string
+	model    string
+	// flags are the flags sgpt serve was started with, passed on to every request.
+	flags []string
+
+	mu sync.Mutex
+	// keys are the API keys of the platforms, resolved once.
+	keys map[string]string
+}
+
+// apiKey returns the API key of the platform, resolving it on the first request for the
+// platform only. Failures are not kept, so that a key configured later is picked up.
+func (b *serveBackend) apiKey(ctx context.Context, cmd *cli.Command, platform string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if key, ok := b.keys[platform]; ok {
+		return key, nil
+	}
+	key, err := apiKey(ctx, cmd, platform)
+	if err != nil {
+		return "", err
+	}
+	if b.keys == nil {
+		b.keys = make(map[string]string)
+	}
+	b.keys[platform] = key
+	return key, nil
+}
+
+// requestFlags are set from the fields of a request instead of the flags of sgpt serve.
+var requestFlags = map[string]bool{
+	"platform": true, "model": true, "chat": true, "temperature": true, "top-p": true,
+	"max-tokens": true, "seed": true, "stop": true, "n": true, "json": true, "json-schema": true,
+}
+
+// serveFlags returns the flags set when starting sgpt serve as arguments, e.g. --var or
+// --shell-name, except those set from the requests.
+func serveFlags(cmd *cli.Command) []string {
+	var args []string
+	for _, f := range cmd.Root().Flags {
+		name := f.Names()[0]
+		if requestFlags[name] || !cmd.IsSet(name) {
+			continue
+		}
+		switch v := cmd.Value(name).(type) {
+		case map[string]string:
+			for _, k := range slices.Sorted(maps.Keys(v)) {
+				args = append(args, "--"+name+"="+k+"="+v[k])
+			}
+		case []string:
+			for _, item := range v {
+				args = append(args, "--"+name+"="+item)
+			}
+		default:
+			args = append(args, fmt.Sprintf("--%s=%v", name, v))
+		}
+	}
+	return args
+}
+
+func (b *serveBackend) Models() []server.Model {
+	return []server.Model{
+		{ID: handler.DefaultOpenAIModel, OwnedBy: handler.ProviderOpenAI},
+		{ID: "gpt-4o-mini", OwnedBy: handler.ProviderOpenAI},
+		{ID: handler.DefaultGeminiModel, OwnedBy: handler.ProviderGemini},
+	}
+}
+
+func (b *serveBackend) Complete(ctx context.Context, req *server.Request, onDelta func(delta string) error) (*handler.Response, error) {
+	ctx = nonInteractive(ctx)
+	args, err := b.args(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", server.ErrBadRequest, err)
+	}
+	cmd, err := commandFor(ctx, args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", server.ErrBadRequest, err)
+	}
+	role, err := handler.RoleFromCommand(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", server.ErrBadRequest, err)
+	}
+	opts, err := newOptions(cmd, role)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", server.ErrBadRequest, err)
+	}
+	if f := req.ResponseFormat; f != nil && f.Type == "json_schema" {
+		opts.Schema, err = schema.Parse(f.JSONSchema.Name, f.JSONSchema.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", server.ErrBadRequest, err)
+		}
+	}
+	// A chat keeps its own history, so only the prompt is sent to it.
+	if req.ChatID == "" {
+		opts.History = req.History()
+	}
+	opts.APIKey, err = b.apiKey(ctx, cmd, cmd.String("platform"))
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := newHandler(ctx, cmd, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", server.ErrBadRequest, err)
+	}
+
+	prompt := req.Prompt()
+	if onDelta == nil {
+		res, err := h.Handle(ctx, cmd, prompt)
+		if err != nil {
+			return nil, err
+		}
+		if opts.JSON || opts.Schema != nil {
+			return conform(ctx, cmd, h, opts.Schema, prompt, res)
+		}
+		return res, nil
+	}
+
+	if s, ok := h.(handler.Streamer); ok {
+		return s.Stream(ctx, cmd, prompt, onDelta)
+	}
+	res, err := h.Handle(ctx, cmd, prompt)
+	if err != nil {
+		return nil, err
+	}
+	return res, onDelta(res.Content)
+}
+
+// args maps the request to sgpt flags.
+func (b *serveBackend) args(req *server.Request) ([]string, error) {
+	platform := req.Platform
+	if platform == "" {
+		platform = b.platform
+		if strings.HasPrefix(req.Model, "gemini-") && platform != handler.ProviderVertex {
+			platform = handler.ProviderGemini
+		}
+	}
+	model := req.Model
+	if model == "" {
+		model = b.model
+	}
+	args := append(slices.Clone(b.flags), "--platform="+platform)
+	if model != "" {
+		args = append(args, "--model="+model)
+	}
+
+	switch req.Role {
+	case "", "default":
+	case "shell", "code", "describe-shell":
+		args = append(args, "--"+req.Role)
+	default:
+		return nil, fmt.Errorf("unknown role %q, must be one of: default, shell, code, describe-shell", req.Role)
+	}
+	if req.ChatID != "" {
+		// the conversation would be answered without its history otherwise
+		if !keepsChats(platform) {
+			return nil, fmt.Errorf("%s does not keep chats, send the conversation as messages instead of %s", platform, server.HeaderChat)
+		}
+		args = append(args, "--chat="+req.ChatID)
+	}
+
+	if req.Temperature != nil {
+		args = append(args, "--temperature", strconv.FormatFloat(*req.Temperature, 'g', -1, 64))
+	}
+	if req.TopP != nil {
+		args = append(args, "--top-p", strconv.FormatFloat(*req.TopP, 'g', -1, 64))
+	}
+	maxTokens := req.MaxCompletionTokens
+	if maxTokens == nil {
+		maxTokens = req.MaxTokens
+	}
+	if maxTokens != nil {
+		args = append(args, "--max-tokens", strconv.FormatInt(*maxTokens, 10))
+	}
+	if req.Seed != nil {
+		args = append(args, "--seed", strconv.FormatInt(*req.Seed, 10))
+	}
+	for _, stop := range req.Stop {
+		// --stop=VALUE keeps values starting with a dash from being read as flags.
+		args = append(args, "--stop="+stop)
+	}
+	if req.N != nil {
+		args = append(args, "--n", strconv.FormatInt(*req.N, 10))
+	}
+	if f := req.ResponseFormat; f != nil && (f.Type == "json_object" || f.Type == "json_schema") {
+		args = append(args, "--json")
+	}
+	return args, nil
+}
+
+// commandFor parses args as sgpt flags and returns the parsed command without running it.
+func commandFor(ctx context.Context, args []string) (*cli.Command, error) {
+	var parsed *cli.Command
+	c := newCmd()
+	c.Writer = io.Discard
+	c.ErrWriter = io.Discard
+	// Request values are passed one per flag and must not be split at commas.
+	c.DisableSliceFlagSeparator = true
+	c.ExitErrHandler = func(context.Context, *cli.Command, error) {}
+	c.Action = func(ctx context.Context, cmd *cli.Command) error {
+		parsed = cmd
+		return nil
+	}
+	if err := c.Run(ctx, append([]string{os.Args[0]}, args...)); err != nil {
+		return nil, err
+	}
+	if parsed == nil {
+		return nil, errors.New("failed to parse the request")
+	}
+	return parsed, nil
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hirosassa/sgpt/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+)
+
+// serveCommand parses args as the command line of sgpt serve.
+func serveCommand(t *testing.T, args ...string) *cli.Command {
+	t.Helper()
+	var parsed *cli.Command
+	c := newCmd()
+	for _, sub := range c.Commands {
+		if sub.Name == "serve" {
+			sub.Action = func(ctx context.Context, cmd *cli.Command) error {
+				parsed = cmd
+				return nil
+			}
+		}
+	}
+	require.NoError(t, c.Run(context.Background(), append([]string{"sgpt"}, append(args, "serve")...)))
+	require.NotNil(t, parsed)
+	return parsed
+}
+
+func TestServeFlags(t *testing.T) {
+	t.Parallel()
+	cmd := serveCommand(t, "--platform", "gemini", "--shell-name", "fish", "--context-budget", "git=100",
+		"--var", "OS=Linux", "--var", "Shell=bash", "--temperature", "1")
+	assert.ElementsMatch(t, []string{
+		"--shell-name=fish", "--context-budget=git=100", "--var=OS=Linux", "--var=Shell=bash",
+	}, serveFlags(cmd), "the temperature is set by the requests")
+
+	backend := &serveBackend{platform: "gemini", flags: serveFlags(cmd)}
+	temperature := 0.5
+	args, err := backend.args(&server.Request{Temperature: &temperature})
+	require.NoError(t, err)
+	parsed, err := commandFor(context.Background(), args)
+	require.NoError(t, err)
+	assert.Equal(t, "fish", parsed.String("shell-name"))
+	assert.Equal(t, map[string]string{"OS": "Linux", "Shell": "bash"}, parsed.StringMap("var"))
+	assert.Equal(t, "gemini", parsed.String("platform"))
+	assert.Equal(t, 0.5, parsed.Float("temperature"))
+}
+
+func TestServeChatWithoutChats(t *testing.T) {
+	t.Parallel()
+	backend := &serveBackend{platform: "gemini"}
+	_, err := backend.args(&server.Request{ChatID: "work"})
+	assert.ErrorContains(t, err, "gemini does not keep chats")
+
+	backend.platform = "openai"
+	args, err := backend.args(&server.Request{ChatID: "work"})
+	require.NoError(t, err)
+	assert.Contains(t, args, "--chat=work")
+}
+
+func TestServeAPIKeyResolvedOnce(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SGPT_OPENAI_API_KEY", "")
+	count := filepath.Join(home, "count")
+	cmd := serveCommand(t, "--api-key-command", "echo >> "+count+"; echo sk-test")
+
+	backend := &serveBackend{platform: "openai"}
+	for range 3 {
+		key, err := backend.apiKey(context.Background(), cmd, "openai")
+		require.NoError(t, err)
+		assert.Equal(t, "sk-test", key)
+	}
+	data, err := os.ReadFile(count)
+	require.NoError(t, err)
+	assert.Equal(t, "\n", string(data), "the key command runs once")
+
+	key, err := backend.apiKey(context.Background(), cmd, "mock")
+	require.NoError(t, err)
+	assert.Empty(t, key)
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -10,6 +11,8 @@ import (
 	"strings"
 
 	"github.com/hirosassa/sgpt/handler"
+	sgptrole "github.com/hirosassa/sgpt/role"
+	"github.com/hirosassa/sgpt/schema"
 	"github.com/urfave/cli/v3"
 )
 
@@ -62,22 +65,254 @@ func newCmd() *cli.Command {
 					{},
 				},
 			},
+			// chat history changes are mutually exclusive
+			{
+				Flags: [][]cli.Flag{
+					{
+						&cli.BoolFlag{
+							Name:  "fork",
+							Usage: "Copy the chat given as first argument to a new chat given as second argument.",
+						},
+					},
+					{
+						&cli.BoolFlag{
+							Name:  "undo",
+							Usage: "Remove the last prompt and answer of --chat.",
+						},
+					},
+					{
+						&cli.BoolFlag{
+							Name:  "regenerate",
+							Usage: "Send the last prompt of --chat again for a new answer, replacing the last answer.",
+						},
+					},
+					{
+						&cli.BoolFlag{
+							Name:  "edit-last",
+							Usage: "Edit the last prompt of --chat in $EDITOR and send it again, replacing the last answer.",
+						},
+					},
+				},
+			},
 		},
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:  "chat",
 				Usage: "Follow conversation with id, \" 'use \"temp\" for quick session.",
 			},
+			&cli.StringFlag{
+				Name:  "template",
+				Usage: "Name of a prompt template to render, see \"sgpt template list\".",
+			},
+			&cli.StringMapFlag{
+				Name:  "var",
+				Usage: "Template and role variable as NAME=VALUE; can be repeated.",
+			},
+			&cli.StringFlag{
+				Name:  "shell-name",
+				Usage: "Shell to generate commands for, e.g. fish; detected from the parent process by default.",
+			},
+			&cli.StringSliceFlag{
+				Name:  "context",
+				Usage: "Prepend context to the prompt, any of: git, dir, env; can be repeated or comma separated.",
+			},
+			&cli.StringMapFlag{
+				Name:  "context-budget",
+				Usage: "Token budget of a context provider as NAME=TOKENS, e.g. git=8000.",
+			},
+			&cli.StringFlag{
+				Name:      "rag",
+				Usage:     "Prepend the passages most related to the prompt from an index created by \"sgpt index\", or the indexed directory.",
+				TakesFile: true,
+			},
+			&cli.Int64Flag{
+				Name:  "rag-top-k",
+				Usage: "Number of passages --rag prepends.",
+				Value: 5,
+			},
+			&cli.StringFlag{
+				Name:      "record",
+				Usage:     "Record the provider traffic, with API keys redacted, to cassettes in DIR.",
+				TakesFile: true,
+			},
+			&cli.StringFlag{
+				Name:      "replay",
+				Usage:     "Answer from the cassettes recorded in DIR instead of calling the provider.",
+				TakesFile: true,
+			},
+			&cli.BoolFlag{
+				Name:  "no-redact",
+				Usage: "Send prompts without replacing secrets such as API keys and private keys by placeholders.",
+			},
+			&cli.BoolFlag{
+				Name:  "show-redactions",
+				Usage: "Report the secrets replaced in prompts on stderr.",
+			},
+			&cli.StringFlag{
+				Name:  "redact-pattern",
+				Usage: "Regular expression of additional secrets to redact, on top of those in ~/.config/shell_gpt/redact.",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Print what would be sent to the provider without sending it.",
+			},
 			&cli.StringFlag{
 				Name:  "platform",
-				Usage: "One of: openai, gemini",
+				Usage: "One of: openai, azure, gemini, vertex, mock",
 				Value: "openai",
 			},
+			&cli.StringFlag{
+				Name:    "azure-endpoint",
+				Usage:   "Endpoint of the Azure OpenAI resource, e.g. https://example.openai.azure.com.",
+				Sources: cli.EnvVars("AZURE_OPENAI_ENDPOINT"),
+			},
+			&cli.StringFlag{
+				Name:    "azure-deployment",
+				Usage:   "Azure OpenAI deployment to use when --model is not given.",
+				Sources: cli.EnvVars("AZURE_OPENAI_DEPLOYMENT"),
+			},
+			&cli.StringFlag{
+				Name:    "azure-api-version",
+				Usage:   "Azure OpenAI API version.",
+				Value:   handler.DefaultAzureAPIVersion,
+				Sources: cli.EnvVars("OPENAI_API_VERSION"),
+			},
+			&cli.StringFlag{
+				Name:    "azure-auth",
+				Usage:   "How the Azure OpenAI key is sent, one of: api-key (header), bearer (Microsoft Entra ID token).",
+				Value:   azureAuthAPIKey,
+				Sources: cli.EnvVars("SGPT_AZURE_AUTH"),
+			},
+			&cli.StringFlag{
+				Name:    "vertex-project",
+				Usage:   "Google Cloud project of the vertex platform.",
+				Sources: cli.EnvVars("GOOGLE_CLOUD_PROJECT"),
+			},
+			&cli.StringFlag{
+				Name:    "vertex-location",
+				Usage:   "Google Cloud region of the vertex platform.",
+				Value:   "us-central1",
+				Sources: cli.EnvVars("GOOGLE_CLOUD_LOCATION"),
+			},
+			&cli.StringFlag{
+				Name:      "vertex-credentials",
+				Usage:     "Service account JSON key of the vertex platform; Application Default Credentials by default.",
+				Sources:   cli.EnvVars("SGPT_VERTEX_CREDENTIALS"),
+				TakesFile: true,
+			},
+			&cli.StringFlag{
+				Name:    "vertex-endpoint",
+				Usage:   "Vertex AI endpoint to use instead of https://LOCATION-aiplatform.googleapis.com/.",
+				Sources: cli.EnvVars("SGPT_VERTEX_ENDPOINT"),
+			},
+			&cli.StringFlag{
+				Name:      "api-key-file",
+				Usage:     "Read the API key of the platform from FILE.",
+				TakesFile: true,
+			},
+			&cli.StringFlag{
+				Name:  "api-key-command",
+				Usage: "Read the API key of the platform from the output of a command, e.g. \"pass show openai\".",
+			},
+			&cli.StringFlag{
+				Name:      "ca-bundle",
+				Usage:     "PEM file of certificate authorities to trust in addition to the system ones.",
+				Sources:   cli.EnvVars("SGPT_CA_BUNDLE"),
+				TakesFile: true,
+			},
+			&cli.StringFlag{
+				Name:      "client-cert",
+				Usage:     "PEM client certificate for mutual TLS; requires --client-key.",
+				Sources:   cli.EnvVars("SGPT_CLIENT_CERT"),
+				TakesFile: true,
+			},
+			&cli.StringFlag{
+				Name:      "client-key",
+				Usage:     "PEM private key of --client-cert.",
+				Sources:   cli.EnvVars("SGPT_CLIENT_KEY"),
+				TakesFile: true,
+			},
+			&cli.StringMapFlag{
+				Name:  "header",
+				Usage: "HTTP header to add to provider requests as NAME=VALUE; can be repeated.",
+			},
+			&cli.DurationFlag{
+				Name:    "timeout",
+				Usage:   "Time limit of a provider request including the streamed response, e.g. 90s. No limit by default.",
+				Sources: cli.EnvVars("SGPT_TIMEOUT"),
+			},
+			&cli.StringFlag{
+				Name:  "mock",
+				Usage: "Answer of the mock platform, one of: echo (the prompt), canned (see --mock-responses), role (the request messages).",
+				Value: handler.MockEcho,
+			},
+			&cli.StringFlag{
+				Name:      "mock-responses",
+				Usage:     "JSON list of {\"pattern\", \"response\"} the mock platform answers with; implies --mock canned.",
+				TakesFile: true,
+			},
 			&cli.StringFlag{
 				Name:  "model",
-				Usage: "Model name to use, e.g. gemini-2.0-flash; only used when --platform is set to gemini.",
-				Value: "gemini-2.0-flash",
+				Usage: "Model name to use, e.g. gpt-4o-mini or gemini-2.0-flash, or the deployment on azure. Defaults to the platform default.",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Request the response as a JSON document.",
+			},
+			&cli.FloatFlag{
+				Name:  "temperature",
+				Usage: "Randomness of generated output, between 0 and 2. Defaults to the role or provider default.",
+			},
+			&cli.FloatFlag{
+				Name:  "top-p",
+				Usage: "Limits highest probable tokens (words), between 0 and 1.",
 			},
+			&cli.Int64Flag{
+				Name:  "max-tokens",
+				Usage: "Maximum number of tokens to generate.",
+			},
+			&cli.Int64Flag{
+				Name:  "seed",
+				Usage: "Seed for deterministic sampling; not supported by gemini.",
+			},
+			&cli.StringSliceFlag{
+				Name:  "stop",
+				Usage: "Sequence where the model stops generating; can be repeated.",
+			},
+			&cli.StringSliceFlag{
+				Name:  "compare",
+				Usage: "Answer with several models at once and compare them, e.g. openai:gpt-4o,gemini:gemini-2.0-flash; a platform alone uses its default model.",
+			},
+			&cli.StringFlag{
+				Name:  "compare-layout",
+				Usage: "Layout of --compare, one of: sequential, side-by-side (as wide as $COLUMNS).",
+				Value: compareSequential,
+			},
+			&cli.Int64Flag{
+				Name:  "n",
+				Usage: "Number of candidates to generate; more than one shows a picker.",
+				Value: 1,
+			},
+			&cli.StringFlag{
+				Name:      "output",
+				Usage:     "Output format, one of: text, json, jsonl",
+				Value:     "text",
+				Validator: validateOutputFormat,
+			},
+			&cli.StringFlag{
+				Name:      "json-schema",
+				Usage:     "Path to a JSON schema the response must conform to; implies --json.",
+				TakesFile: true,
+			},
+		},
+		Commands: []*cli.Command{
+			newTemplateCmd(),
+			newCommitCmd(),
+			newReviewCmd(),
+			newServeCmd(),
+			newChatCmd(),
+			newIndexCmd(),
+			newBatchCmd(),
 		},
 		Action: run,
 		// todo: try enabling this feature for stdin input.
@@ -87,6 +322,88 @@ func newCmd() *cli.Command {
 }
 
 func run(ctx context.Context, cmd *cli.Command) error {
+	switch {
+	case cmd.Bool("fork"):
+		return forkChat(cmd)
+	case cmd.Bool("undo"):
+		return undoChat(cmd)
+	}
+
+	if cmd.IsSet("compare") && cmd.String("chat") != "" {
+		return errors.New("--compare cannot be combined with --chat, the models would answer the same chat")
+	}
+
+	var prompt string
+	var err error
+	if cmd.Bool("regenerate") || cmd.Bool("edit-last") {
+		prompt, err = resendPrompt(ctx, cmd)
+	} else {
+		prompt, err = readPrompt(ctx, cmd)
+	}
+	if err != nil {
+		return err
+	}
+
+	role, err := handler.RoleFromCommand(cmd)
+	if err != nil {
+		return err
+	}
+	opts, err := newOptions(cmd, role)
+	if err != nil {
+		return err
+	}
+	opts.ReplaceLastTurn = cmd.Bool("regenerate") || cmd.Bool("edit-last")
+
+	if cmd.Bool("dry-run") {
+		redactor, err := newRedactor(cmd)
+		if err != nil {
+			return err
+		}
+		if redactor != nil {
+			prompt = redactor.Redact(prompt)
+		}
+		return printDryRun(cmd.Root().Writer, cmd, opts, prompt)
+	}
+	if cmd.IsSet("compare") {
+		return runCompare(ctx, cmd, opts, prompt)
+	}
+
+	h, err := newHandler(ctx, cmd, opts)
+	if err != nil {
+		return err
+	}
+
+	res, err := h.Handle(ctx, cmd, prompt)
+	if err != nil {
+		return fmt.Errorf("failed to communicate OpenAI API: %w", err)
+	}
+
+	if opts.JSON || opts.Schema != nil {
+		res, err = conform(ctx, cmd, h, opts.Schema, prompt, res)
+		if err != nil {
+			return err
+		}
+	}
+
+	checkShellSyntax(ctx, role, res)
+
+	format := cmd.String("output")
+	if len(res.Candidates) > 1 && format == outputText {
+		if isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+			return pick(ctx, cmd, h, res, role.Shell)
+		}
+		return printCandidates(cmd.Root().Writer, res.Candidates)
+	}
+	return printResponse(cmd.Root().Writer, format, res)
+}
+
+// keepsChats reports whether the handlers of the platform store --chat conversations.
+func keepsChats(platform string) bool {
+	return platform != handler.ProviderGemini && platform != handler.ProviderVertex
+}
+
+// readPrompt returns the prompt of the arguments, stdin, --template, --rag and --context.
+func readPrompt(ctx context.Context, cmd *cli.Command) (string, error) {
 	stat, err := os.Stdin.Stat()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -97,42 +414,234 @@ func run(ctx context.Context, cmd *cli.Command) error {
 	if stat.Size() > 0 { // if stdin is not empty
 		stdin, err = io.ReadAll(os.Stdin)
 		if err != nil {
-			return fmt.Errorf("failed to read from stdin: %w", err)
+			return "", fmt.Errorf("failed to read from stdin: %w", err)
 		}
 	}
 	prompt := cmd.Args().First() + "\n" + strings.TrimSpace(string(stdin))
+	if cmd.String("template") != "" {
+		prompt, err = renderTemplate(cmd, cmd.Args().First(), strings.TrimSpace(string(stdin)), nil)
+		if err != nil {
+			return "", err
+		}
+	}
 	slog.Debug("get prompt", slog.String("prompt", prompt))
 
+	if cmd.String("rag") != "" {
+		prompt, err = withRAG(ctx, cmd, prompt)
+		if err != nil {
+			return "", err
+		}
+	}
+	if names := cmd.StringSlice("context"); len(names) > 0 {
+		prompt, err = withContext(ctx, cmd, names, prompt)
+		if err != nil {
+			return "", err
+		}
+	}
+	return prompt, nil
+}
+
+// newHandler creates the handler for the platform and chat selected by the command flags.
+// The API key is looked up unless opts already holds one.
+func newHandler(ctx context.Context, cmd *cli.Command, opts handler.Options) (handler.Handler, error) {
+	return newPlatformHandler(ctx, cmd, cmd.String("platform"), opts)
+}
+
+// newPlatformHandler is newHandler for a platform other than the one of --platform.
+func newPlatformHandler(ctx context.Context, cmd *cli.Command, platform string, opts handler.Options) (handler.Handler, error) {
+	var err error
+	if opts.APIKey == "" {
+		opts.APIKey, err = apiKey(ctx, cmd, platform)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient, err = httpClient(cmd, opts.APIKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if platform == handler.ProviderAzure {
+		opts, err = azureOptions(cmd, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	redactor, err := newRedactor(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if redactor != nil {
+		opts.History = redactHistory(redactor, opts.History)
+	}
+
 	var h handler.Handler
-	platform := cmd.String("platform")
 	switch platform {
+	case handler.ProviderMock:
+		h, err = newMockHandler(cmd, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create chat handler: %w", err)
+		}
 	case "gemini":
-		model := cmd.String("model")
-		h, err = handler.NewGeminiChatHandler(ctx, os.Getenv("SGPT_GEMINI_API_KEY"), model)
+		model := opts.Model
+		if model == "" {
+			model = handler.DefaultGeminiModel
+		}
+		h, err = handler.NewGeminiChatHandler(ctx, model, opts)
 		if err != nil {
-			return fmt.Errorf("failed to create chat handler: %w", err)
+			return nil, fmt.Errorf("failed to create chat handler: %w", err)
+		}
+	case handler.ProviderVertex:
+		model := opts.Model
+		if model == "" {
+			model = handler.DefaultGeminiModel
+		}
+		opts.Vertex = handler.Vertex{
+			Project:               cmd.String("vertex-project"),
+			Location:              cmd.String("vertex-location"),
+			CredentialsFile:       cmd.String("vertex-credentials"),
+			Endpoint:              cmd.String("vertex-endpoint"),
+			WithoutAuthentication: cmd.String("replay") != "",
+		}
+		h, err = handler.NewVertexHandler(ctx, model, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create chat handler: %w", err)
 		}
 	default:
 		chatID := cmd.String("chat")
 		switch chatID {
 		case "":
-			h, err = handler.NewDefaultHandler(cmd)
+			h, err = handler.NewDefaultHandler(cmd, opts)
 			if err != nil {
-				return fmt.Errorf("failed to create chat handler: %w", err)
+				return nil, fmt.Errorf("failed to create chat handler: %w", err)
 			}
 		default:
-			h, err = handler.NewChatHandler(cmd, chatID)
+			h, err = handler.NewChatHandler(cmd, chatID, opts)
 			if err != nil {
-				return fmt.Errorf("failed to create chat handler: %w", err)
+				return nil, fmt.Errorf("failed to create chat handler: %w", err)
 			}
 		}
 	}
+	if redactor != nil {
+		return handler.NewRedactingHandler(h, redactor.Redact, redactor.Restore), nil
+	}
+	return h, nil
+}
 
-	res, err := h.Handle(ctx, cmd, prompt)
-	if err != nil {
-		return fmt.Errorf("failed to communicate OpenAI API: %w", err)
+func newMockHandler(cmd *cli.Command, opts handler.Options) (*handler.MockHandler, error) {
+	mode := cmd.String("mock")
+	var canned []handler.CannedResponse
+	if path := cmd.String("mock-responses"); path != "" {
+		if !cmd.IsSet("mock") {
+			mode = handler.MockCanned
+		}
+		var err error
+		canned, err = handler.LoadCannedResponses(path)
+		if err != nil {
+			return nil, err
+		}
 	}
+	return handler.NewMockHandler(cmd, cmd.String("chat"), mode, canned, opts)
+}
 
-	fmt.Println(res)
-	return nil
+func newOptions(cmd *cli.Command, role *sgptrole.SystemRole) (handler.Options, error) {
+	sampling := newSampling(cmd, role)
+	opts := handler.Options{
+		Model:    cmd.String("model"),
+		Role:     role,
+		JSON:     cmd.Bool("json"),
+		Sampling: sampling,
+		N:        cmd.Int64("n"),
+	}
+	if opts.N < 1 {
+		return handler.Options{}, fmt.Errorf("n must be at least 1, got %d", opts.N)
+	}
+	if opts.N > 1 && (opts.JSON || cmd.String("json-schema") != "") {
+		return handler.Options{}, errors.New("--n cannot be combined with --json or --json-schema")
+	}
+	if path := cmd.String("json-schema"); path != "" {
+		s, err := schema.Load(path)
+		if err != nil {
+			return handler.Options{}, err
+		}
+		opts.Schema = s
+	}
+	return opts, nil
+}
+
+// newSampling reads the sampling flags, falling back to the defaults of the selected role.
+func newSampling(cmd *cli.Command, role *sgptrole.SystemRole) handler.Sampling {
+	sampling := handler.Sampling{
+		Temperature: role.Temperature,
+		TopP:        role.TopP,
+		Stop:        cmd.StringSlice("stop"),
+	}
+	if cmd.IsSet("temperature") {
+		v := cmd.Float("temperature")
+		sampling.Temperature = &v
+	}
+	if cmd.IsSet("top-p") {
+		v := cmd.Float("top-p")
+		sampling.TopP = &v
+	}
+	if cmd.IsSet("max-tokens") {
+		v := cmd.Int64("max-tokens")
+		sampling.MaxTokens = &v
+	}
+	if cmd.IsSet("seed") {
+		v := cmd.Int64("seed")
+		sampling.Seed = &v
+	}
+	return sampling
+}
+
+// checkShellSyntax warns when a generated command does not parse as the shell of the role.
+func checkShellSyntax(ctx context.Context, role *sgptrole.SystemRole, res *handler.Response) {
+	if role.Shell == "" {
+		return
+	}
+	commands := res.Candidates
+	if len(commands) == 0 {
+		commands = []string{res.Content}
+	}
+	for i, command := range commands {
+		err := sgptrole.CheckSyntax(ctx, role.Shell, command)
+		if err == nil || errors.Is(err, sgptrole.ErrNoParser) {
+			continue
+		}
+		if len(commands) > 1 {
+			fmt.Fprintf(os.Stderr, "warning: candidate %d: %v\n", i+1, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}
+	}
+}
+
+// conform validates a JSON response and asks the model to repair it once if it does not conform.
+// In a chat, the repaired answer replaces the rejected one instead of adding a turn.
+func conform(ctx context.Context, cmd *cli.Command, h handler.Handler, s *schema.Schema, prompt string, res *handler.Response) (*handler.Response, error) {
+	verr := schema.Validate(s, res.Content)
+	if verr == nil {
+		return res, nil
+	}
+	slog.Debug("response does not conform, retrying", slog.String("error", verr.Error()))
+
+	repairPrompt := prompt + "\n\nYour previous response was:\n" + res.Content +
+		"\n\nIt was rejected with the following validation error:\n" + verr.Error() +
+		"\n\nReturn a corrected response."
+	repaired, err := h.Handle(handler.Unrecorded(ctx), cmd, repairPrompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to repair response: %w", err)
+	}
+	if err := schema.Validate(s, repaired.Content); err != nil {
+		return nil, fmt.Errorf("response does not conform to the json schema: %w", err)
+	}
+	if recorder, ok := h.(handler.Recorder); ok && res.ChatID != "" {
+		if err := recorder.Record(repaired.Content); err != nil {
+			return nil, err
+		}
+	}
+	return repaired, nil
 }
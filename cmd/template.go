@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/hirosassa/sgpt/prompt"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	// variables every template can reference without --var
+	promptVariable = "Prompt"
+	stdinVariable  = "Stdin"
+)
+
+func templateLibrary() *prompt.Library {
+	return prompt.NewLibrary(os.ExpandEnv("$HOME/.config/shell_gpt/templates")) // todo: make this configurable
+}
+
+func newTemplateCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "template",
+		Usage: "Manage prompt templates stored in ~/.config/shell_gpt/templates.",
+		Commands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "List available templates and their variables.",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					lib := templateLibrary()
+					names, err := lib.List()
+					if err != nil {
+						return err
+					}
+					for _, name := range names {
+						tpl, err := lib.Get(name)
+						if err != nil {
+							return err
+						}
+						fmt.Printf("%s\t%s\n", name, strings.Join(tpl.Variables(), ", "))
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "Print a template.",
+				ArgsUsage: "NAME",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					if cmd.Args().Len() != 1 {
+						return errors.New("usage: sgpt template show NAME")
+					}
+					tpl, err := templateLibrary().Get(cmd.Args().First())
+					if err != nil {
+						return err
+					}
+					fmt.Print(tpl.Text)
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// renderTemplate renders the template selected with --template with the --var variables,
+// overridden by vars. The command line prompt and stdin are available as {{ .Prompt }}
+// and {{ .Stdin }}; when the template does not reference them, they are appended to the
+// rendered text.
+func renderTemplate(cmd *cli.Command, input string, stdin string, vars map[string]string) (string, error) {
+	tpl, err := templateLibrary().Get(cmd.String("template"))
+	if err != nil {
+		return "", err
+	}
+
+	variables := map[string]string{
+		promptVariable: input,
+		stdinVariable:  stdin,
+	}
+	for k, v := range cmd.StringMap("var") {
+		variables[k] = v
+	}
+	for k, v := range vars {
+		variables[k] = v
+	}
+
+	rendered, err := tpl.Render(variables)
+	if err != nil {
+		var missing *prompt.MissingVariableError
+		if errors.As(err, &missing) {
+			return "", fmt.Errorf("%w; set them with --var NAME=VALUE", err)
+		}
+		return "", err
+	}
+
+	used := tpl.Variables()
+	if input != "" && !slices.Contains(used, promptVariable) {
+		rendered += "\n" + input
+	}
+	if stdin != "" && !slices.Contains(used, stdinVariable) {
+		rendered += "\n" + stdin
+	}
+	return rendered, nil
+}
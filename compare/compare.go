@@ -0,0 +1,101 @@
+// Package compare sends the same prompt to several models at once and lays out their
+// answers with the latency, tokens and cost of each.
+package compare
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hirosassa/sgpt/handler"
+)
+
+// Platforms are the platforms a target may name.
+var Platforms = []string{handler.ProviderOpenAI, handler.ProviderAzure, handler.ProviderGemini, handler.ProviderVertex, handler.ProviderMock}
+
+// Target is a model of a platform, written platform:model.
+type Target struct {
+	Platform string
+	// Model is empty for the default model of the platform.
+	Model string
+}
+
+func (t Target) String() string {
+	if t.Model == "" {
+		return t.Platform
+	}
+	return t.Platform + ":" + t.Model
+}
+
+// ParseTargets parses targets such as openai:gpt-4o or gemini; at least two are needed.
+func ParseTargets(specs []string) ([]Target, error) {
+	var targets []Target
+	seen := map[Target]bool{}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		platform, model, _ := strings.Cut(spec, ":")
+		t := Target{Platform: platform, Model: model}
+		if !isPlatform(platform) {
+			return nil, fmt.Errorf("unknown platform %q in %q, must be one of: %s", platform, spec, strings.Join(Platforms, ", "))
+		}
+		if seen[t] {
+			return nil, fmt.Errorf("%s is compared twice", t)
+		}
+		seen[t] = true
+		targets = append(targets, t)
+	}
+	if len(targets) < 2 {
+		return nil, fmt.Errorf("a comparison needs at least two models, got %d", len(targets))
+	}
+	return targets, nil
+}
+
+func isPlatform(platform string) bool {
+	for _, p := range Platforms {
+		if p == platform {
+			return true
+		}
+	}
+	return false
+}
+
+// Result is the answer of a target, or why it failed.
+type Result struct {
+	Target string `json:"target"`
+	*handler.Response
+	// CostUSD is estimated from list prices; nil when the price of the model is unknown.
+	CostUSD *float64 `json:"cost_usd,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Run asks every target at the same time and returns their results in the order of
+// targets.
+func Run(ctx context.Context, targets []Target, ask func(context.Context, Target) (*handler.Response, error)) []Result {
+	results := make([]Result, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := ask(ctx, t)
+			if err != nil {
+				results[i] = Result{Target: t.String(), Error: err.Error()}
+				return
+			}
+			results[i] = Result{Target: t.String(), Response: res}
+			model := res.Model
+			if model == "" {
+				model = t.Model
+			}
+			if cost, ok := Cost(model, res.Usage); ok {
+				results[i].CostUSD = &cost
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
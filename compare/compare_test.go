@@ -0,0 +1,118 @@
+package compare
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hirosassa/sgpt/handler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTargets(t *testing.T) {
+	t.Parallel()
+	targets, err := ParseTargets([]string{"openai:gpt-4o", " gemini", "azure:my-deployment"})
+	require.NoError(t, err)
+	assert.Equal(t, []Target{{"openai", "gpt-4o"}, {"gemini", ""}, {"azure", "my-deployment"}}, targets)
+	assert.Equal(t, "gemini", targets[1].String())
+
+	for specs, want := range map[string]string{
+		"openai:gpt-4o":               "at least two models, got 1",
+		"openai:gpt-4o,claude:sonnet": `unknown platform "claude" in "claude:sonnet"`,
+		"openai,openai":               "openai is compared twice",
+	} {
+		_, err := ParseTargets(strings.Split(specs, ","))
+		assert.ErrorContains(t, err, want, specs)
+	}
+}
+
+func TestCost(t *testing.T) {
+	t.Parallel()
+	usage := handler.Usage{PromptTokens: 1_000_000, CompletionTokens: 500_000}
+	for model, want := range map[string]float64{
+		"gpt-4o-2024-08-06":       7.5,
+		"gpt-4o-mini":             0.45,
+		"gpt-4o-mini-2024-07-18":  0.45,
+		"models/gemini-2.0-flash": 0.3,
+	} {
+		cost, ok := Cost(model, usage)
+		assert.True(t, ok, model)
+		assert.InDelta(t, want, cost, 1e-9, model)
+	}
+	for _, model := range []string{"my-deployment", "gpt-4o-audio-preview", "gpt-4o-realtime-preview-2024-12-17", "o1-pro", "gpt-4o-2024"} {
+		_, ok := Cost(model, usage)
+		assert.False(t, ok, model)
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	targets := []Target{{"openai", "gpt-4o-mini"}, {"gemini", ""}, {"mock", ""}}
+	start := time.Now()
+	results := Run(context.Background(), targets, func(ctx context.Context, target Target) (*handler.Response, error) {
+		time.Sleep(50 * time.Millisecond)
+		switch target.Platform {
+		case "gemini":
+			return nil, errors.New("quota exceeded")
+		case "mock":
+			return &handler.Response{Content: "hi", Model: "mock"}, nil
+		}
+		return &handler.Response{Content: "hello", Usage: handler.Usage{PromptTokens: 100, CompletionTokens: 10}}, nil
+	})
+	assert.Less(t, time.Since(start), 140*time.Millisecond, "the targets are asked at the same time")
+
+	require.Len(t, results, 3)
+	assert.Equal(t, "openai:gpt-4o-mini", results[0].Target)
+	require.NotNil(t, results[0].CostUSD, "priced by the model of the target")
+	assert.InDelta(t, 0.000021, *results[0].CostUSD, 1e-12)
+	assert.Equal(t, Result{Target: "gemini", Error: "quota exceeded"}, results[1])
+	assert.Nil(t, results[2].CostUSD)
+}
+
+func TestSequential(t *testing.T) {
+	t.Parallel()
+	cost := 0.00028
+	var out bytes.Buffer
+	require.NoError(t, Sequential(&out, []Result{
+		{Target: "openai:gpt-4o", Response: &handler.Response{Content: "Paris.\n", LatencyMS: 612, Usage: handler.Usage{PromptTokens: 80, CompletionTokens: 8}}, CostUSD: &cost},
+		{Target: "gemini", Error: "quota exceeded"},
+	}))
+	assert.Equal(t, `=== openai:gpt-4o (612ms, 80 in + 8 out tokens, $0.000280)
+Paris.
+
+=== gemini
+error: quota exceeded
+`, out.String())
+}
+
+func TestSideBySide(t *testing.T) {
+	t.Parallel()
+	results := []Result{
+		{Target: "openai:gpt-4o", Response: &handler.Response{Content: "The capital of France is Paris, on the Seine.", LatencyMS: 1500}},
+		{Target: "gemini", Error: "quota exceeded"},
+	}
+	var out bytes.Buffer
+	require.NoError(t, SideBySide(&out, results, 55))
+	assert.Equal(t, `openai:gpt-4o              │ gemini
+───────────────────────────┼───────────────────────────
+The capital of France is   │ error: quota exceeded
+Paris, on the Seine.       │
+───────────────────────────┼───────────────────────────
+1.5s                       │
+0 in + 0 out tokens        │
+cost unknown               │
+`, out.String())
+
+	out.Reset()
+	require.NoError(t, SideBySide(&out, results, 40))
+	assert.True(t, strings.HasPrefix(out.String(), "=== openai:gpt-4o"), "too narrow for columns")
+}
+
+func TestWrap(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, []string{"go test", "./...", "", "abcdefgh", "ij", "    if x", "{"}, wrap("go test ./...\n\nabcdefghij\n\tif x {\n", 8))
+}
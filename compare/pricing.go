@@ -0,0 +1,52 @@
+package compare
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/hirosassa/sgpt/handler"
+)
+
+// price is the list price of a model in US dollars per million tokens.
+type price struct {
+	input, output float64
+}
+
+// prices are the list prices of early 2025 by model name. A dated snapshot such as
+// gpt-4o-2024-08-06 costs as its model; other names, e.g. gpt-4o-audio-preview, have
+// prices of their own and are unknown. Gemini prices are those of prompts up to 128k tokens.
+var prices = map[string]price{
+	"gpt-4o":                {2.50, 10.00},
+	"gpt-4o-mini":           {0.15, 0.60},
+	"gpt-4.1":               {2.00, 8.00},
+	"gpt-4.1-mini":          {0.40, 1.60},
+	"gpt-4.1-nano":          {0.10, 0.40},
+	"gpt-4-turbo":           {10.00, 30.00},
+	"gpt-3.5-turbo":         {0.50, 1.50},
+	"o1":                    {15.00, 60.00},
+	"o1-mini":               {1.10, 4.40},
+	"o3-mini":               {1.10, 4.40},
+	"gemini-1.5-flash":      {0.075, 0.30},
+	"gemini-1.5-pro":        {1.25, 5.00},
+	"gemini-2.0-flash":      {0.10, 0.40},
+	"gemini-2.0-flash-lite": {0.075, 0.30},
+	"gemini-2.5-flash":      {0.30, 2.50},
+	"gemini-2.5-pro":        {1.25, 10.00},
+}
+
+// snapshotDate is the date suffix of a snapshot of a model.
+var snapshotDate = regexp.MustCompile(`-\d{4}-\d{2}-\d{2}$`)
+
+// Cost estimates the cost of usage by model in US dollars. It reports false when the
+// price of the model is unknown.
+func Cost(model string, usage handler.Usage) (float64, bool) {
+	model = strings.TrimPrefix(model, "models/")
+	p, ok := prices[model]
+	if !ok {
+		p, ok = prices[snapshotDate.ReplaceAllString(model, "")]
+	}
+	if !ok {
+		return 0, false
+	}
+	return (float64(usage.PromptTokens)*p.input + float64(usage.CompletionTokens)*p.output) / 1e6, true
+}
@@ -0,0 +1,137 @@
+package compare
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// columnSeparator separates the answers laid out side by side.
+const columnSeparator = " │ "
+
+// minColumnWidth is the narrowest column SideBySide lays out.
+const minColumnWidth = 24
+
+// stats describes the latency, tokens and cost of a result.
+func stats(r Result) []string {
+	if r.Response == nil {
+		return nil
+	}
+	cost := "cost unknown"
+	if r.CostUSD != nil {
+		cost = fmt.Sprintf("$%.6f", *r.CostUSD)
+	}
+	return []string{
+		(time.Duration(r.LatencyMS) * time.Millisecond).String(),
+		fmt.Sprintf("%d in + %d out tokens", r.Usage.PromptTokens, r.Usage.CompletionTokens),
+		cost,
+	}
+}
+
+// body is the answer of a result, or its error.
+func body(r Result) string {
+	if r.Response == nil {
+		return "error: " + r.Error
+	}
+	return r.Content
+}
+
+// Sequential writes the results one after the other, each under a heading with its
+// target and stats.
+func Sequential(w io.Writer, results []Result) error {
+	for i, r := range results {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		heading := "=== " + r.Target
+		if s := stats(r); s != nil {
+			heading += " (" + strings.Join(s, ", ") + ")"
+		}
+		if _, err := fmt.Fprintf(w, "%s\n%s\n", heading, strings.TrimRight(body(r), "\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SideBySide writes the results in columns sharing width characters, with the
+// targets on top and the stats at the bottom. When the columns would be too narrow
+// to read, it falls back to Sequential. Characters are assumed to be one column
+// wide.
+func SideBySide(w io.Writer, results []Result, width int) error {
+	n := len(results)
+	if n == 0 {
+		return nil
+	}
+	column := (width - utf8.RuneCountInString(columnSeparator)*(n-1)) / n
+	if column < minColumnWidth {
+		return Sequential(w, results)
+	}
+
+	headers := make([][]string, n)
+	bodies := make([][]string, n)
+	footers := make([][]string, n)
+	for i, r := range results {
+		headers[i] = wrap(r.Target, column)
+		bodies[i] = wrap(body(r), column)
+		for _, s := range stats(r) {
+			footers[i] = append(footers[i], wrap(s, column)...)
+		}
+	}
+	rule := strings.Repeat("─", column)
+	rules := make([]string, n)
+	for i := range rules {
+		rules[i] = rule
+	}
+
+	var b strings.Builder
+	writeRows(&b, headers, column)
+	b.WriteString(strings.Join(rules, "─┼─") + "\n")
+	writeRows(&b, bodies, column)
+	b.WriteString(strings.Join(rules, "─┼─") + "\n")
+	writeRows(&b, footers, column)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeRows writes the lines of the columns next to each other.
+func writeRows(b *strings.Builder, columns [][]string, width int) {
+	rows := 0
+	for _, lines := range columns {
+		rows = max(rows, len(lines))
+	}
+	for row := range rows {
+		cells := make([]string, len(columns))
+		for i, lines := range columns {
+			var cell string
+			if row < len(lines) {
+				cell = lines[row]
+			}
+			cells[i] = cell + strings.Repeat(" ", width-utf8.RuneCountInString(cell))
+		}
+		b.WriteString(strings.TrimRight(strings.Join(cells, columnSeparator), " ") + "\n")
+	}
+}
+
+// wrap breaks text into lines of at most width characters, between words where it can.
+func wrap(text string, width int) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		line = strings.ReplaceAll(strings.TrimRight(line, " \r"), "\t", "    ")
+		for utf8.RuneCountInString(line) > width {
+			runes := []rune(line)
+			cut := width
+			if space := strings.LastIndex(string(runes[:width+1]), " "); space > 0 && strings.TrimSpace(line[:space]) != "" {
+				cut = utf8.RuneCountInString(line[:space])
+			}
+			lines = append(lines, strings.TrimRight(string(runes[:cut]), " "))
+			line = strings.TrimLeft(string(runes[cut:]), " ")
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,99 @@
+package contextprovider
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dirProvider lists the files of the directory as a tree. Inside a git repository
+// the listing respects .gitignore.
+type dirProvider struct{}
+
+func (dirProvider) Name() string { return "dir" }
+
+func (dirProvider) Budget() int { return 1000 }
+
+func (dirProvider) Gather(ctx context.Context, dir string) (string, error) {
+	paths, err := Files(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	return tree(paths), nil
+}
+
+// Files lists the files under dir as slash separated relative paths. Inside a git
+// repository the listing respects .gitignore, elsewhere hidden entries are skipped.
+func Files(ctx context.Context, dir string) ([]string, error) {
+	paths, err := gitFiles(ctx, dir)
+	if err != nil {
+		return walkFiles(dir)
+	}
+	return paths, nil
+}
+
+// gitFiles lists tracked and untracked files that are not ignored.
+func gitFiles(ctx context.Context, dir string) ([]string, error) {
+	// NUL separated, since paths may contain spaces and are quoted otherwise
+	out, err := run(ctx, dir, "git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, nil
+	}
+	return strings.Split(strings.TrimSuffix(out, "\x00"), "\x00"), nil
+}
+
+// walkFiles lists the files under dir, skipping hidden entries.
+func walkFiles(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	})
+	return paths, err
+}
+
+// tree renders slash separated paths as an indented tree.
+func tree(paths []string) string {
+	sort.Strings(paths)
+	var b strings.Builder
+	var previous []string
+	for _, p := range paths {
+		parts := strings.Split(p, "/")
+		// skip the directories shared with the previous path
+		common := 0
+		for common < len(parts)-1 && common < len(previous)-1 && parts[common] == previous[common] {
+			common++
+		}
+		for i := common; i < len(parts); i++ {
+			b.WriteString(strings.Repeat("  ", i))
+			b.WriteString(parts[i])
+			if i < len(parts)-1 {
+				b.WriteString("/")
+			}
+			b.WriteString("\n")
+		}
+		previous = parts
+	}
+	return b.String()
+}
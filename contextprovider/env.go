@@ -0,0 +1,45 @@
+package contextprovider
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// tools are the commands reporting the version of common development tools.
+var tools = [][]string{
+	{"go", "version"},
+	{"node", "--version"},
+	{"python3", "--version"},
+	{"rustc", "--version"},
+	{"java", "-version"},
+	{"docker", "--version"},
+	{"kubectl", "version", "--client"},
+	{"terraform", "version"},
+	{"git", "--version"},
+}
+
+// envProvider reports the versions of the installed development tools.
+type envProvider struct{}
+
+func (envProvider) Name() string { return "env" }
+
+func (envProvider) Budget() int { return 300 }
+
+func (envProvider) Gather(ctx context.Context, dir string) (string, error) {
+	var b strings.Builder
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool[0]); err != nil {
+			continue
+		}
+		out, err := run(ctx, dir, tool[0], tool[1:]...)
+		if err != nil {
+			continue
+		}
+		// only the first line is relevant, e.g. java prints the runtime on the next ones
+		first, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+		fmt.Fprintf(&b, "%s: %s\n", tool[0], first)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,48 @@
+package contextprovider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const gitLogLength = 10
+
+// gitProvider reports the branch, recent log and the staged and unstaged diff.
+type gitProvider struct{}
+
+func (gitProvider) Name() string { return "git" }
+
+func (gitProvider) Budget() int { return 4000 }
+
+func (gitProvider) Gather(ctx context.Context, dir string) (string, error) {
+	branch, err := run(ctx, dir, "git", "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Branch: %s\n", strings.TrimSpace(branch))
+
+	// a repository without commits has no log
+	if log, err := run(ctx, dir, "git", "log", "--oneline", fmt.Sprintf("-n%d", gitLogLength)); err == nil && log != "" {
+		fmt.Fprintf(&b, "\nRecent commits:\n%s", log)
+	}
+
+	staged, err := run(ctx, dir, "git", "diff", "--staged")
+	if err != nil {
+		return "", err
+	}
+	if staged != "" {
+		fmt.Fprintf(&b, "\nStaged changes:\n%s", staged)
+	}
+
+	unstaged, err := run(ctx, dir, "git", "diff")
+	if err != nil {
+		return "", err
+	}
+	if unstaged != "" {
+		fmt.Fprintf(&b, "\nUnstaged changes:\n%s", unstaged)
+	}
+	return b.String(), nil
+}
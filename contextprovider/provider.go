@@ -0,0 +1,115 @@
+package contextprovider
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// charsPerToken approximates the number of characters of a token for budgeting.
+const charsPerToken = 4
+
+const truncatedMarker = "... (truncated)"
+
+// Provider gathers context about the working directory to prepend to a prompt.
+type Provider interface {
+	Name() string
+	// Budget is the default number of tokens the provider may use.
+	Budget() int
+	Gather(ctx context.Context, dir string) (string, error)
+}
+
+var providers = map[string]Provider{
+	"git": gitProvider{},
+	"dir": dirProvider{},
+	"env": envProvider{},
+}
+
+// Names returns the sorted names of the available providers.
+func Names() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the provider with the given name.
+func Get(name string) (Provider, error) {
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown context provider %q, must be one of: %s", name, strings.Join(Names(), ", "))
+	}
+	return p, nil
+}
+
+// Section is the labelled output of a provider, cut to its budget.
+type Section struct {
+	Name      string
+	Content   string
+	Truncated bool
+}
+
+// Build gathers the sections of the named providers in dir. budgets overrides
+// the default budget of a provider, in tokens.
+func Build(ctx context.Context, dir string, names []string, budgets map[string]int) ([]Section, error) {
+	for name := range budgets {
+		if _, err := Get(name); err != nil {
+			return nil, fmt.Errorf("invalid context budget: %w", err)
+		}
+	}
+	sections := make([]Section, 0, len(names))
+	for _, name := range names {
+		p, err := Get(name)
+		if err != nil {
+			return nil, err
+		}
+		content, err := p.Gather(ctx, dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to gather %s context: %w", name, err)
+		}
+
+		budget := p.Budget()
+		if b, ok := budgets[name]; ok {
+			budget = b
+		}
+		content, truncated := truncate(content, budget*charsPerToken)
+		sections = append(sections, Section{Name: name, Content: content, Truncated: truncated})
+	}
+	return sections, nil
+}
+
+// Render formats the sections to be prepended to a prompt.
+func Render(sections []Section) string {
+	var b strings.Builder
+	for _, s := range sections {
+		fmt.Fprintf(&b, "### Context: %s\n```\n%s\n```\n\n", s.Name, strings.TrimRight(s.Content, "\n"))
+	}
+	return b.String()
+}
+
+// truncate cuts s to at most limit bytes at a line boundary.
+func truncate(s string, limit int) (string, bool) {
+	if len(s) <= limit {
+		return s, false
+	}
+	cut := s[:max(limit-len(truncatedMarker)-1, 0)]
+	if i := strings.LastIndexByte(cut, '\n'); i >= 0 {
+		cut = cut[:i+1]
+	}
+	return cut + truncatedMarker, true
+}
+
+// run executes a command in dir and returns its combined output.
+func run(ctx context.Context, dir string, name string, args ...string) (string, error) {
+	c := exec.CommandContext(ctx, name, args...)
+	c.Dir = dir
+	out, err := c.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
@@ -0,0 +1,76 @@
+package contextprovider
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncate(t *testing.T) {
+	t.Parallel()
+	got, truncated := truncate("short", 100)
+	assert.Equal(t, "short", got)
+	assert.False(t, truncated)
+
+	long := strings.Repeat("0123456789\n", 10)
+	got, truncated = truncate(long, 40)
+	assert.True(t, truncated)
+	assert.LessOrEqual(t, len(got), 40)
+	assert.Equal(t, "0123456789\n0123456789\n"+truncatedMarker, got)
+}
+
+func TestTree(t *testing.T) {
+	t.Parallel()
+	got := tree([]string{"main.go", "cmd/sgpt.go", "cmd/output.go", "role/role.go"})
+	assert.Equal(t, "cmd/\n  output.go\n  sgpt.go\nmain.go\nrole/\n  role.go\n", got)
+}
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	gitCmd := func(args ...string) {
+		c := exec.Command("git", args...)
+		c.Dir = dir
+		c.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := c.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	gitCmd("init", "-q", "-b", "main")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("secret.txt\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "release notes.md"), []byte("# Notes\n"), 0o600))
+	gitCmd("add", ".")
+	gitCmd("commit", "-q", "-m", "initial commit")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("token"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o600))
+
+	sections, err := Build(context.Background(), dir, []string{"git", "dir"}, map[string]int{"dir": 100})
+	require.NoError(t, err)
+	require.Len(t, sections, 2)
+
+	assert.Contains(t, sections[0].Content, "Branch: main")
+	assert.Contains(t, sections[0].Content, "initial commit")
+	assert.Contains(t, sections[0].Content, "Unstaged changes:")
+	assert.Contains(t, sections[0].Content, "+func main() {}")
+
+	assert.Equal(t, ".gitignore\nmain.go\nrelease notes.md\n", sections[1].Content)
+	assert.False(t, sections[1].Truncated)
+
+	rendered := Render(sections)
+	assert.Contains(t, rendered, "### Context: dir\n```\n.gitignore\nmain.go\nrelease notes.md\n```\n")
+
+	_, err = Build(context.Background(), dir, []string{"unknown"}, nil)
+	assert.Error(t, err)
+	_, err = Build(context.Background(), dir, []string{"dir"}, map[string]int{"dirs": 100})
+	assert.ErrorContains(t, err, `invalid context budget: unknown context provider "dirs"`)
+}
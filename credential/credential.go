@@ -0,0 +1,174 @@
+// Package credential finds API keys in the environment, files, credential helper
+// commands and the sgpt configuration file.
+package credential
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// ErrNotFound is returned by Resolve when no source has a key.
+var ErrNotFound = errors.New("no api key found")
+
+// Source is one place an API key can come from.
+type Source struct {
+	// Name describes the source in error messages, e.g. "SGPT_OPENAI_API_KEY".
+	Name string
+	get  func(ctx context.Context) (string, error)
+}
+
+// Env reads the key from an environment variable.
+func Env(name string) Source {
+	return Source{Name: "$" + name, get: func(context.Context) (string, error) {
+		return os.Getenv(name), nil
+	}}
+}
+
+// Value is a key given directly, e.g. from the configuration file.
+func Value(name, value string) Source {
+	return Source{Name: name, get: func(context.Context) (string, error) {
+		return value, nil
+	}}
+}
+
+// File reads the key from the file at path, if path is not empty.
+func File(name, path string) Source {
+	return Source{Name: name, get: func(context.Context) (string, error) {
+		if path == "" {
+			return "", nil
+		}
+		data, err := os.ReadFile(expandHome(path))
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}}
+}
+
+// Command runs a credential helper such as "pass show openai" or
+// "op read op://dev/openai/key" with sh and reads the key from its output,
+// if command is not empty.
+func Command(name, command string) Source {
+	return Source{Name: name, get: func(ctx context.Context) (string, error) {
+		if command == "" {
+			return "", nil
+		}
+		c := exec.CommandContext(ctx, "sh", "-c", command)
+		c.Stdin = os.Stdin
+		var stderr bytes.Buffer
+		c.Stderr = &stderr
+		out, err := c.Output()
+		if err != nil {
+			return "", fmt.Errorf("%q failed: %w: %s", command, err, strings.TrimSpace(stderr.String()))
+		}
+		return string(out), nil
+	}}
+}
+
+// Resolve returns the key of the first source that has one, and the name of that source.
+func Resolve(ctx context.Context, sources ...Source) (string, string, error) {
+	for _, s := range sources {
+		key, err := s.get(ctx)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read api key from %s: %w", s.Name, err)
+		}
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		if err := Validate(key); err != nil {
+			return "", "", fmt.Errorf("api key from %s is invalid: %w", s.Name, err)
+		}
+		return key, s.Name, nil
+	}
+	return "", "", ErrNotFound
+}
+
+// Validate reports keys that cannot be sent in a request header.
+func Validate(key string) error {
+	if key == "" {
+		return errors.New("it is empty")
+	}
+	for _, r := range key {
+		if unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return errors.New("it contains whitespace or control characters, only the key itself must be given")
+		}
+	}
+	return nil
+}
+
+// LoadConfig reads a configuration file of "key = value" lines. Empty lines and
+// lines starting with # are ignored. A missing file is an empty configuration.
+func LoadConfig(path string) (map[string]string, error) {
+	config := map[string]string{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		config[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return config, scanner.Err()
+}
+
+// SetConfig sets key to value in the configuration file, keeping its other lines.
+// The file is only readable by the user since it may hold API keys.
+func SetConfig(path, key, value string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	var lines []string
+	replaced := false
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if k, _, ok := strings.Cut(line, "="); ok && strings.TrimSpace(k) == key && !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			line = key + " = " + value
+			replaced = true
+		}
+		if line != "" || len(lines) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	if !replaced {
+		lines = append(lines, key+" = "+value)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file.
+	return os.Chmod(path, 0o600)
+}
+
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
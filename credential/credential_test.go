@@ -0,0 +1,75 @@
+package credential
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("sk-from-file\n"), 0o600))
+
+	key, from, err := Resolve(ctx, File("--api-key-file", ""), Command("--api-key-command", ""), File("openai_api_key_file", keyFile))
+	require.NoError(t, err)
+	assert.Equal(t, "sk-from-file", key)
+	assert.Equal(t, "openai_api_key_file", from)
+
+	key, from, err = Resolve(ctx, Command("openai_api_key_command", "printf 'sk-from-command\n'"), Value("openai_api_key", "sk-from-config"))
+	require.NoError(t, err)
+	assert.Equal(t, "sk-from-command", key)
+	assert.Equal(t, "openai_api_key_command", from)
+
+	_, _, err = Resolve(ctx, Value("openai_api_key", ""))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, _, err = Resolve(ctx, Command("openai_api_key_command", "echo locked >&2; exit 1"))
+	assert.ErrorContains(t, err, "failed to read api key from openai_api_key_command")
+	assert.ErrorContains(t, err, "locked")
+
+	_, _, err = Resolve(ctx, File("openai_api_key_file", filepath.Join(dir, "missing")))
+	assert.ErrorContains(t, err, "failed to read api key from openai_api_key_file")
+
+	_, _, err = Resolve(ctx, Value("openai_api_key", "Bearer sk-test"))
+	assert.ErrorContains(t, err, "api key from openai_api_key is invalid")
+}
+
+func TestConfig(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "shell_gpt", "config")
+
+	config, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Empty(t, config)
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	require.NoError(t, os.WriteFile(path, []byte("# keys\ngemini_api_key_command = pass show gemini\nopenai_api_key = old\n"), 0o644))
+	require.NoError(t, SetConfig(path, "openai_api_key", "sk-new=="))
+	require.NoError(t, SetConfig(path, "openai_api_key_file", "~/.openai"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "# keys\ngemini_api_key_command = pass show gemini\nopenai_api_key = sk-new==\nopenai_api_key_file = ~/.openai\n", string(data))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	config, err = LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"gemini_api_key_command": "pass show gemini",
+		"openai_api_key":         "sk-new==",
+		"openai_api_key_file":    "~/.openai",
+	}, config)
+
+	require.NoError(t, os.WriteFile(path, []byte("not a setting\n"), 0o600))
+	_, err = LoadConfig(path)
+	assert.ErrorContains(t, err, "expected key = value")
+}
@@ -0,0 +1,262 @@
+package gitcommit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/hirosassa/sgpt/prompt"
+)
+
+// DefaultChunkSize is the size in bytes above which a diff is summarized in chunks.
+const DefaultChunkSize = 48 * 1024
+
+const (
+	defaultPrompt = "Write a git commit message for the following staged changes.\n" +
+		"The first line is a summary of at most 72 characters in the imperative mood, " +
+		"followed by a blank line and a short body explaining what changed and why when it is not obvious.\n" +
+		"Provide only the commit message in plain text without Markdown formatting or code fences."
+
+	summarizePrompt = "Summarize the following part of a larger diff as a short bullet list of the changes. " +
+		"Provide only the list."
+
+	// DiffVariable is the template variable holding the diff, e.g. {{ .Diff }}.
+	DiffVariable = "Diff"
+)
+
+// Complete sends a prompt to a provider and returns its answer.
+type Complete func(ctx context.Context, prompt string) (string, error)
+
+// Generator writes commit messages for diffs.
+type Generator struct {
+	Complete Complete
+	// Template, when set, replaces the default prompt. The diff is available as {{ .Diff }}.
+	Template *prompt.Template
+	// Variables are passed to Template in addition to the diff.
+	Variables map[string]string
+	// ChunkSize is the diff size above which the diff is summarized in chunks first.
+	ChunkSize int
+}
+
+// Generate returns a commit message for the diff.
+func (g *Generator) Generate(ctx context.Context, diff string) (string, error) {
+	if strings.TrimSpace(diff) == "" {
+		return "", errors.New("nothing to commit, stage changes with git add first")
+	}
+
+	chunkSize := g.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if len(diff) > chunkSize {
+		summary, err := g.summarize(ctx, Chunk(diff, chunkSize))
+		if err != nil {
+			return "", err
+		}
+		diff = summary
+	}
+
+	p, err := g.prompt(diff)
+	if err != nil {
+		return "", err
+	}
+	message, err := g.Complete(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	return clean(message), nil
+}
+
+func (g *Generator) prompt(diff string) (string, error) {
+	if g.Template != nil {
+		vars := map[string]string{DiffVariable: diff}
+		for k, v := range g.Variables {
+			vars[k] = v
+		}
+		return g.Template.Render(vars)
+	}
+
+	return defaultPrompt + "\n\n" + diff, nil
+}
+
+// summarize replaces a diff too large for a single request by summaries of its chunks.
+func (g *Generator) summarize(ctx context.Context, chunks []string) (string, error) {
+	var b strings.Builder
+	b.WriteString("The diff is too large to include, these are summaries of its parts:\n")
+	for i, chunk := range chunks {
+		summary, err := g.Complete(ctx, summarizePrompt+"\n\n"+chunk)
+		if err != nil {
+			return "", fmt.Errorf("failed to summarize part %d of the diff: %w", i+1, err)
+		}
+		fmt.Fprintf(&b, "\nPart %d:\n%s\n", i+1, strings.TrimSpace(summary))
+	}
+	return b.String(), nil
+}
+
+// Chunk splits a diff into chunks of at most size bytes. It splits between files
+// when possible and between lines otherwise.
+func Chunk(diff string, size int) []string {
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, file := range splitFiles(diff) {
+		if current.Len()+len(file) <= size {
+			current.WriteString(file)
+			continue
+		}
+		flush()
+		if len(file) <= size {
+			current.WriteString(file)
+			continue
+		}
+		for _, line := range strings.SplitAfter(file, "\n") {
+			if current.Len()+len(line) > size {
+				flush()
+			}
+			current.WriteString(line)
+		}
+	}
+	flush()
+	return chunks
+}
+
+// splitFiles splits a diff at each "diff --git" header.
+func splitFiles(diff string) []string {
+	if diff == "" {
+		return nil
+	}
+	var files []string
+	start := 0
+	for {
+		i := strings.Index(diff[start+1:], "\ndiff --git ")
+		if i < 0 {
+			break
+		}
+		end := start + 1 + i + 1
+		files = append(files, diff[start:end])
+		start = end
+	}
+	return append(files, diff[start:])
+}
+
+// clean removes code fences a model may wrap the message in.
+func clean(message string) string {
+	message = strings.TrimSpace(message)
+	if strings.HasPrefix(message, "```") {
+		if i := strings.IndexByte(message, '\n'); i >= 0 {
+			message = message[i+1:]
+		}
+		message = strings.TrimSuffix(strings.TrimSpace(message), "```")
+	}
+	return strings.TrimSpace(message) + "\n"
+}
+
+// StagedDiff returns the staged changes of the repository in dir.
+func StagedDiff(ctx context.Context, dir string) (string, error) {
+	return git(ctx, dir, "diff", "--staged")
+}
+
+// Commit runs git commit with the message. When edit is true, git opens the
+// message in the user's editor ($GIT_EDITOR, core.editor or $EDITOR) first.
+func Commit(ctx context.Context, dir string, message string, edit bool) error {
+	f, err := os.CreateTemp("", "sgpt-commit-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(message); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	args := []string{"commit", "--file", f.Name()}
+	if edit {
+		args = append(args, "--edit")
+	}
+	c := exec.CommandContext(ctx, "git", args...)
+	c.Dir = dir
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	return c.Run()
+}
+
+// WriteMessage prepends the message to a commit message file, as prepared by git
+// for the prepare-commit-msg hook.
+func WriteMessage(path string, message string) error {
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(message), existing...), 0o600)
+}
+
+// hookMarker identifies hooks installed by sgpt so that they can be replaced safely.
+const hookMarker = "# installed by sgpt"
+
+// Hook is the prepare-commit-msg hook. It only fills the message when git did not get
+// one from -m, -F, a template, a merge or an amended commit.
+const Hook = `#!/bin/sh
+` + hookMarker + `
+case "$2" in
+"")
+	sgpt commit --write "$1" < /dev/null || true
+	;;
+esac
+`
+
+// InstallHook installs the prepare-commit-msg hook into the repository in dir.
+// An existing hook not installed by sgpt is only replaced when force is true.
+func InstallHook(ctx context.Context, dir string, force bool) (string, error) {
+	hooks, err := git(ctx, dir, "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", err
+	}
+	hooks = strings.TrimSpace(hooks)
+	if !filepath.IsAbs(hooks) {
+		hooks = filepath.Join(dir, hooks)
+	}
+	if err := os.MkdirAll(hooks, 0o755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(hooks, "prepare-commit-msg")
+	if existing, err := os.ReadFile(path); err == nil && !force && !bytes.Contains(existing, []byte(hookMarker)) {
+		return "", fmt.Errorf("%s already exists, use --force to replace it", path)
+	}
+	//nolint:gosec // hooks must be executable
+	if err := os.WriteFile(path, []byte(Hook), 0o755); err != nil {
+		return "", err
+	}
+	//nolint:gosec // hooks must be executable
+	if err := os.Chmod(path, 0o755); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	c := exec.CommandContext(ctx, "git", args...)
+	c.Dir = dir
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
@@ -0,0 +1,135 @@
+package gitcommit
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hirosassa/sgpt/prompt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProvider records the prompts it receives and answers with canned responses.
+type fakeProvider struct {
+	prompts []string
+	answer  func(prompt string) string
+}
+
+func (f *fakeProvider) complete(_ context.Context, p string) (string, error) {
+	f.prompts = append(f.prompts, p)
+	return f.answer(p), nil
+}
+
+func newRepo(t *testing.T) (string, func(args ...string) string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	gitCmd := func(args ...string) string {
+		c := exec.Command("git", args...)
+		c.Dir = dir
+		out, err := c.CombinedOutput()
+		require.NoError(t, err, string(out))
+		return string(out)
+	}
+	gitCmd("init", "-q")
+	gitCmd("config", "user.name", "test")
+	gitCmd("config", "user.email", "test@example.com")
+	gitCmd("config", "commit.gpgsign", "false")
+	return dir, gitCmd
+}
+
+func TestGenerateAndCommit(t *testing.T) {
+	t.Parallel()
+	dir, gitCmd := newRepo(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0o600))
+	gitCmd("add", "main.go")
+
+	ctx := context.Background()
+	diff, err := StagedDiff(ctx, dir)
+	require.NoError(t, err)
+	assert.Contains(t, diff, "+package main")
+
+	fake := &fakeProvider{answer: func(string) string { return "```\nfeat: add main package\n```" }}
+	g := &Generator{Complete: fake.complete}
+	message, err := g.Generate(ctx, diff)
+	require.NoError(t, err)
+	assert.Equal(t, "feat: add main package\n", message)
+	require.Len(t, fake.prompts, 1)
+	assert.Contains(t, fake.prompts[0], "+package main")
+
+	require.NoError(t, Commit(ctx, dir, message, false))
+	assert.Equal(t, "feat: add main package", strings.TrimSpace(gitCmd("log", "-1", "--format=%B")))
+
+	_, err = g.Generate(ctx, "")
+	assert.Error(t, err)
+}
+
+func TestGenerateChunked(t *testing.T) {
+	t.Parallel()
+	diff := "diff --git a/a.go b/a.go\n+" + strings.Repeat("a", 50) + "\n" +
+		"diff --git a/b.go b/b.go\n+" + strings.Repeat("b", 50) + "\n"
+	chunks := Chunk(diff, 90)
+	require.Len(t, chunks, 2)
+	assert.True(t, strings.HasPrefix(chunks[1], "diff --git a/b.go"))
+	assert.Equal(t, diff, strings.Join(chunks, ""))
+
+	fake := &fakeProvider{answer: func(p string) string {
+		if strings.HasPrefix(p, summarizePrompt) {
+			return "- changed a file"
+		}
+		return "Update files"
+	}}
+	g := &Generator{Complete: fake.complete, ChunkSize: 90}
+	message, err := g.Generate(context.Background(), diff)
+	require.NoError(t, err)
+	assert.Equal(t, "Update files\n", message)
+	require.Len(t, fake.prompts, 3)
+	assert.Contains(t, fake.prompts[2], "Part 2:\n- changed a file")
+}
+
+func TestGenerateTemplate(t *testing.T) {
+	t.Parallel()
+	tpl, err := prompt.Parse("commit", "Commit for {{ .ticket }}:\n{{ .Diff }}")
+	require.NoError(t, err)
+
+	fake := &fakeProvider{answer: func(string) string { return "ABC-1: fix" }}
+	g := &Generator{Complete: fake.complete, Template: tpl, Variables: map[string]string{"ticket": "ABC-1"}}
+	_, err = g.Generate(context.Background(), "+fix")
+	require.NoError(t, err)
+	assert.Equal(t, "Commit for ABC-1:\n+fix", fake.prompts[0])
+}
+
+func TestInstallHook(t *testing.T) {
+	t.Parallel()
+	dir, _ := newRepo(t)
+	ctx := context.Background()
+
+	path, err := InstallHook(ctx, dir, false)
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode()&0o100)
+
+	// reinstalling over our own hook is fine
+	_, err = InstallHook(ctx, dir, false)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\necho custom\n"), 0o700))
+	_, err = InstallHook(ctx, dir, false)
+	assert.Error(t, err)
+	_, err = InstallHook(ctx, dir, true)
+	assert.NoError(t, err)
+
+	msg := filepath.Join(dir, "COMMIT_EDITMSG")
+	require.NoError(t, os.WriteFile(msg, []byte("# comment\n"), 0o600))
+	require.NoError(t, WriteMessage(msg, "fix: typo\n"))
+	data, err := os.ReadFile(msg)
+	require.NoError(t, err)
+	assert.Equal(t, "fix: typo\n# comment\n", string(data))
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/openai/openai-go/option"
+)
+
+// DefaultAzureAPIVersion is the Azure OpenAI API version used when none is given.
+const DefaultAzureAPIVersion = "2024-10-21"
+
+// Azure addresses a deployment of an Azure OpenAI resource instead of the OpenAI API.
+// The model of the request names the deployment.
+type Azure struct {
+	// Endpoint is the URL of the resource, e.g. https://example.openai.azure.com.
+	Endpoint string
+	// APIVersion is the api-version query parameter of every request.
+	APIVersion string
+	// Bearer sends the key as a Microsoft Entra ID bearer token instead of an api-key header.
+	Bearer bool
+}
+
+// enabled reports whether the requests go to Azure.
+func (a Azure) enabled() bool {
+	return a.Endpoint != ""
+}
+
+// options returns the client options sending requests for the deployment to the resource.
+func (a Azure) options(key, deployment string) ([]option.RequestOption, error) {
+	if deployment == "" {
+		return nil, errors.New("azure needs the name of a deployment")
+	}
+	endpoint, err := url.Parse(a.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, errors.New("azure endpoint must be a URL such as https://example.openai.azure.com")
+	}
+	version := a.APIVersion
+	if version == "" {
+		version = DefaultAzureAPIVersion
+	}
+
+	options := []option.RequestOption{
+		option.WithBaseURL(strings.TrimSuffix(endpoint.String(), "/") + "/openai/deployments/" + url.PathEscape(deployment) + "/"),
+		option.WithQuery("api-version", version),
+		// The OpenAI credentials of the environment must not reach Azure.
+		option.WithHeaderDel("authorization"),
+		option.WithHeaderDel("openai-organization"),
+		option.WithHeaderDel("openai-project"),
+	}
+	if a.Bearer {
+		return append(options, option.WithAPIKey(key)), nil
+	}
+	return append(options, option.WithHeader("api-key", key)), nil
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// azureRequest is what the Azure stand-in received.
+type azureRequest struct {
+	path, apiVersion, apiKey, authorization string
+	messages                                int
+}
+
+// newAzureServer stands in for the chat completions of an Azure OpenAI resource.
+// It answers with the number of messages it received.
+func newAzureServer(t *testing.T) (*httptest.Server, *[]azureRequest) {
+	t.Helper()
+	var received []azureRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []json.RawMessage `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received = append(received, azureRequest{
+			path:          r.URL.Path,
+			apiVersion:    r.URL.Query().Get("api-version"),
+			apiKey:        r.Header.Get("Api-Key"),
+			authorization: r.Header.Get("Authorization"),
+			messages:      len(body.Messages),
+		})
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o-2024-08-06",`+
+			`"choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"%d messages"}}],`+
+			`"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}`, len(body.Messages))
+	})
+	return standIn(t, mux), &received
+}
+
+func TestAzure(t *testing.T) {
+	t.Parallel()
+	srv, received := newAzureServer(t)
+	cmd := testCommand(t)
+
+	opts := Options{APIKey: "azure-key", HTTPClient: srv.Client(), Model: "prod gpt-4o", Azure: Azure{Endpoint: srv.URL + "/"}}
+	h, err := NewDefaultHandler(cmd, opts)
+	require.NoError(t, err)
+	res, err := h.Handle(context.Background(), cmd, "What is Go?")
+	require.NoError(t, err)
+	assert.Equal(t, "2 messages", res.Content)
+
+	opts.Azure.APIVersion = "2025-01-01-preview"
+	opts.Azure.Bearer = true
+	h, err = NewDefaultHandler(cmd, opts)
+	require.NoError(t, err)
+	_, err = h.Handle(context.Background(), cmd, "What is Go?")
+	require.NoError(t, err)
+
+	require.Len(t, *received, 2)
+	assert.Equal(t, azureRequest{
+		path:       "/openai/deployments/prod gpt-4o/chat/completions",
+		apiVersion: DefaultAzureAPIVersion,
+		apiKey:     "azure-key",
+		messages:   2,
+	}, (*received)[0])
+	assert.Equal(t, azureRequest{
+		path:          "/openai/deployments/prod gpt-4o/chat/completions",
+		apiVersion:    "2025-01-01-preview",
+		authorization: "Bearer azure-key",
+		messages:      2,
+	}, (*received)[1])
+}
+
+func TestAzureChatHistory(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("OPENAI_API_KEY", "sk-must-not-reach-azure")
+	srv, received := newAzureServer(t)
+	cmd := testCommand(t, "--chat", "shared")
+	opts := Options{APIKey: "azure-key", HTTPClient: srv.Client(), Model: "prod", Azure: Azure{Endpoint: srv.URL}}
+
+	h, err := NewChatHandler(cmd, "shared", opts)
+	require.NoError(t, err)
+	_, err = h.Handle(context.Background(), cmd, "What is Go?")
+	require.NoError(t, err)
+
+	// The conversation continues from the same chat cache as the other platforms.
+	session, err := openChatSession("shared", Options{})
+	require.NoError(t, err)
+	params, err := session.read("shared")
+	require.NoError(t, err)
+	assert.Len(t, params.Messages.Value, 2)
+
+	res, err := h.Handle(context.Background(), cmd, "Who designed it?")
+	require.NoError(t, err)
+	assert.Equal(t, "4 messages", res.Content)
+	require.Len(t, *received, 2)
+	assert.Empty(t, (*received)[1].authorization)
+}
+
+func TestAzureInvalidOptions(t *testing.T) {
+	t.Parallel()
+	cmd := testCommand(t)
+	_, err := NewDefaultHandler(cmd, Options{APIKey: "azure-key", Azure: Azure{Endpoint: "https://example.openai.azure.com"}})
+	assert.ErrorContains(t, err, "needs the name of a deployment")
+	_, err = NewDefaultHandler(cmd, Options{APIKey: "azure-key", Model: "prod", Azure: Azure{Endpoint: "example.openai.azure.com"}})
+	assert.ErrorContains(t, err, "must be a URL")
+}
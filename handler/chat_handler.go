@@ -3,10 +3,15 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"log/slog"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	sgptrole "github.com/hirosassa/sgpt/role"
 	"github.com/openai/openai-go"
@@ -19,6 +24,9 @@ const cacheUmask = 0o700
 // It is designed to store cached messages in a specified directory and in JSON format.
 type ChatSession struct {
 	storagePath string
+	// replaceLastTurn leaves the last turn out of the conversation sent with the next prompt,
+	// so that storing the answer replaces it. The chat file is unchanged until then.
+	replaceLastTurn bool
 }
 
 func NewChatSession(storagePath string) (*ChatSession, error) {
@@ -32,29 +40,37 @@ func NewChatSession(storagePath string) (*ChatSession, error) {
 	}, nil
 }
 
-func (c *ChatSession) Wrap(fn func(ctx context.Context, cmd *cli.Command, params openai.ChatCompletionNewParams) (openai.ChatCompletionMessage, error)) func(ctx context.Context, cmd *cli.Command, params openai.ChatCompletionNewParams) (openai.ChatCompletionMessage, error) {
-	return func(ctx context.Context, cmd *cli.Command, params openai.ChatCompletionNewParams) (openai.ChatCompletionMessage, error) {
+func (c *ChatSession) Wrap(fn func(ctx context.Context, cmd *cli.Command, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error)) func(ctx context.Context, cmd *cli.Command, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	return func(ctx context.Context, cmd *cli.Command, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
 		chatID := cmd.String("chat")
 		if chatID == "" {
 			return fn(ctx, cmd, params)
 		}
+		defer c.lock(chatID)()
 
-		previousParams, err := c.read(chatID)
+		previous, err := c.history(chatID)
 		if err != nil {
-			return openai.ChatCompletionMessage{}, err
+			return nil, err
 		}
 
-		params.Messages.Value = append(previousParams.Messages.Value, params.Messages.Value...)
-		message, err := fn(ctx, cmd, params)
+		params.Messages.Value = append(marshalMessages(previous), params.Messages.Value...)
+		completion, err := fn(ctx, cmd, params)
 		if err != nil {
-			return openai.ChatCompletionMessage{}, err
+			return nil, err
+		}
+		if len(completion.Choices) == 0 {
+			return nil, errors.New("the response has no choices")
 		}
 
-		params.Messages.Value = append(params.Messages.Value, message)
+		if !recorded(ctx) {
+			return completion, nil
+		}
+		params.Messages.Value = append(params.Messages.Value, openai.AssistantMessage(completion.Choices[0].Message.Content))
 		if err := c.write(chatID, params); err != nil {
-			return openai.ChatCompletionMessage{}, err
+			return nil, err
 		}
-		return message, nil
+		c.replaceLastTurn = false
+		return completion, nil
 	}
 }
 
@@ -71,33 +87,76 @@ type Message struct {
 	Role    string      `json:"role"`
 }
 
+// text returns the text content of the message.
+func (m Message) text() string {
+	var content string
+	switch parsed := m.Content.(type) {
+	case string:
+		content = parsed
+	case []interface{}:
+		for _, item := range parsed {
+			if contentMap, ok := item.(map[string]interface{}); ok {
+				content, ok = contentMap["text"].(string)
+				if !ok {
+					content = ""
+				}
+			}
+		}
+	default:
+		content = ""
+	}
+	return content
+}
+
 type Root struct {
 	Messages []Message `json:"messages"`
 	Model    string    `json:"model"`
 }
 
 func (c *ChatSession) read(chatID string) (openai.ChatCompletionNewParams, error) {
+	cache, err := c.readCache(chatID)
+	if err != nil {
+		return openai.ChatCompletionNewParams{}, err
+	}
+
+	messages := marshalMessages(cache)
+	return openai.ChatCompletionNewParams{
+		Messages: openai.F(messages),
+		Model:    openai.F(openai.ChatModelGPT4o),
+	}, nil
+}
+
+// readCache reads the stored messages of the conversation. A missing conversation has none.
+func (c *ChatSession) readCache(chatID string) (Root, error) {
 	filePath := c.storagePath + "/" + chatID
 	if _, err := os.Stat(filePath); err != nil {
 		//lint:ignore nilerr for initial kick
-		return openai.ChatCompletionNewParams{}, nil
+		return Root{}, nil
 	}
 
 	data, err := os.ReadFile(filePath)
 	if err != nil {
-		return openai.ChatCompletionNewParams{}, err
+		return Root{}, err
 	}
 
 	var cache Root
 	if err := json.Unmarshal(data, &cache); err != nil {
-		return openai.ChatCompletionNewParams{}, err
+		return Root{}, err
 	}
+	return cache, nil
+}
 
-	messages := marshalMessages(cache)
-	return openai.ChatCompletionNewParams{
-		Messages: openai.F(messages),
-		Model:    openai.F(openai.ChatModelGPT4o),
-	}, nil
+// history returns the stored messages of the conversation that are sent before the next prompt.
+func (c *ChatSession) history(chatID string) (Root, error) {
+	if !c.replaceLastTurn {
+		return c.readCache(chatID)
+	}
+	cache, i, _, err := c.lastTurn(chatID)
+	if err != nil {
+		return Root{}, err
+	}
+	cache.Messages = cache.Messages[:i]
+	return cache, nil
 }
 
 func marshalMessages(cache Root) []openai.ChatCompletionMessageParamUnion {
@@ -108,23 +167,7 @@ func marshalMessages(cache Root) []openai.ChatCompletionMessageParamUnion {
 	var messages []openai.ChatCompletionMessageParamUnion
 	for _, m := range cache.Messages {
 		role := m.Role
-
-		var content string
-		switch parsed := m.Content.(type) {
-		case string:
-			content = parsed
-		case []interface{}:
-			for _, item := range parsed {
-				if contentMap, ok := item.(map[string]interface{}); ok {
-					content, ok = contentMap["text"].(string)
-					if !ok {
-						content = ""
-					}
-				}
-			}
-		default:
-			content = ""
-		}
+		content := m.text()
 		switch role {
 		case "user":
 			messages = append(messages, openai.UserMessage(content))
@@ -138,6 +181,18 @@ func marshalMessages(cache Root) []openai.ChatCompletionMessageParamUnion {
 	return messages
 }
 
+// chatLocks holds a mutex per chat file. Requests of sgpt serve continue chats
+// concurrently, and a turn written between reading and writing a chat would be lost.
+var chatLocks sync.Map
+
+// lock locks the chat for changes within the process and returns the unlock function.
+func (c *ChatSession) lock(chatID string) func() {
+	v, _ := chatLocks.LoadOrStore(c.storagePath+"/"+chatID, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
 func (c *ChatSession) write(chatID string, params openai.ChatCompletionNewParams) error {
 	data, err := json.Marshal(params)
 	if err != nil {
@@ -145,10 +200,11 @@ func (c *ChatSession) write(chatID string, params openai.ChatCompletionNewParams
 	}
 
 	filePath := c.storagePath + "/" + chatID
-	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, cacheUmask) // always overwrite
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, cacheUmask) // always overwrite
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
 	if _, err := f.Write(data); err != nil {
 		return err
@@ -156,6 +212,87 @@ func (c *ChatSession) write(chatID string, params openai.ChatCompletionNewParams
 	return nil
 }
 
+// replaceLast replaces the content of the last assistant message of the conversation.
+func (c *ChatSession) replaceLast(chatID string, content string) error {
+	defer c.lock(chatID)()
+	params, err := c.read(chatID)
+	if err != nil {
+		return err
+	}
+	messages := params.Messages.Value
+	for i := len(messages) - 1; i >= 0; i-- {
+		if _, ok := messages[i].(openai.ChatCompletionAssistantMessageParam); ok {
+			messages[i] = openai.AssistantMessage(content)
+			return c.write(chatID, params)
+		}
+	}
+	return errors.New("no answer to replace in chat " + chatID)
+}
+
+// lastTurn returns the stored messages of the conversation, the index where its last turn
+// starts and the prompt of that turn. The turn includes the system message sent again
+// before the prompt when there is one.
+func (c *ChatSession) lastTurn(chatID string) (Root, int, string, error) {
+	cache, err := c.readCache(chatID)
+	if err != nil {
+		return Root{}, 0, "", err
+	}
+	if len(cache.Messages) == 0 {
+		return Root{}, 0, "", fmt.Errorf("chat %s has no messages", chatID)
+	}
+
+	i := len(cache.Messages) - 1
+	if cache.Messages[i].Role == "assistant" {
+		i--
+	}
+	if i < 0 || cache.Messages[i].Role != "user" {
+		return Root{}, 0, "", fmt.Errorf("the last turn of chat %s has no prompt", chatID)
+	}
+	prompt := strings.TrimSuffix(cache.Messages[i].text(), "\n\n"+jsonInstruction)
+	if i > 0 && cache.Messages[i-1].Role == "system" {
+		i--
+	}
+	return cache, i, prompt, nil
+}
+
+// dropLastTurn removes the last prompt of the conversation and its answer, and returns the prompt.
+func (c *ChatSession) dropLastTurn(chatID string) (string, error) {
+	defer c.lock(chatID)()
+	cache, i, prompt, err := c.lastTurn(chatID)
+	if err != nil {
+		return "", err
+	}
+	cache.Messages = cache.Messages[:i]
+	params := openai.ChatCompletionNewParams{
+		Messages: openai.F(marshalMessages(cache)),
+		Model:    openai.F(openai.ChatModelGPT4o),
+	}
+	return prompt, c.write(chatID, params)
+}
+
+// fork copies the conversation src to dst, which must not exist yet.
+func (c *ChatSession) fork(src, dst string) error {
+	data, err := os.ReadFile(c.storagePath + "/" + src)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("chat %s does not exist", src)
+	}
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(c.storagePath+"/"+dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, cacheUmask)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("chat %s already exists", dst)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 func (c *ChatSession) invalidate(chatID string) error {
 	filePath := c.storagePath + "/" + chatID
 	if _, err := os.Stat(filePath); err != nil {
@@ -169,41 +306,46 @@ func (c *ChatSession) invalidate(chatID string) error {
 }
 
 func (c *ChatSession) exists(chatID string) bool {
-	data, err := c.read(chatID)
+	defer c.lock(chatID)()
+	cache, err := c.history(chatID)
 	if err != nil {
 		return false
 	}
-	return len(data.Messages.Value) > 0
-}
-
-// codes below are for in the future
-// func (c *ChatSession) list() ([]string, error) {
-// 	files, err := os.ReadDir(c.storagePath)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// 	filePathes := make([]string, len(files))
-// 	sortFilesByModTime(files)
-// 	for i, file := range files {
-// 		filePathes[i] = c.storagePath + "/" + file.Name()
-// 	}
-// 	return filePathes, nil
-// }
-
-// func sortFilesByModTime(files []os.DirEntry) {
-// 	sort.Slice(files, func(i, j int) bool {
-// 		return getModTime(files[i]).After(getModTime(files[j]))
-// 	})
-// }
-
-// func getModTime(file os.DirEntry) time.Time {
-// 	fileInfo, err := file.Info()
-// 	if err != nil {
-// 		return time.Time{}
-// 	}
-// 	return fileInfo.ModTime()
-// }
+	return len(cache.Messages) > 0
+}
+
+// ChatInfo describes a stored conversation.
+type ChatInfo struct {
+	ID string
+	// ModTime is when the conversation was last continued.
+	ModTime time.Time
+	Size    int64
+}
+
+// list returns the stored conversations, most recently continued first.
+func (c *ChatSession) list() ([]ChatInfo, error) {
+	files, err := os.ReadDir(c.storagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	chats := make([]ChatInfo, 0, len(files))
+	for _, file := range files {
+		if !file.Type().IsRegular() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			// removed in the meantime
+			continue
+		}
+		chats = append(chats, ChatInfo{ID: file.Name(), ModTime: info.ModTime(), Size: info.Size()})
+	}
+	sort.SliceStable(chats, func(i, j int) bool {
+		return chats[i].ModTime.After(chats[j].ModTime)
+	})
+	return chats, nil
+}
 
 func createDirectory(storagePath string) error {
 	err := os.MkdirAll(storagePath, cacheUmask)
@@ -213,34 +355,129 @@ func createDirectory(storagePath string) error {
 	return nil
 }
 
+// openChatCache opens the chat cache.
+func openChatCache() (*ChatSession, error) {
+	return NewChatSession(ChatCachePath()) // todo: make this configurable
+}
+
+// openChatSession opens the chat cache, starting over when chatID is the "temp" chat
+// unless its last turn is to be replaced.
+func openChatSession(chatID string, opts Options) (*ChatSession, error) {
+	chatSession, err := openChatCache()
+	if err != nil {
+		return nil, err
+	}
+	chatSession.replaceLastTurn = opts.ReplaceLastTurn
+
+	if chatID == "temp" && !opts.ReplaceLastTurn {
+		if err := chatSession.invalidate(chatID); err != nil {
+			return nil, err
+		}
+	}
+	return chatSession, nil
+}
+
+// ChatCachePath returns the directory holding the stored conversations.
+func ChatCachePath() string {
+	return os.ExpandEnv("$HOME/.config/shell_gpt/chat_cache")
+}
+
+// ChatTurns returns the messages of the stored conversation.
+func ChatTurns(chatID string) ([]Turn, error) {
+	chatSession, err := openChatCache()
+	if err != nil {
+		return nil, err
+	}
+	cache, err := chatSession.readCache(chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat %s: %w", chatID, err)
+	}
+	if len(cache.Messages) == 0 {
+		return nil, fmt.Errorf("chat %s does not exist", chatID)
+	}
+	turns := make([]Turn, 0, len(cache.Messages))
+	for _, m := range cache.Messages {
+		turns = append(turns, Turn{Role: m.Role, Content: m.text()})
+	}
+	return turns, nil
+}
+
+// SaveChat stores the turns as the conversation chatID, replacing it if it exists.
+func SaveChat(chatID string, turns []Turn) error {
+	chatSession, err := openChatCache()
+	if err != nil {
+		return err
+	}
+	params := openai.ChatCompletionNewParams{
+		Messages: openai.F(Options{History: turns}.openAIHistory()),
+		Model:    openai.F(openai.ChatModelGPT4o),
+	}
+	return chatSession.write(chatID, params)
+}
+
+// ListChats returns the stored conversations, most recently continued first.
+func ListChats() ([]ChatInfo, error) {
+	chatSession, err := openChatCache()
+	if err != nil {
+		return nil, err
+	}
+	return chatSession.list()
+}
+
+// ForkChat copies the conversation src to the new conversation dst.
+func ForkChat(src, dst string) error {
+	chatSession, err := openChatCache()
+	if err != nil {
+		return err
+	}
+	return chatSession.fork(src, dst)
+}
+
+// LastChatPrompt returns the last prompt of the conversation.
+func LastChatPrompt(chatID string) (string, error) {
+	chatSession, err := openChatCache()
+	if err != nil {
+		return "", err
+	}
+	_, _, prompt, err := chatSession.lastTurn(chatID)
+	return prompt, err
+}
+
+// UndoChat removes the last prompt of the conversation and its answer, and returns the prompt.
+func UndoChat(chatID string) (string, error) {
+	chatSession, err := openChatCache()
+	if err != nil {
+		return "", err
+	}
+	return chatSession.dropLastTurn(chatID)
+}
+
 type ChatHandler struct {
 	client      *openai.Client
 	role        sgptrole.SystemRole
 	chatID      string
 	chatSession *ChatSession
+	opts        Options
 }
 
-func NewChatHandler(cmd *cli.Command, chatID string) (*ChatHandler, error) {
-	chatCachePath := os.ExpandEnv("$HOME/.config/shell_gpt/chat_cache")
-	chatSession, err := NewChatSession(chatCachePath) // todo: make this configurable
+func NewChatHandler(cmd *cli.Command, chatID string, opts Options) (*ChatHandler, error) {
+	chatSession, err := openChatSession(chatID, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	role, err := sgptrole.CheckGet(cmd.Bool("shell"), cmd.Bool("describe-shell"), cmd.Bool("code"))
+	role, err := opts.role(cmd)
 	if err != nil {
 		return nil, err
 	}
 
-	client, err := getClient()
-	if err != nil {
+	if err := opts.Sampling.Validate(ProviderOpenAI); err != nil {
 		return nil, err
 	}
 
-	if chatID == "temp" {
-		if err := chatSession.invalidate(chatID); err != nil {
-			return nil, err
-		}
+	client, err := getClient(opts)
+	if err != nil {
+		return nil, err
 	}
 
 	return &ChatHandler{
@@ -248,6 +485,7 @@ func NewChatHandler(cmd *cli.Command, chatID string) (*ChatHandler, error) {
 		role:        *role,
 		chatID:      chatID,
 		chatSession: chatSession,
+		opts:        opts,
 	}, nil
 }
 
@@ -255,41 +493,75 @@ func (h *ChatHandler) initiated() bool {
 	return h.chatSession.exists(h.chatID)
 }
 
-func (h *ChatHandler) getCompletion(ctx context.Context, cmd *cli.Command, params openai.ChatCompletionNewParams) (openai.ChatCompletionMessage, error) {
+func (h *ChatHandler) getCompletion(ctx context.Context, cmd *cli.Command, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
 	chatCompletion, err := h.client.Chat.Completions.New(ctx, params)
 	if err != nil {
 		log.Println(err)
-		return openai.ChatCompletionMessage{}, err
+		return nil, err
 	}
-	return chatCompletion.Choices[0].Message, nil
+	return chatCompletion, nil
 }
 
 func (h *ChatHandler) makeParams(prompt string) openai.ChatCompletionNewParams {
-	if h.initiated() {
+	return h.params(h.initiated(), prompt)
+}
+
+// params returns the request for the prompt, without the stored messages of the chat.
+func (h *ChatHandler) params(initiated bool, prompt string) openai.ChatCompletionNewParams {
+	var params openai.ChatCompletionNewParams
+	if initiated {
 		messages := []openai.ChatCompletionMessageParamUnion{
 			openai.SystemMessage(h.role.Role),
-			openai.UserMessage(strings.TrimSpace(prompt)),
+			openai.UserMessage(h.opts.Prompt(prompt)),
 		}
-		return openai.ChatCompletionNewParams{
+		params = openai.ChatCompletionNewParams{
 			Messages: openai.F(messages),
-			Model:    openai.F(openai.ChatModelGPT4o), // todo: make this configurable
+			Model:    openai.F(h.opts.openAIModel()),
+		}
+	} else {
+		params = openai.ChatCompletionNewParams{
+			Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+				openai.UserMessage(h.opts.Prompt(prompt)),
+			}),
+			Model: openai.F(h.opts.openAIModel()),
 		}
 	}
-	return openai.ChatCompletionNewParams{
-		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(strings.TrimSpace(prompt)),
-		}),
-		Model: openai.F(openai.ChatModelGPT4o),
-	}
+	h.opts.applyOpenAI(&params)
+	return params
+}
+
+func (h *ChatHandler) Record(content string) error {
+	return h.chatSession.replaceLast(h.chatID, content)
 }
 
-func (h *ChatHandler) Handle(ctx context.Context, cmd *cli.Command, prompt string) (string, error) {
+func (h *ChatHandler) Handle(ctx context.Context, cmd *cli.Command, prompt string) (*Response, error) {
 	params := h.makeParams(prompt)
 
 	wrappedGetCompletion := h.chatSession.Wrap(h.getCompletion)
-	message, err := wrappedGetCompletion(ctx, cmd, params)
+	start := time.Now()
+	completion, err := wrappedGetCompletion(ctx, cmd, params)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	res := newOpenAIResponse(completion, time.Since(start))
+	res.Role = h.role.Name
+	res.ChatID = h.chatID
+	return res, nil
+}
+
+func (h *ChatHandler) Stream(ctx context.Context, cmd *cli.Command, prompt string, onDelta func(delta string) error) (*Response, error) {
+	params := h.makeParams(prompt)
+
+	wrappedStreamCompletion := h.chatSession.Wrap(func(ctx context.Context, cmd *cli.Command, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+		return streamCompletion(ctx, h.client, params, onDelta)
+	})
+	start := time.Now()
+	completion, err := wrappedStreamCompletion(ctx, cmd, params)
+	if err != nil {
+		return nil, err
 	}
-	return message.Content, nil
+	res := newOpenAIResponse(completion, time.Since(start))
+	res.Role = h.role.Name
+	res.ChatID = h.chatID
+	return res, nil
 }
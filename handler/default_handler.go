@@ -3,7 +3,7 @@ package handler
 import (
 	"context"
 	"log"
-	"strings"
+	"time"
 
 	sgptrole "github.com/hirosassa/sgpt/role"
 	"github.com/openai/openai-go"
@@ -13,15 +13,20 @@ import (
 type DefaultHandler struct {
 	client *openai.Client
 	role   sgptrole.SystemRole
+	opts   Options
 }
 
-func NewDefaultHandler(cmd *cli.Command) (*DefaultHandler, error) {
-	role, err := sgptrole.CheckGet(cmd.Bool("shell"), cmd.Bool("describe-shell"), cmd.Bool("code"))
+func NewDefaultHandler(cmd *cli.Command, opts Options) (*DefaultHandler, error) {
+	role, err := opts.role(cmd)
 	if err != nil {
 		return nil, err
 	}
 
-	client, err := getClient()
+	if err := opts.Sampling.Validate(ProviderOpenAI); err != nil {
+		return nil, err
+	}
+
+	client, err := getClient(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -29,35 +34,54 @@ func NewDefaultHandler(cmd *cli.Command) (*DefaultHandler, error) {
 	return &DefaultHandler{
 		client: client,
 		role:   *role,
+		opts:   opts,
 	}, nil
 }
 
-func (h *DefaultHandler) getCompletion(ctx context.Context, params openai.ChatCompletionNewParams) (openai.ChatCompletionMessage, error) {
+func (h *DefaultHandler) getCompletion(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
 	chatCompletion, err := h.client.Chat.Completions.New(ctx, params)
 	if err != nil {
 		log.Println(err)
-		return openai.ChatCompletionMessage{}, err
+		return nil, err
 	}
-	return chatCompletion.Choices[0].Message, nil
+	return chatCompletion, nil
 }
 
 func (h *DefaultHandler) makeParams(prompt string) openai.ChatCompletionNewParams {
 	messages := []openai.ChatCompletionMessageParamUnion{
 		openai.SystemMessage(h.role.Role),
-		openai.UserMessage(strings.TrimSpace(prompt)),
 	}
+	messages = append(messages, h.opts.openAIHistory()...)
+	messages = append(messages, openai.UserMessage(h.opts.Prompt(prompt)))
 
-	return openai.ChatCompletionNewParams{
+	params := openai.ChatCompletionNewParams{
 		Messages: openai.F(messages),
-		Model:    openai.F(openai.ChatModelGPT4o), // todo: make this configurable
+		Model:    openai.F(h.opts.openAIModel()),
 	}
+	h.opts.applyOpenAI(&params)
+	return params
 }
 
-func (h *DefaultHandler) Handle(ctx context.Context, cmd *cli.Command, prompt string) (string, error) {
+func (h *DefaultHandler) Handle(ctx context.Context, cmd *cli.Command, prompt string) (*Response, error) {
 	params := h.makeParams(prompt)
-	message, err := h.getCompletion(ctx, params)
+	start := time.Now()
+	completion, err := h.getCompletion(ctx, params)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	res := newOpenAIResponse(completion, time.Since(start))
+	res.Role = h.role.Name
+	return res, nil
+}
+
+func (h *DefaultHandler) Stream(ctx context.Context, cmd *cli.Command, prompt string, onDelta func(delta string) error) (*Response, error) {
+	params := h.makeParams(prompt)
+	start := time.Now()
+	completion, err := streamCompletion(ctx, h.client, params, onDelta)
+	if err != nil {
+		return nil, err
 	}
-	return message.Content, nil
+	res := newOpenAIResponse(completion, time.Since(start))
+	res.Role = h.role.Name
+	return res, nil
 }
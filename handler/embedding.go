@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/openai/openai-go"
+)
+
+const (
+	DefaultOpenAIEmbeddingModel = openai.EmbeddingModelTextEmbedding3Small
+	DefaultGeminiEmbeddingModel = "text-embedding-004"
+	// MockEmbeddingModel names the embeddings of the mock provider.
+	MockEmbeddingModel = "mock-hashed-words"
+)
+
+// mockDimensions is the length of the vectors of the mock provider.
+const mockDimensions = 256
+
+// EmbeddingTask is what the embedded texts are used for. Gemini tunes the vectors for it.
+type EmbeddingTask int
+
+const (
+	// RetrievalDocument embeds texts to be searched, e.g. indexed files.
+	RetrievalDocument EmbeddingTask = iota
+	// RetrievalQuery embeds texts searching for documents, e.g. prompts.
+	RetrievalQuery
+)
+
+// Embedder turns texts into vectors whose distance reflects how related the texts are.
+type Embedder interface {
+	// Embed returns a vector for each text, in the same order.
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// Model is the name of the embedding model.
+	Model() string
+}
+
+var _ Embedder = (*OpenAIEmbedder)(nil)
+var _ Embedder = (*GeminiEmbedder)(nil)
+var _ Embedder = (*MockEmbedder)(nil)
+
+// OpenAIEmbedder embeds texts with the OpenAI embeddings API, or with an Azure OpenAI
+// deployment when the Azure options are set.
+type OpenAIEmbedder struct {
+	client *openai.Client
+	model  string
+}
+
+// NewOpenAIEmbedder returns an embedder of opts.Model, text-embedding-3-small by default.
+func NewOpenAIEmbedder(opts Options) (*OpenAIEmbedder, error) {
+	if opts.Model == "" {
+		opts.Model = DefaultOpenAIEmbeddingModel
+	}
+	client, err := getClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &OpenAIEmbedder{client: client, model: opts.Model}, nil
+}
+
+func (e *OpenAIEmbedder) Model() string { return e.model }
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	res, err := e.client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Input:          openai.F[openai.EmbeddingNewParamsInputUnion](openai.EmbeddingNewParamsInputArrayOfStrings(texts)),
+		Model:          openai.F(e.model),
+		EncodingFormat: openai.F(openai.EmbeddingNewParamsEncodingFormatFloat),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Data) != len(texts) {
+		return nil, fmt.Errorf("got %d embeddings for %d texts", len(res.Data), len(texts))
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range res.Data {
+		if d.Index < 0 || int(d.Index) >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		v := make([]float32, len(d.Embedding))
+		for i, x := range d.Embedding {
+			v[i] = float32(x)
+		}
+		vectors[d.Index] = v
+	}
+	return vectors, nil
+}
+
+// GeminiEmbedder embeds texts with the Gemini API.
+type GeminiEmbedder struct {
+	model *genai.EmbeddingModel
+	name  string
+}
+
+// NewGeminiEmbedder returns an embedder of opts.Model, text-embedding-004 by default,
+// for texts used in the task.
+func NewGeminiEmbedder(ctx context.Context, opts Options, task EmbeddingTask) (*GeminiEmbedder, error) {
+	if opts.APIKey == "" {
+		return nil, errors.New("please set api key to SGPT_GEMINI_API_KEY")
+	}
+	if opts.Model == "" {
+		opts.Model = DefaultGeminiEmbeddingModel
+	}
+	client, err := genai.NewClient(ctx, geminiClientOptions(opts)...)
+	if err != nil {
+		return nil, err
+	}
+	model := client.EmbeddingModel(opts.Model)
+	model.TaskType = genai.TaskTypeRetrievalDocument
+	if task == RetrievalQuery {
+		model.TaskType = genai.TaskTypeRetrievalQuery
+	}
+	return &GeminiEmbedder{model: model, name: opts.Model}, nil
+}
+
+func (e *GeminiEmbedder) Model() string { return e.name }
+
+func (e *GeminiEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	batch := e.model.NewBatch()
+	for _, text := range texts {
+		batch.AddContent(genai.Text(text))
+	}
+	res, err := e.model.BatchEmbedContents(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("got %d embeddings for %d texts", len(res.Embeddings), len(texts))
+	}
+	vectors := make([][]float32, len(texts))
+	for i, embedding := range res.Embeddings {
+		vectors[i] = embedding.Values
+	}
+	return vectors, nil
+}
+
+// MockEmbedder embeds texts without any network access by hashing their words, so
+// texts sharing words are close. It is meant for tests and demos.
+type MockEmbedder struct{}
+
+func NewMockEmbedder() *MockEmbedder {
+	return &MockEmbedder{}
+}
+
+func (e *MockEmbedder) Model() string { return MockEmbeddingModel }
+
+func (e *MockEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		v := make([]float32, mockDimensions)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for _, w := range words {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(w))
+			v[h.Sum32()%mockDimensions]++
+		}
+		var norm float64
+		for _, x := range v {
+			norm += float64(x * x)
+		}
+		if norm > 0 {
+			for j := range v {
+				v[j] /= float32(math.Sqrt(norm))
+			}
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
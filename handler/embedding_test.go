@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	pb "cloud.google.com/go/ai/generativelanguage/apiv1beta/generativelanguagepb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// embeddingRequest is what the embeddings stand-in received.
+type embeddingRequest struct {
+	path, authorization, apiKey string
+	// taskTypes are the task types of a Gemini batch, sent as numbers
+	taskTypes []string
+}
+
+// newEmbeddingServer stands in for the embeddings APIs of OpenAI and Gemini. It embeds
+// a text as its length and records the requests.
+func newEmbeddingServer(t *testing.T) (*http.Client, *[]embeddingRequest) {
+	t.Helper()
+	var received []embeddingRequest
+	record := func(r *http.Request, taskTypes []string) {
+		received = append(received, embeddingRequest{
+			path:          r.URL.Path,
+			authorization: r.Header.Get("Authorization"),
+			apiKey:        r.Header.Get("X-Goog-Api-Key"),
+			taskTypes:     taskTypes,
+		})
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/embeddings", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input []string `json:"input"`
+			Model string   `json:"model"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		record(r, nil)
+		data := []map[string]interface{}{}
+		// answered out of order, as allowed by the index of each embedding
+		for i := len(body.Input) - 1; i >= 0; i-- {
+			data = append(data, map[string]interface{}{"object": "embedding", "index": i, "embedding": []float64{float64(len(body.Input[i])), 0.5}})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"object": "list", "data": data, "model": body.Model,
+			"usage": map[string]int{"prompt_tokens": 4, "total_tokens": 4},
+		})
+	})
+	mux.HandleFunc("POST /v1beta/models/{action}", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Requests []struct {
+				Content struct {
+					Parts []struct {
+						Text string `json:"text"`
+					} `json:"parts"`
+				} `json:"content"`
+				TaskType pb.TaskType `json:"taskType"`
+			} `json:"requests"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var taskTypes []string
+		embeddings := []map[string]interface{}{}
+		for _, req := range body.Requests {
+			taskTypes = append(taskTypes, req.TaskType.String())
+			embeddings = append(embeddings, map[string]interface{}{"values": []float64{float64(len(req.Content.Parts[0].Text)), 0.5}})
+		}
+		record(r, taskTypes)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"embeddings": embeddings})
+	})
+	return redirectClient(t, standIn(t, mux)), &received
+}
+
+func TestOpenAIEmbedder(t *testing.T) {
+	t.Parallel()
+	client, requests := newEmbeddingServer(t)
+	e, err := NewOpenAIEmbedder(Options{APIKey: "sk-test", HTTPClient: client})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultOpenAIEmbeddingModel, e.Model())
+
+	vectors, err := e.Embed(context.Background(), []string{"a", "bcd"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{1, 0.5}, {3, 0.5}}, vectors)
+	require.Len(t, *requests, 1)
+	assert.Equal(t, "Bearer sk-test", (*requests)[0].authorization)
+}
+
+func TestGeminiEmbedder(t *testing.T) {
+	t.Parallel()
+	client, requests := newEmbeddingServer(t)
+	e, err := NewGeminiEmbedder(context.Background(), Options{APIKey: "gemini-test", HTTPClient: client}, RetrievalDocument)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultGeminiEmbeddingModel, e.Model())
+
+	vectors, err := e.Embed(context.Background(), []string{"a", "bcd"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{1, 0.5}, {3, 0.5}}, vectors)
+	require.Len(t, *requests, 1)
+	assert.Equal(t, "/v1beta/models/text-embedding-004:batchEmbedContents", (*requests)[0].path)
+	assert.Equal(t, "gemini-test", (*requests)[0].apiKey)
+	assert.Equal(t, []string{"RETRIEVAL_DOCUMENT", "RETRIEVAL_DOCUMENT"}, (*requests)[0].taskTypes)
+
+	e, err = NewGeminiEmbedder(context.Background(), Options{APIKey: "gemini-test", HTTPClient: client}, RetrievalQuery)
+	require.NoError(t, err)
+	_, err = e.Embed(context.Background(), []string{"a"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"RETRIEVAL_QUERY"}, (*requests)[1].taskTypes)
+}
+
+func TestMockEmbedder(t *testing.T) {
+	t.Parallel()
+	vectors, err := NewMockEmbedder().Embed(context.Background(), []string{"Rebase the branch", "the branch, rebased", ""})
+	require.NoError(t, err)
+	require.Len(t, vectors, 3)
+	assert.Len(t, vectors[0], mockDimensions)
+	var dot float32
+	for i := range vectors[0] {
+		dot += vectors[0][i] * vectors[1][i]
+	}
+	assert.InDelta(t, 2.0/3.0, dot, 1e-6, "two of three words are shared")
+	assert.Equal(t, make([]float32, mockDimensions), vectors[2])
+}
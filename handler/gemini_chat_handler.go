@@ -2,47 +2,263 @@ package handler
 
 import (
 	"context"
+	"errors"
+	"io"
+	"net/http"
 	"strings"
+	"time"
 
+	gl "cloud.google.com/go/ai/generativelanguage/apiv1beta"
+	pb "cloud.google.com/go/ai/generativelanguage/apiv1beta/generativelanguagepb"
 	"github.com/google/generative-ai-go/genai"
+	sgptrole "github.com/hirosassa/sgpt/role"
 	"github.com/urfave/cli/v3"
 	"google.golang.org/api/option"
 )
 
 var _ Handler = (*GeminiChatHandler)(nil)
+var _ Streamer = (*GeminiChatHandler)(nil)
 
 type GeminiChatHandler struct {
 	// storagePath string TODO: Implement chat session caching
-	client *genai.Client
+	client *gl.GenerativeClient
 	model  string
+	opts   Options
 }
 
-func NewGeminiChatHandler(ctx context.Context, apiKey string, model string) (*GeminiChatHandler, error) {
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+func NewGeminiChatHandler(ctx context.Context, model string, opts Options) (*GeminiChatHandler, error) {
+	if opts.APIKey == "" {
+		return nil, errors.New("please set api key to SGPT_GEMINI_API_KEY")
+	}
+	if err := opts.Sampling.Validate(ProviderGemini); err != nil {
+		return nil, err
+	}
+
+	// The generative client is used directly: a model of the client library streams
+	// every answer, and its chat session always asks for a single candidate.
+	client, err := gl.NewGenerativeRESTClient(ctx, geminiClientOptions(opts)...)
 	if err != nil {
 		return nil, err
 	}
 	return &GeminiChatHandler{
 		client: client,
 		model:  model,
+		opts:   opts,
 	}, nil
 }
 
 // Handle
-func (h *GeminiChatHandler) Handle(ctx context.Context, cmd *cli.Command, prompt string) (string, error) {
-	model := h.client.GenerativeModel(h.model)
-	session := model.StartChat()
+func (h *GeminiChatHandler) Handle(ctx context.Context, cmd *cli.Command, prompt string) (*Response, error) {
+	role, err := h.opts.role(cmd)
+	if err != nil {
+		return nil, err
+	}
 
-	response, err := session.SendMessage(ctx, genai.Text(prompt))
+	start := time.Now()
+	response, err := h.client.GenerateContent(ctx, h.request(role, prompt))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	res := newGeminiResponse(geminiProtoResponse(response), h.model, time.Since(start))
+	res.Role = role.Name
+	return res, nil
+}
+
+// request returns the request for the prompt to the model of the handler.
+func (h *GeminiChatHandler) request(role *sgptrole.SystemRole, prompt string) *pb.GenerateContentRequest {
+	req := geminiRequest(role, h.opts, prompt)
+	req.Model = geminiModelName(h.model)
+	return req
+}
+
+// geminiRequest returns the request for the prompt, sent after the history with the
+// role as system instruction and the generation config of the options. The model is
+// left to the caller, since Vertex AI names it in the URL instead.
+func geminiRequest(role *sgptrole.SystemRole, opts Options, prompt string) *pb.GenerateContentRequest {
+	contents := opts.geminiHistory()
+	contents = append(contents, &genai.Content{Role: "user", Parts: []genai.Part{genai.Text(opts.Prompt(prompt))}})
 
-	responseMessage := []string{}
-	for _, part := range response.Candidates[0].Content.Parts {
-		if str, ok := part.(genai.Text); ok {
-			responseMessage = append(responseMessage, string(str))
+	var model genai.GenerativeModel
+	opts.applyGemini(&model)
+	c := model.GenerationConfig
+	return &pb.GenerateContentRequest{
+		SystemInstruction: &pb.Content{Parts: []*pb.Part{{Data: &pb.Part_Text{Text: role.Role}}}},
+		Contents:          geminiProtoContents(contents),
+		GenerationConfig: &pb.GenerationConfig{
+			CandidateCount:   c.CandidateCount,
+			StopSequences:    c.StopSequences,
+			MaxOutputTokens:  c.MaxOutputTokens,
+			Temperature:      c.Temperature,
+			TopP:             c.TopP,
+			ResponseMimeType: c.ResponseMIMEType,
+			ResponseSchema:   geminiProtoSchema(c.ResponseSchema),
+		},
+	}
+}
+
+// geminiModelName returns the resource name of a model, e.g. models/gemini-1.5-flash.
+func geminiModelName(model string) string {
+	if strings.ContainsRune(model, '/') {
+		return model
+	}
+	return "models/" + model
+}
+
+// geminiProtoContents converts Gemini contents into their wire form.
+func geminiProtoContents(contents []*genai.Content) []*pb.Content {
+	converted := make([]*pb.Content, 0, len(contents))
+	for _, c := range contents {
+		content := &pb.Content{Role: c.Role}
+		for _, part := range c.Parts {
+			if text, ok := part.(genai.Text); ok {
+				content.Parts = append(content.Parts, &pb.Part{Data: &pb.Part_Text{Text: string(text)}})
+			}
+		}
+		converted = append(converted, content)
+	}
+	return converted
+}
+
+// geminiProtoSchema converts a Gemini schema into its wire form.
+func geminiProtoSchema(s *genai.Schema) *pb.Schema {
+	if s == nil {
+		return nil
+	}
+	converted := &pb.Schema{
+		Type:        pb.Type(s.Type),
+		Format:      s.Format,
+		Description: s.Description,
+		Nullable:    s.Nullable,
+		Enum:        s.Enum,
+		Items:       geminiProtoSchema(s.Items),
+		Required:    s.Required,
+	}
+	if len(s.Properties) > 0 {
+		converted.Properties = make(map[string]*pb.Schema, len(s.Properties))
+		for name, prop := range s.Properties {
+			converted.Properties[name] = geminiProtoSchema(prop)
 		}
 	}
-	return strings.Join(responseMessage, "\n"), nil
+	return converted
+}
+
+// geminiProtoResponse converts a response in wire form into a Gemini response.
+func geminiProtoResponse(response *pb.GenerateContentResponse) *genai.GenerateContentResponse {
+	converted := &genai.GenerateContentResponse{}
+	for _, c := range response.GetCandidates() {
+		candidate := &genai.Candidate{Index: c.GetIndex(), FinishReason: genai.FinishReason(c.GetFinishReason())}
+		if c.Content != nil {
+			candidate.Content = &genai.Content{Role: c.Content.Role}
+			for _, part := range c.Content.Parts {
+				if text := part.GetText(); text != "" {
+					candidate.Content.Parts = append(candidate.Content.Parts, genai.Text(text))
+				}
+			}
+		}
+		converted.Candidates = append(converted.Candidates, candidate)
+	}
+	if u := response.GetUsageMetadata(); u != nil {
+		converted.UsageMetadata = &genai.UsageMetadata{
+			PromptTokenCount:     u.PromptTokenCount,
+			CandidatesTokenCount: u.CandidatesTokenCount,
+			TotalTokenCount:      u.TotalTokenCount,
+		}
+	}
+	return converted
+}
+
+func (h *GeminiChatHandler) Stream(ctx context.Context, cmd *cli.Command, prompt string, onDelta func(delta string) error) (*Response, error) {
+	role, err := h.opts.role(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	stream, err := h.client.StreamGenerateContent(ctx, h.request(role, prompt))
+	if err != nil {
+		return nil, err
+	}
+	merged := &genai.GenerateContentResponse{}
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		response := geminiProtoResponse(chunk)
+		mergeGeminiResponse(merged, response)
+		for _, candidate := range response.Candidates {
+			if candidate.Index != 0 {
+				continue
+			}
+			if delta := geminiText(candidate); delta != "" {
+				if err := onDelta(delta); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	res := newGeminiResponse(merged, h.model, time.Since(start))
+	res.Role = role.Name
+	return res, nil
+}
+
+// mergeGeminiResponse adds a streamed chunk to the response accumulated so far.
+func mergeGeminiResponse(merged, chunk *genai.GenerateContentResponse) {
+	for _, c := range chunk.Candidates {
+		var candidate *genai.Candidate
+		for _, m := range merged.Candidates {
+			if m.Index == c.Index {
+				candidate = m
+			}
+		}
+		if candidate == nil {
+			candidate = &genai.Candidate{Index: c.Index, Content: &genai.Content{Role: "model"}}
+			merged.Candidates = append(merged.Candidates, candidate)
+		}
+		if text := geminiText(c); text != "" {
+			candidate.Content.Parts = append(candidate.Content.Parts, genai.Text(text))
+		}
+		if c.FinishReason != genai.FinishReasonUnspecified {
+			candidate.FinishReason = c.FinishReason
+		}
+	}
+	if chunk.UsageMetadata != nil {
+		merged.UsageMetadata = chunk.UsageMetadata
+	}
+}
+
+// geminiClientOptions returns the client options authenticating with the API key of opts.
+func geminiClientOptions(opts Options) []option.ClientOption {
+	options := []option.ClientOption{option.WithAPIKey(opts.APIKey)}
+	if opts.HTTPClient != nil {
+		auth := &apiKeyTransport{key: opts.APIKey, next: opts.HTTPClient.Transport}
+		options = append(options, withHTTPClient(opts.HTTPClient, auth))
+	}
+	return options
+}
+
+// withHTTPClient returns the option sending the requests of a Google client library
+// with client through auth. A custom HTTP client replaces the authentication of the
+// client library, so auth has to authenticate the requests itself.
+func withHTTPClient(client *http.Client, auth http.RoundTripper) option.ClientOption {
+	return option.WithHTTPClient(&http.Client{Transport: auth, Timeout: client.Timeout})
+}
+
+// apiKeyTransport authenticates Gemini API requests with an API key.
+type apiKeyTransport struct {
+	key  string
+	next http.RoundTripper
+}
+
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Goog-Api-Key", t.key)
+	return next.RoundTrip(req)
 }
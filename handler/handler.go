@@ -3,26 +3,106 @@ package handler
 import (
 	"context"
 	"errors"
-	"os"
 
+	sgptrole "github.com/hirosassa/sgpt/role"
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 	"github.com/urfave/cli/v3"
 )
 
 type Handler interface {
-	Handle(ctx context.Context, cmd *cli.Command, prompt string) (string, error)
+	Handle(ctx context.Context, cmd *cli.Command, prompt string) (*Response, error)
 }
 
-func getClient() (*openai.Client, error) {
-	apiKey := os.Getenv("SGPT_OPENAI_API_KEY")
-	if apiKey == "" {
+// Streamer is implemented by handlers that can deliver the answer while it is generated.
+type Streamer interface {
+	// Stream calls onDelta with each piece of the first candidate as it arrives
+	// and returns the complete response.
+	Stream(ctx context.Context, cmd *cli.Command, prompt string, onDelta func(delta string) error) (*Response, error)
+}
+
+// Recorder is implemented by handlers that keep a conversation history.
+type Recorder interface {
+	// Record replaces the last answer in the history with the given content,
+	// e.g. when the user picked another candidate than the first one.
+	Record(content string) error
+}
+
+type unrecordedKey struct{}
+
+// Unrecorded marks ctx so that a handler keeping a conversation history answers from it
+// without adding the prompt and answer to it, e.g. to retry the last answer.
+func Unrecorded(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unrecordedKey{}, true)
+}
+
+func recorded(ctx context.Context) bool {
+	v, _ := ctx.Value(unrecordedKey{}).(bool)
+	return !v
+}
+
+// RoleFromCommand returns the role selected by the command flags, rendered with
+// the --var variables and the --shell-name override.
+func RoleFromCommand(cmd *cli.Command) (*sgptrole.SystemRole, error) {
+	variables := map[string]string{}
+	if name := cmd.String("shell-name"); name != "" {
+		variables["Shell"] = name
+	}
+	for k, v := range cmd.StringMap("var") {
+		variables[k] = v
+	}
+	return sgptrole.CheckGet(cmd.Bool("shell"), cmd.Bool("describe-shell"), cmd.Bool("code"), variables)
+}
+
+// streamCompletion requests a streamed chat completion and accumulates it.
+func streamCompletion(ctx context.Context, client *openai.Client, params openai.ChatCompletionNewParams, onDelta func(delta string) error) (*openai.ChatCompletion, error) {
+	params.StreamOptions = openai.F(openai.ChatCompletionStreamOptionsParam{
+		IncludeUsage: openai.F(true),
+	})
+	stream := client.Chat.Completions.NewStreaming(ctx, params)
+	defer stream.Close()
+
+	acc := openai.ChatCompletionAccumulator{}
+	for stream.Next() {
+		chunk := stream.Current()
+		acc.AddChunk(chunk)
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 || choice.Delta.Content == "" {
+				continue
+			}
+			if err := onDelta(choice.Delta.Content); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	if len(acc.Choices) == 0 {
+		return nil, errors.New("empty response from the stream")
+	}
+	return &acc.ChatCompletion, nil
+}
+
+func getClient(opts Options) (*openai.Client, error) {
+	if opts.APIKey == "" {
 		return nil, errors.New("please set api key to SGPT_OPENAI_API_KEY")
 	}
 
-	client := openai.NewClient(
-		option.WithAPIKey(apiKey),
-	)
+	options := []option.RequestOption{
+		option.WithAPIKey(opts.APIKey),
+	}
+	if opts.HTTPClient != nil {
+		options = append(options, option.WithHTTPClient(opts.HTTPClient))
+	}
+	if opts.Azure.enabled() {
+		azure, err := opts.Azure.options(opts.APIKey, opts.Model)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, azure...)
+	}
+	client := openai.NewClient(options...)
 
 	return client, nil
 }
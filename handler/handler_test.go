@@ -0,0 +1,286 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hirosassa/sgpt/cassette"
+	sgptrole "github.com/hirosassa/sgpt/role"
+	"github.com/openai/openai-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+)
+
+// testCommand parses args with the role flags handlers read. The role variables are
+// fixed so that the recorded requests do not depend on the machine running the tests.
+func testCommand(t *testing.T, args ...string) *cli.Command {
+	t.Helper()
+	var parsed *cli.Command
+	cmd := &cli.Command{
+		Name: "sgpt",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "shell"},
+			&cli.BoolFlag{Name: "code"},
+			&cli.BoolFlag{Name: "describe-shell"},
+			&cli.StringFlag{Name: "chat"},
+			&cli.StringFlag{Name: "shell-name"},
+			&cli.StringMapFlag{Name: "var"},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			parsed = cmd
+			return nil
+		},
+	}
+	args = append([]string{"sgpt", "--var", "OS=Linux", "--var", "Shell=bash"}, args...)
+	require.NoError(t, cmd.Run(context.Background(), args))
+	return parsed
+}
+
+func TestChatSessionUnrecorded(t *testing.T) {
+	t.Parallel()
+	session, err := NewChatSession(t.TempDir())
+	require.NoError(t, err)
+	cmd := testCommand(t, "--chat", "work")
+
+	answers := []string{"a long first answer", "short"}
+	complete := session.Wrap(func(ctx context.Context, cmd *cli.Command, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+		content := answers[0]
+		answers = answers[1:]
+		return &openai.ChatCompletion{Choices: []openai.ChatCompletionChoice{
+			{Message: openai.ChatCompletionMessage{Role: openai.ChatCompletionMessageRoleAssistant, Content: content}},
+		}}, nil
+	})
+	params := func(prompt string) openai.ChatCompletionNewParams {
+		return openai.ChatCompletionNewParams{
+			Messages: openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage(prompt)}),
+			Model:    openai.F(openai.ChatModelGPT4o),
+		}
+	}
+
+	_, err = complete(context.Background(), cmd, params("question"))
+	require.NoError(t, err)
+	completion, err := complete(Unrecorded(context.Background()), cmd, params("repair"))
+	require.NoError(t, err)
+	assert.Equal(t, "short", completion.Choices[0].Message.Content)
+
+	stored, err := session.read("work")
+	require.NoError(t, err)
+	assert.Len(t, stored.Messages.Value, 2, "the unrecorded exchange is not stored")
+
+	require.NoError(t, session.replaceLast("work", "short"))
+	stored, err = session.read("work")
+	require.NoError(t, err)
+	require.Len(t, stored.Messages.Value, 2)
+	assert.Equal(t, openai.AssistantMessage("short"), stored.Messages.Value[1], "a shorter answer leaves nothing of the old one")
+}
+
+func TestChatSessionEmptyResponse(t *testing.T) {
+	t.Parallel()
+	chatSession, err := NewChatSession(t.TempDir())
+	require.NoError(t, err)
+	cmd := testCommand(t, "--chat", "work")
+	getCompletion := chatSession.Wrap(func(ctx context.Context, cmd *cli.Command, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+		return &openai.ChatCompletion{}, nil
+	})
+	params := openai.ChatCompletionNewParams{Messages: openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("hi")})}
+	_, err = getCompletion(context.Background(), cmd, params)
+	assert.ErrorContains(t, err, "the response has no choices")
+	assert.False(t, chatSession.exists("work"), "nothing is stored")
+}
+
+func TestPreviewChat(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	role := &sgptrole.SystemRole{Name: "ShellGPT", Role: "You are ShellGPT"}
+	session, err := NewChatSession(ChatCachePath())
+	require.NoError(t, err)
+	require.NoError(t, session.write("work", openai.ChatCompletionNewParams{Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+		openai.UserMessage("first"), openai.AssistantMessage("answer"),
+	})}))
+
+	turns, err := Preview(ProviderOpenAI, "work", Options{Role: role}, "second")
+	require.NoError(t, err)
+	assert.Equal(t, []Turn{
+		{Role: "user", Content: "first"},
+		{Role: "assistant", Content: "answer"},
+		{Role: "system", Content: "You are ShellGPT"},
+		{Role: "user", Content: "second"},
+	}, turns)
+
+	turns, err = Preview(ProviderOpenAI, "new", Options{Role: role}, "first")
+	require.NoError(t, err)
+	assert.Equal(t, []Turn{{Role: "user", Content: "first"}}, turns, "a new chat starts without the system message")
+
+	turns, err = Preview(ProviderOpenAI, "", Options{Role: role}, "first")
+	require.NoError(t, err)
+	assert.Equal(t, []Turn{{Role: "system", Content: "You are ShellGPT"}, {Role: "user", Content: "first"}}, turns)
+}
+
+func TestChatSessionConcurrentTurns(t *testing.T) {
+	t.Parallel()
+	chatSession, err := NewChatSession(t.TempDir())
+	require.NoError(t, err)
+	cmd := testCommand(t, "--chat", "work")
+	getCompletion := chatSession.Wrap(func(ctx context.Context, cmd *cli.Command, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+		// give other requests the time to read the chat
+		time.Sleep(time.Millisecond)
+		return &openai.ChatCompletion{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "ok"}}}}, nil
+	})
+
+	const turns = 8
+	var wg sync.WaitGroup
+	for range turns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			params := openai.ChatCompletionNewParams{Messages: openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("hi")})}
+			_, err := getCompletion(context.Background(), cmd, params)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	params, err := chatSession.read("work")
+	require.NoError(t, err)
+	assert.Len(t, params.Messages.Value, 2*turns, "no turn is lost")
+}
+
+func TestGeminiSystemInstruction(t *testing.T) {
+	t.Parallel()
+	srv, requests := newCompletionServer(t)
+	cmd := testCommand(t, "--shell")
+	role, err := RoleFromCommand(cmd)
+	require.NoError(t, err)
+	history := []Turn{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}}
+	_, err = geminiStandInHandler(t, srv, Options{History: history}).Handle(context.Background(), cmd, "list files")
+	require.NoError(t, err)
+
+	body := requests.body()
+	instruction := body["systemInstruction"].(map[string]interface{})["parts"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, role.Role, instruction["text"])
+	assert.Len(t, body["contents"], 3, "the history is sent before the prompt")
+
+	turns, err := Preview(ProviderGemini, "", Options{Role: role, History: history}, "list files")
+	require.NoError(t, err)
+	assert.Equal(t, append(append([]Turn{{Role: "system", Content: role.Role}}, history...), Turn{Role: "user", Content: "list files"}), turns)
+
+	// the role of the options, e.g. of a batch item, is used over the command flags
+	code := &sgptrole.SystemRole{Name: "Code Generator", Role: "Only code."}
+	_, err = geminiStandInHandler(t, srv, Options{Role: code}).Handle(context.Background(), cmd, "sort a slice")
+	require.NoError(t, err)
+	instruction = requests.body()["systemInstruction"].(map[string]interface{})["parts"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "Only code.", instruction["text"])
+}
+
+// replayOptions returns options answering from the cassettes in testdata/name.
+func replayOptions(t *testing.T, name string) Options {
+	t.Helper()
+	replayer, err := cassette.NewReplayer("testdata/" + name)
+	require.NoError(t, err)
+	return Options{APIKey: "sk-test-key", HTTPClient: &http.Client{Transport: replayer}}
+}
+
+const answer = "Go is a statically typed, compiled language designed at Google."
+
+func TestDefaultHandler(t *testing.T) {
+	t.Parallel()
+	cmd := testCommand(t)
+	h, err := NewDefaultHandler(cmd, replayOptions(t, "openai"))
+	require.NoError(t, err)
+
+	res, err := h.Handle(context.Background(), cmd, "What is Go?")
+	require.NoError(t, err)
+	assert.Equal(t, answer, res.Content)
+	assert.Equal(t, ProviderOpenAI, res.Provider)
+	assert.Equal(t, "gpt-4o-2024-08-06", res.Model)
+	assert.Equal(t, "stop", res.FinishReason)
+	assert.Equal(t, Usage{PromptTokens: 52, CompletionTokens: 12, TotalTokens: 64}, res.Usage)
+	assert.Equal(t, "ShellGPT", res.Role)
+
+	var deltas []string
+	res, err = h.Stream(context.Background(), cmd, "What is Go?", func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, answer, res.Content)
+	assert.Equal(t, answer, strings.Join(deltas, ""))
+	assert.Len(t, deltas, 2)
+	assert.Equal(t, "stop", res.FinishReason)
+	assert.Equal(t, int64(64), res.Usage.TotalTokens)
+}
+
+func TestDefaultHandlerUnrecorded(t *testing.T) {
+	t.Parallel()
+	cmd := testCommand(t)
+	h, err := NewDefaultHandler(cmd, replayOptions(t, "openai"))
+	require.NoError(t, err)
+
+	_, err = h.Handle(context.Background(), cmd, "What is Rust?")
+	assert.ErrorContains(t, err, "no interaction recorded")
+}
+
+func TestChatHandler(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cmd := testCommand(t, "--chat", "test")
+	h, err := NewChatHandler(cmd, "test", replayOptions(t, "chat"))
+	require.NoError(t, err)
+
+	res, err := h.Handle(context.Background(), cmd, "What is Go?")
+	require.NoError(t, err)
+	assert.Equal(t, answer, res.Content)
+	assert.Equal(t, "test", res.ChatID)
+
+	// The second request only matches the cassette when it carries the first exchange.
+	res, err = h.Handle(context.Background(), cmd, "Who designed it?")
+	require.NoError(t, err)
+	assert.Equal(t, "Robert Griesemer, Rob Pike and Ken Thompson.", res.Content)
+}
+
+func TestMissingAPIKey(t *testing.T) {
+	t.Parallel()
+	cmd := testCommand(t)
+	_, err := NewDefaultHandler(cmd, Options{})
+	assert.ErrorContains(t, err, "SGPT_OPENAI_API_KEY")
+	_, err = NewGeminiChatHandler(context.Background(), DefaultGeminiModel, Options{})
+	assert.ErrorContains(t, err, "SGPT_GEMINI_API_KEY")
+}
+
+func TestChatHistoryChanges(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cmd := testCommand(t, "--chat", "work")
+	h, err := NewMockHandler(cmd, "work", MockEcho, nil, Options{JSON: true})
+	require.NoError(t, err)
+	for _, prompt := range []string{"first", "second"} {
+		_, err = h.Handle(context.Background(), cmd, prompt)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, ForkChat("work", "alt"))
+	assert.ErrorContains(t, ForkChat("work", "alt"), "chat alt already exists")
+	assert.ErrorContains(t, ForkChat("missing", "other"), "chat missing does not exist")
+
+	// The JSON instruction is added again when the prompt is sent again.
+	prompt, err := LastChatPrompt("work")
+	require.NoError(t, err)
+	assert.Equal(t, "second", prompt)
+	prompt, err = UndoChat("work")
+	require.NoError(t, err)
+	assert.Equal(t, "second", prompt)
+	prompt, err = UndoChat("work")
+	require.NoError(t, err)
+	assert.Equal(t, "first", prompt)
+	_, err = UndoChat("work")
+	assert.ErrorContains(t, err, "chat work has no messages")
+
+	// The fork keeps the whole conversation.
+	chatSession, err := openChatCache()
+	require.NoError(t, err)
+	params, err := chatSession.read("alt")
+	require.NoError(t, err)
+	assert.Len(t, params.Messages.Value, 5)
+}
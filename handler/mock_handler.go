@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	sgptrole "github.com/hirosassa/sgpt/role"
+	"github.com/openai/openai-go"
+	"github.com/urfave/cli/v3"
+)
+
+// Modes of the mock provider.
+const (
+	// MockEcho answers with the prompt.
+	MockEcho = "echo"
+	// MockCanned answers with the first canned response whose pattern matches the prompt.
+	MockCanned = "canned"
+	// MockRole answers with the rendered system role and every message of the request.
+	MockRole = "role"
+)
+
+// CannedResponse is the answer of the mock provider to prompts matching Pattern.
+type CannedResponse struct {
+	Pattern  string `json:"pattern"`
+	Response string `json:"response"`
+
+	re *regexp.Regexp
+}
+
+// LoadCannedResponses reads a JSON list of canned responses, e.g.
+// [{"pattern": "(?i)list files", "response": "ls -la"}].
+func LoadCannedResponses(path string) ([]CannedResponse, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var canned []CannedResponse
+	if err := json.Unmarshal(data, &canned); err != nil {
+		return nil, fmt.Errorf("failed to parse canned responses %s: %w", path, err)
+	}
+	for i := range canned {
+		canned[i].re, err = regexp.Compile(canned[i].Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of canned response %d: %w", i+1, err)
+		}
+	}
+	return canned, nil
+}
+
+var _ Handler = (*MockHandler)(nil)
+var _ Streamer = (*MockHandler)(nil)
+
+// MockHandler answers without any network access. It builds the same requests as the
+// OpenAI handlers, including the chat cache, so roles, templates and chats can be
+// tested end to end without spending tokens.
+type MockHandler struct {
+	role        sgptrole.SystemRole
+	mode        string
+	canned      []CannedResponse
+	chatID      string
+	chatSession *ChatSession
+	opts        Options
+}
+
+func NewMockHandler(cmd *cli.Command, chatID string, mode string, canned []CannedResponse, opts Options) (*MockHandler, error) {
+	switch mode {
+	case MockEcho, MockRole:
+	case MockCanned:
+		if len(canned) == 0 {
+			return nil, errors.New("the canned mock mode needs canned responses")
+		}
+	default:
+		return nil, fmt.Errorf("unknown mock mode %q, must be one of: echo, canned, role", mode)
+	}
+
+	role, err := opts.role(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := opts.Sampling.Validate(ProviderMock); err != nil {
+		return nil, err
+	}
+
+	h := &MockHandler{
+		role:   *role,
+		mode:   mode,
+		canned: canned,
+		chatID: chatID,
+		opts:   opts,
+	}
+	if chatID != "" {
+		h.chatSession, err = openChatSession(chatID, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+func (h *MockHandler) makeParams(prompt string) openai.ChatCompletionNewParams {
+	if h.chatSession != nil {
+		chat := &ChatHandler{role: h.role, chatID: h.chatID, chatSession: h.chatSession, opts: h.opts}
+		return chat.makeParams(prompt)
+	}
+	return (&DefaultHandler{role: h.role, opts: h.opts}).makeParams(prompt)
+}
+
+// getCompletion answers the request locally.
+func (h *MockHandler) getCompletion(ctx context.Context, cmd *cli.Command, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	var request Root
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, err
+	}
+
+	content, err := h.answer(request.Messages)
+	if err != nil {
+		return nil, err
+	}
+
+	var promptTokens int64
+	for _, m := range request.Messages {
+		promptTokens += int64(len(strings.Fields(m.text())))
+	}
+	completionTokens := int64(len(strings.Fields(content)))
+
+	model := h.opts.Model
+	if model == "" {
+		model = ProviderMock
+	}
+	n := h.opts.N
+	if n < 1 {
+		n = 1
+	}
+	completion := &openai.ChatCompletion{
+		ID:     "chatcmpl-mock",
+		Object: openai.ChatCompletionObjectChatCompletion,
+		Model:  model,
+		Usage: openai.CompletionUsage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens * n,
+			TotalTokens:      promptTokens + completionTokens*n,
+		},
+	}
+	for i := int64(0); i < n; i++ {
+		completion.Choices = append(completion.Choices, openai.ChatCompletionChoice{
+			Index:        i,
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatCompletionMessageRoleAssistant, Content: content},
+			FinishReason: openai.ChatCompletionChoicesFinishReasonStop,
+		})
+	}
+	return completion, nil
+}
+
+func (h *MockHandler) answer(messages []Message) (string, error) {
+	prompt := ""
+	if len(messages) > 0 {
+		prompt = messages[len(messages)-1].text()
+	}
+
+	switch h.mode {
+	case MockCanned:
+		for _, c := range h.canned {
+			if c.re.MatchString(prompt) {
+				return c.Response, nil
+			}
+		}
+		return "", fmt.Errorf("no canned response matches the prompt %q", prompt)
+	case MockRole:
+		parts := make([]string, 0, len(messages))
+		for _, m := range messages {
+			parts = append(parts, "["+m.Role+"]\n"+m.text())
+		}
+		return strings.Join(parts, "\n\n"), nil
+	default:
+		return prompt, nil
+	}
+}
+
+func (h *MockHandler) complete(ctx context.Context, cmd *cli.Command, prompt string) (*Response, error) {
+	params := h.makeParams(prompt)
+
+	getCompletion := h.getCompletion
+	if h.chatSession != nil {
+		getCompletion = h.chatSession.Wrap(h.getCompletion)
+	}
+	start := time.Now()
+	completion, err := getCompletion(ctx, cmd, params)
+	if err != nil {
+		return nil, err
+	}
+	res := newOpenAIResponse(completion, time.Since(start))
+	res.Provider = ProviderMock
+	res.Role = h.role.Name
+	res.ChatID = h.chatID
+	return res, nil
+}
+
+func (h *MockHandler) Handle(ctx context.Context, cmd *cli.Command, prompt string) (*Response, error) {
+	return h.complete(ctx, cmd, prompt)
+}
+
+func (h *MockHandler) Stream(ctx context.Context, cmd *cli.Command, prompt string, onDelta func(delta string) error) (*Response, error) {
+	res, err := h.complete(ctx, cmd, prompt)
+	if err != nil {
+		return nil, err
+	}
+	for _, word := range strings.SplitAfter(res.Content, " ") {
+		if err := onDelta(word); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (h *MockHandler) Record(content string) error {
+	if h.chatSession == nil {
+		return nil
+	}
+	return h.chatSession.replaceLast(h.chatID, content)
+}
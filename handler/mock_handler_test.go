@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMockHandlerEcho(t *testing.T) {
+	t.Parallel()
+	cmd := testCommand(t)
+	h, err := NewMockHandler(cmd, "", MockEcho, nil, Options{N: 2})
+	require.NoError(t, err)
+
+	res, err := h.Handle(context.Background(), cmd, "  hello world\n")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", res.Content)
+	assert.Equal(t, []string{"hello world", "hello world"}, res.Candidates)
+	assert.Equal(t, ProviderMock, res.Provider)
+	assert.Equal(t, ProviderMock, res.Model)
+	assert.Equal(t, "stop", res.FinishReason)
+	assert.Equal(t, int64(4), res.Usage.CompletionTokens)
+
+	var deltas []string
+	res, err = h.Stream(context.Background(), cmd, "hello world", func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hello ", "world"}, deltas)
+	assert.Equal(t, "hello world", res.Content)
+}
+
+func TestMockHandlerCanned(t *testing.T) {
+	t.Parallel()
+	canned, err := LoadCannedResponses("testdata/canned.json")
+	require.NoError(t, err)
+	cmd := testCommand(t, "--shell")
+	h, err := NewMockHandler(cmd, "", MockCanned, canned, Options{})
+	require.NoError(t, err)
+
+	res, err := h.Handle(context.Background(), cmd, "List all files")
+	require.NoError(t, err)
+	assert.Equal(t, "ls -la", res.Content)
+	assert.Equal(t, "Shell Command Generator", res.Role)
+
+	res, err = h.Handle(context.Background(), cmd, "show disk usage")
+	require.NoError(t, err)
+	assert.Equal(t, "df -h", res.Content)
+
+	_, err = h.Handle(context.Background(), cmd, "reboot")
+	assert.ErrorContains(t, err, "no canned response")
+}
+
+func TestMockHandlerRole(t *testing.T) {
+	t.Parallel()
+	cmd := testCommand(t, "--code")
+	h, err := NewMockHandler(cmd, "", MockRole, nil, Options{
+		History: []Turn{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}},
+	})
+	require.NoError(t, err)
+
+	res, err := h.Handle(context.Background(), cmd, "fizzbuzz in Go")
+	require.NoError(t, err)
+	parts := strings.Split(res.Content, "\n\n")
+	require.Len(t, parts, 4)
+	assert.True(t, strings.HasPrefix(parts[0], "[system]\nYou are Code Generator\n"))
+	assert.Equal(t, []string{"[user]\nhi", "[assistant]\nhello", "[user]\nfizzbuzz in Go"}, parts[1:])
+}
+
+func TestMockHandlerChat(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cmd := testCommand(t, "--chat", "mock")
+	h, err := NewMockHandler(cmd, "mock", MockRole, nil, Options{})
+	require.NoError(t, err)
+
+	_, err = h.Handle(context.Background(), cmd, "first")
+	require.NoError(t, err)
+	res, err := h.Handle(context.Background(), cmd, "second")
+	require.NoError(t, err)
+	assert.Equal(t, "mock", res.ChatID)
+	// The second request carries the first exchange from the chat cache.
+	assert.True(t, strings.HasPrefix(res.Content, "[user]\nfirst\n\n[assistant]\n"))
+	assert.True(t, strings.HasSuffix(res.Content, "[user]\nsecond"))
+}
+
+func TestNewMockHandler(t *testing.T) {
+	t.Parallel()
+	cmd := testCommand(t)
+	_, err := NewMockHandler(cmd, "", "parrot", nil, Options{})
+	assert.ErrorContains(t, err, "unknown mock mode")
+	_, err = NewMockHandler(cmd, "", MockCanned, nil, Options{})
+	assert.ErrorContains(t, err, "needs canned responses")
+}
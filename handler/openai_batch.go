@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// batchEndpoint is the endpoint the requests of a batch are sent to.
+const batchEndpoint = openai.BatchNewParamsEndpointV1ChatCompletions
+
+// OpenAIBatch runs chat completions through the OpenAI Batch API, which answers
+// within 24 hours at a lower price than synchronous requests.
+type OpenAIBatch struct {
+	client *openai.Client
+}
+
+func NewOpenAIBatch(opts Options) (*OpenAIBatch, error) {
+	if opts.Azure.enabled() {
+		return nil, errors.New("the batch API is only supported on openai")
+	}
+	client, err := getClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &OpenAIBatch{client: client}, nil
+}
+
+// batchRequest is a line of the input file of a batch.
+type batchRequest struct {
+	CustomID string                         `json:"custom_id"`
+	Method   string                         `json:"method"`
+	URL      string                         `json:"url"`
+	Body     openai.ChatCompletionNewParams `json:"body"`
+}
+
+// BatchRequest returns the line of a batch input file requesting the completion of
+// prompt as Handle would, identified by id in the results.
+func (h *DefaultHandler) BatchRequest(id, prompt string) ([]byte, error) {
+	return json.Marshal(batchRequest{
+		CustomID: id,
+		Method:   "POST",
+		URL:      string(batchEndpoint),
+		Body:     h.makeParams(prompt),
+	})
+}
+
+// Submit uploads the requests made by BatchRequest and starts a batch of them.
+// It returns the ID of the batch.
+func (b *OpenAIBatch) Submit(ctx context.Context, requests [][]byte) (string, error) {
+	input := bytes.Join(requests, []byte("\n"))
+	file, err := b.client.Files.New(ctx, openai.FileNewParams{
+		File:    openai.FileParam(bytes.NewReader(input), "sgpt-batch.jsonl", "application/jsonl"),
+		Purpose: openai.F(openai.FilePurposeBatch),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload the batch input: %w", err)
+	}
+	batch, err := b.client.Batches.New(ctx, openai.BatchNewParams{
+		CompletionWindow: openai.F(openai.BatchNewParamsCompletionWindow24h),
+		Endpoint:         openai.F(batchEndpoint),
+		InputFileID:      openai.F(file.ID),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create the batch: %w", err)
+	}
+	return batch.ID, nil
+}
+
+// Wait polls the batch every interval until it ends, reporting its progress to
+// onProgress, and returns the results by custom ID. A request that failed has a nil
+// response and an error.
+func (b *OpenAIBatch) Wait(ctx context.Context, id string, interval time.Duration, onProgress func(status string, done, total int64)) (map[string]*Response, map[string]error, error) {
+	for {
+		batch, err := b.client.Batches.Get(ctx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		counts := batch.RequestCounts
+		onProgress(string(batch.Status), counts.Completed+counts.Failed, counts.Total)
+
+		switch batch.Status {
+		case openai.BatchStatusCompleted, openai.BatchStatusExpired, openai.BatchStatusCancelled:
+			// expired and cancelled batches keep the requests completed until then
+			return b.results(ctx, batch)
+		case openai.BatchStatusFailed:
+			if len(batch.Errors.Data) > 0 {
+				return nil, nil, fmt.Errorf("batch %s failed: %s", id, batch.Errors.Data[0].Message)
+			}
+			return nil, nil, fmt.Errorf("batch %s failed", id)
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// batchOutput is a line of the output or error file of a batch.
+type batchOutput struct {
+	CustomID string `json:"custom_id"`
+	Response *struct {
+		StatusCode int             `json:"status_code"`
+		Body       json.RawMessage `json:"body"`
+	} `json:"response"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (b *OpenAIBatch) results(ctx context.Context, batch *openai.Batch) (map[string]*Response, map[string]error, error) {
+	responses := map[string]*Response{}
+	errs := map[string]error{}
+	for _, fileID := range []string{batch.OutputFileID, batch.ErrorFileID} {
+		if fileID == "" {
+			continue
+		}
+		content, err := b.client.Files.Content(ctx, fileID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to download the batch results: %w", err)
+		}
+		err = readBatchOutput(content.Body, responses, errs)
+		content.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return responses, errs, nil
+}
+
+func readBatchOutput(r io.Reader, responses map[string]*Response, errs map[string]error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var out batchOutput
+		if err := json.Unmarshal(scanner.Bytes(), &out); err != nil {
+			return fmt.Errorf("failed to read the batch results: %w", err)
+		}
+		switch {
+		case out.Error != nil:
+			errs[out.CustomID] = fmt.Errorf("%s: %s", out.Error.Code, out.Error.Message)
+		case out.Response == nil:
+			errs[out.CustomID] = errors.New("no response")
+		case out.Response.StatusCode != 200:
+			errs[out.CustomID] = fmt.Errorf("status %d: %s", out.Response.StatusCode, out.Response.Body)
+		default:
+			var completion openai.ChatCompletion
+			if err := json.Unmarshal(out.Response.Body, &completion); err != nil {
+				errs[out.CustomID] = err
+				continue
+			}
+			// the batch API reports no latency of its own
+			responses[out.CustomID] = newOpenAIResponse(&completion, 0)
+		}
+	}
+	return scanner.Err()
+}
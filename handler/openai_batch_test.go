@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	sgptrole "github.com/hirosassa/sgpt/role"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+)
+
+// newBatchServer stands in for the files and batches APIs of OpenAI. The batch is
+// in progress on the first poll and completed on the next, with an answer to
+// request "1" and an error for request "2".
+func newBatchServer(t *testing.T) (*http.Client, *string) {
+	t.Helper()
+	var input string
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/files", func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("file")
+		require.NoError(t, err)
+		data, err := io.ReadAll(file)
+		require.NoError(t, err)
+		input = string(data)
+		assert.Equal(t, "batch", r.FormValue("purpose"))
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"id": "file-in", "object": "file", "purpose": "batch"}))
+	})
+	batch := func(status string, done int) map[string]interface{} {
+		return map[string]interface{}{
+			"id": "batch_1", "object": "batch", "endpoint": "/v1/chat/completions", "status": status,
+			"input_file_id": "file-in", "output_file_id": "file-out", "error_file_id": "file-err",
+			"request_counts": map[string]int{"total": 2, "completed": done, "failed": 0},
+		}
+	}
+	mux.HandleFunc("POST /v1/batches", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, map[string]string{"completion_window": "24h", "endpoint": "/v1/chat/completions", "input_file_id": "file-in"}, body)
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(batch("validating", 0)))
+	})
+	mux.HandleFunc("GET /v1/batches/batch_1", func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		w.Header().Set("Content-Type", "application/json")
+		if polls == 1 {
+			require.NoError(t, json.NewEncoder(w).Encode(batch("in_progress", 1)))
+			return
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(batch("completed", 2)))
+	})
+	mux.HandleFunc("GET /v1/files/file-out/content", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"custom_id": "1", "response": {"status_code": 200, "body": {"id": "chatcmpl-1", "object": "chat.completion", "model": "gpt-4o-mini", `+
+			`"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "ls -la"}}], `+
+			`"usage": {"prompt_tokens": 20, "completion_tokens": 3, "total_tokens": 23}}}, "error": null}`+"\n")
+	})
+	mux.HandleFunc("GET /v1/files/file-err/content", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"custom_id": "2", "response": {"status_code": 400, "body": {"error": {"message": "invalid model"}}}, "error": null}`+"\n")
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	to, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return &http.Client{Transport: redirectTransport{to: to}}, &input
+}
+
+func TestOpenAIBatch(t *testing.T) {
+	t.Parallel()
+	client, input := newBatchServer(t)
+	opts := Options{APIKey: "sk-test", HTTPClient: client, Model: "gpt-4o-mini", Role: &sgptrole.SystemRole{Name: "Shell Command Generator", Role: "Provide only commands."}}
+	h, err := NewDefaultHandler(&cli.Command{}, opts)
+	require.NoError(t, err)
+	request, err := h.BatchRequest("1", "list files")
+	require.NoError(t, err)
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(request, &line))
+	assert.Equal(t, "1", line["custom_id"])
+	assert.Equal(t, "/v1/chat/completions", line["url"])
+	assert.Equal(t, "gpt-4o-mini", line["body"].(map[string]interface{})["model"])
+
+	b, err := NewOpenAIBatch(opts)
+	require.NoError(t, err)
+	id, err := b.Submit(context.Background(), [][]byte{request, request})
+	require.NoError(t, err)
+	assert.Equal(t, "batch_1", id)
+	assert.Equal(t, 2, strings.Count(*input, `"custom_id":"1"`))
+
+	var progress []string
+	responses, errs, err := b.Wait(context.Background(), id, time.Millisecond, func(status string, done, total int64) {
+		progress = append(progress, status)
+		assert.Equal(t, int64(2), total)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"in_progress", "completed"}, progress)
+	require.Contains(t, responses, "1")
+	assert.Equal(t, "ls -la", responses["1"].Content)
+	assert.Equal(t, int64(23), responses["1"].Usage.TotalTokens)
+	assert.ErrorContains(t, errs["2"], "status 400")
+
+	_, err = NewOpenAIBatch(Options{APIKey: "sk-test", Azure: Azure{Endpoint: "https://example.openai.azure.com"}})
+	assert.ErrorContains(t, err, "only supported on openai")
+}
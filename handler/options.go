@@ -0,0 +1,292 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	sgptrole "github.com/hirosassa/sgpt/role"
+	"github.com/hirosassa/sgpt/schema"
+	"github.com/openai/openai-go"
+	"github.com/urfave/cli/v3"
+)
+
+const jsonInstruction = "Respond only with a valid JSON document without any description or Markdown formatting."
+
+const (
+	DefaultOpenAIModel = openai.ChatModelGPT4o
+	DefaultGeminiModel = "gemini-2.0-flash"
+)
+
+// Turn is a message of a conversation.
+type Turn struct {
+	// Role is one of "system", "user" or "assistant".
+	Role    string
+	Content string
+}
+
+// Options holds the request settings shared by all handlers.
+type Options struct {
+	// APIKey authenticates the requests to the provider.
+	APIKey string
+	// HTTPClient, when set, sends the requests to the provider.
+	HTTPClient *http.Client
+	// Azure, when its endpoint is set, sends the OpenAI requests to Azure OpenAI.
+	Azure Azure
+	// Vertex addresses the Gemini models of a Google Cloud project on Vertex AI.
+	Vertex Vertex
+	// Model overrides the default model of the provider.
+	Model string
+	// Role, when set, is used instead of the role selected by the command flags.
+	Role *sgptrole.SystemRole
+	// History holds earlier turns of the conversation to send before the prompt.
+	History []Turn
+	// ReplaceLastTurn answers the prompt of a chat in place of its last turn, which is
+	// replaced once the answer is stored.
+	ReplaceLastTurn bool
+	// JSON requests the response as a JSON document.
+	JSON bool
+	// Schema constrains the JSON response. It implies JSON.
+	Schema *schema.Schema
+	// Sampling holds the sampling parameters of the request.
+	Sampling Sampling
+	// N is the number of candidates to generate. Zero or one requests a single answer.
+	N int64
+}
+
+// role returns the role of the options, or else the role of the command flags.
+func (o Options) role(cmd *cli.Command) (*sgptrole.SystemRole, error) {
+	if o.Role != nil {
+		return o.Role, nil
+	}
+	return RoleFromCommand(cmd)
+}
+
+func (o Options) openAIModel() openai.ChatModel {
+	if o.Model != "" {
+		return o.Model
+	}
+	return DefaultOpenAIModel
+}
+
+// openAIHistory converts the history into OpenAI messages.
+func (o Options) openAIHistory() []openai.ChatCompletionMessageParamUnion {
+	messages := make([]openai.ChatCompletionMessageParamUnion, 0, len(o.History))
+	for _, t := range o.History {
+		switch t.Role {
+		case "user":
+			messages = append(messages, openai.UserMessage(t.Content))
+		case "assistant":
+			messages = append(messages, openai.AssistantMessage(t.Content))
+		default:
+			messages = append(messages, openai.SystemMessage(t.Content))
+		}
+	}
+	return messages
+}
+
+// geminiHistory converts the history into Gemini contents. Gemini has no system turns,
+// so they are sent as user turns.
+func (o Options) geminiHistory() []*genai.Content {
+	contents := make([]*genai.Content, 0, len(o.History))
+	for _, t := range o.History {
+		role := "user"
+		if t.Role == "assistant" {
+			role = "model"
+		}
+		contents = append(contents, &genai.Content{Role: role, Parts: []genai.Part{genai.Text(t.Content)}})
+	}
+	return contents
+}
+
+func (o Options) jsonMode() bool {
+	return o.JSON || o.Schema != nil
+}
+
+// responseFormat returns the OpenAI response_format for the options, or nil for plain text.
+func (o Options) responseFormat() openai.ChatCompletionNewParamsResponseFormatUnion {
+	if o.Schema != nil {
+		return openai.ResponseFormatJSONSchemaParam{
+			Type: openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
+			JSONSchema: openai.F(openai.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   openai.F(o.Schema.Name),
+				Schema: openai.F[interface{}](o.Schema.Raw),
+				Strict: openai.F(strictSchema(o.Schema.Raw)),
+			}),
+		}
+	}
+	if o.JSON {
+		return openai.ResponseFormatJSONObjectParam{
+			Type: openai.F(openai.ResponseFormatJSONObjectTypeJSONObject),
+		}
+	}
+	return nil
+}
+
+// strictUnsupported lists the keywords OpenAI rejects in strict mode.
+var strictUnsupported = []string{
+	"allOf", "not", "if", "then", "else", "dependentRequired", "dependentSchemas",
+	"patternProperties", "unevaluatedProperties", "propertyNames", "minProperties", "maxProperties",
+	"unevaluatedItems", "contains", "minContains", "maxContains", "uniqueItems",
+}
+
+// strictSchema reports whether OpenAI accepts the schema in strict mode: the root is an
+// object, every object lists all of its properties as required and disallows additional
+// ones, and no unsupported keyword is used. Other schemas are sent without strict and
+// are only validated locally.
+func strictSchema(raw map[string]interface{}) bool {
+	return raw["type"] == "object" && strictCompatible(raw)
+}
+
+func strictCompatible(raw map[string]interface{}) bool {
+	for _, keyword := range strictUnsupported {
+		if _, ok := raw[keyword]; ok {
+			return false
+		}
+	}
+	if props, ok := raw["properties"].(map[string]interface{}); ok || raw["type"] == "object" {
+		if raw["additionalProperties"] != false {
+			return false
+		}
+		required := map[string]bool{}
+		if list, ok := raw["required"].([]interface{}); ok {
+			for _, r := range list {
+				if name, ok := r.(string); ok {
+					required[name] = true
+				}
+			}
+		}
+		for name, prop := range props {
+			p, ok := prop.(map[string]interface{})
+			if !ok || !required[name] || !strictCompatible(p) {
+				return false
+			}
+		}
+	}
+	if items, ok := raw["items"].(map[string]interface{}); ok && !strictCompatible(items) {
+		return false
+	}
+	if list, ok := raw["anyOf"].([]interface{}); ok {
+		for _, sub := range list {
+			if s, ok := sub.(map[string]interface{}); !ok || !strictCompatible(s) {
+				return false
+			}
+		}
+	}
+	for _, key := range []string{"$defs", "definitions"} {
+		if defs, ok := raw[key].(map[string]interface{}); ok {
+			for _, def := range defs {
+				if s, ok := def.(map[string]interface{}); !ok || !strictCompatible(s) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// applyOpenAI sets the options on OpenAI chat completion params.
+func (o Options) applyOpenAI(params *openai.ChatCompletionNewParams) {
+	o.Sampling.applyOpenAI(params)
+	if o.N > 1 {
+		params.N = openai.F(o.N)
+	}
+	if format := o.responseFormat(); format != nil {
+		params.ResponseFormat = openai.F(format)
+	}
+}
+
+// applyGemini sets the options on a Gemini generative model.
+func (o Options) applyGemini(model *genai.GenerativeModel) {
+	o.Sampling.applyGemini(model)
+	if o.N > 1 {
+		model.SetCandidateCount(int32(o.N))
+	}
+	if !o.jsonMode() {
+		return
+	}
+	model.ResponseMIMEType = "application/json"
+	if o.Schema != nil {
+		model.ResponseSchema = geminiSchema(o.Schema.Raw)
+	}
+}
+
+// geminiSchema converts a JSON schema document into the OpenAPI subset accepted by Gemini.
+// Keywords Gemini does not understand are dropped.
+func geminiSchema(raw map[string]interface{}) *genai.Schema {
+	s := &genai.Schema{}
+	switch t := raw["type"].(type) {
+	case string:
+		s.Type = geminiType(t)
+	case []interface{}:
+		// e.g. ["string", "null"]
+		for _, v := range t {
+			name, _ := v.(string)
+			if name == "null" {
+				s.Nullable = true
+				continue
+			}
+			s.Type = geminiType(name)
+		}
+	}
+	if v, ok := raw["description"].(string); ok {
+		s.Description = v
+	}
+	if v, ok := raw["format"].(string); ok {
+		s.Format = v
+	}
+	if v, ok := raw["enum"].([]interface{}); ok {
+		for _, e := range v {
+			if str, ok := e.(string); ok {
+				s.Enum = append(s.Enum, str)
+			}
+		}
+	}
+	if v, ok := raw["items"].(map[string]interface{}); ok {
+		s.Items = geminiSchema(v)
+	}
+	if v, ok := raw["properties"].(map[string]interface{}); ok {
+		s.Properties = make(map[string]*genai.Schema, len(v))
+		for name, prop := range v {
+			if p, ok := prop.(map[string]interface{}); ok {
+				s.Properties[name] = geminiSchema(p)
+			}
+		}
+	}
+	if v, ok := raw["required"].([]interface{}); ok {
+		for _, r := range v {
+			if str, ok := r.(string); ok {
+				s.Required = append(s.Required, str)
+			}
+		}
+	}
+	return s
+}
+
+func geminiType(name string) genai.Type {
+	switch name {
+	case "string":
+		return genai.TypeString
+	case "number":
+		return genai.TypeNumber
+	case "integer":
+		return genai.TypeInteger
+	case "boolean":
+		return genai.TypeBoolean
+	case "array":
+		return genai.TypeArray
+	case "object":
+		return genai.TypeObject
+	default:
+		return genai.TypeUnspecified
+	}
+}
+
+// Prompt returns the user message sent for prompt, decorated according to the options.
+func (o Options) Prompt(prompt string) string {
+	prompt = strings.TrimSpace(prompt)
+	if o.jsonMode() {
+		return prompt + "\n\n" + jsonInstruction
+	}
+	return prompt
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/hirosassa/sgpt/schema"
+	"github.com/openai/openai-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var personSchema = &schema.Schema{Name: "person", Raw: map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"name":     map[string]interface{}{"type": "string", "description": "Full name"},
+		"born":     map[string]interface{}{"type": []interface{}{"integer", "null"}},
+		"role":     map[string]interface{}{"type": "string", "enum": []interface{}{"author", "reviewer"}},
+		"projects": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+	},
+	"required":             []interface{}{"name"},
+	"additionalProperties": false,
+}}
+
+// openAIBody returns the request body of the options as sent to OpenAI.
+func openAIBody(t *testing.T, opts Options) map[string]interface{} {
+	t.Helper()
+	params := openai.ChatCompletionNewParams{
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("hi")}),
+		Model:    openai.F(openai.ChatModelGPT4o),
+	}
+	opts.applyOpenAI(&params)
+	data, err := json.Marshal(params)
+	require.NoError(t, err)
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &body))
+	return body
+}
+
+func TestApplyOpenAI(t *testing.T) {
+	t.Parallel()
+	body := openAIBody(t, Options{})
+	assert.NotContains(t, body, "response_format")
+
+	body = openAIBody(t, Options{JSON: true})
+	assert.Equal(t, map[string]interface{}{"type": "json_object"}, body["response_format"])
+
+	body = openAIBody(t, Options{Schema: personSchema})
+	format := body["response_format"].(map[string]interface{})
+	assert.Equal(t, "json_schema", format["type"])
+	assert.Equal(t, "person", format["json_schema"].(map[string]interface{})["name"])
+	assert.Equal(t, "object", format["json_schema"].(map[string]interface{})["schema"].(map[string]interface{})["type"])
+	assert.Equal(t, false, format["json_schema"].(map[string]interface{})["strict"], "born is optional")
+}
+
+func TestStrictSchema(t *testing.T) {
+	t.Parallel()
+	strict := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{"type": "string"},
+			"tags": map[string]interface{}{"type": "array", "items": map[string]interface{}{
+				"type":                 "object",
+				"properties":           map[string]interface{}{"label": map[string]interface{}{"type": "string"}},
+				"required":             []interface{}{"label"},
+				"additionalProperties": false,
+			}},
+		},
+		"required":             []interface{}{"name", "tags"},
+		"additionalProperties": false,
+	}
+	assert.True(t, strictSchema(strict))
+	assert.False(t, strictSchema(personSchema.Raw), "a property is not required")
+	assert.False(t, strictSchema(map[string]interface{}{"type": "array"}), "the root is not an object")
+
+	open := map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+		"label": map[string]interface{}{"type": "string"},
+	}, "required": []interface{}{"label"}}
+	strict["properties"].(map[string]interface{})["meta"] = open
+	strict["required"] = append(strict["required"].([]interface{}), "meta")
+	assert.False(t, strictSchema(strict), "a nested object allows additional properties")
+
+	open["additionalProperties"] = false
+	assert.True(t, strictSchema(strict))
+	open["minProperties"] = 1
+	assert.False(t, strictSchema(strict), "minProperties is not supported")
+}
+
+func TestApplyGemini(t *testing.T) {
+	t.Parallel()
+	model := &genai.GenerativeModel{}
+	Options{}.applyGemini(model)
+	assert.Empty(t, model.ResponseMIMEType)
+
+	model = &genai.GenerativeModel{}
+	Options{JSON: true}.applyGemini(model)
+	assert.Equal(t, "application/json", model.ResponseMIMEType)
+	assert.Nil(t, model.ResponseSchema)
+
+	model = &genai.GenerativeModel{}
+	Options{Schema: personSchema}.applyGemini(model)
+	assert.Equal(t, "application/json", model.ResponseMIMEType)
+	require.NotNil(t, model.ResponseSchema)
+	assert.Equal(t, genai.TypeObject, model.ResponseSchema.Type)
+}
+
+func TestGeminiSchema(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"name":     {Type: genai.TypeString, Description: "Full name"},
+			"born":     {Type: genai.TypeInteger, Nullable: true},
+			"role":     {Type: genai.TypeString, Enum: []string{"author", "reviewer"}},
+			"projects": {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}},
+		},
+		Required: []string{"name"},
+	}, geminiSchema(personSchema.Raw), "additionalProperties is dropped")
+	assert.Equal(t, &genai.Schema{Type: genai.TypeUnspecified}, geminiSchema(map[string]interface{}{"type": "date"}))
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/openai/openai-go"
+)
+
+// Preview returns the messages a handler of the platform sends for the prompt without
+// contacting the provider. A non-empty chatID continues that chat, as the --chat flag does.
+func Preview(platform, chatID string, opts Options, prompt string) ([]Turn, error) {
+	if opts.Role == nil {
+		return nil, errors.New("the options have no role")
+	}
+	if platform == ProviderGemini || platform == ProviderVertex {
+		// Gemini keeps no chats and sends the role as system instruction
+		turns := append([]Turn{{Role: "system", Content: opts.Role.Role}}, opts.History...)
+		return append(turns, Turn{Role: "user", Content: opts.Prompt(prompt)}), nil
+	}
+	if chatID == "" {
+		return messageTurns((&DefaultHandler{role: *opts.Role, opts: opts}).makeParams(prompt).Messages.Value)
+	}
+
+	chatSession, err := openChatCache()
+	if err != nil {
+		return nil, err
+	}
+	chatSession.replaceLastTurn = opts.ReplaceLastTurn
+	var previous Root
+	// the temp chat starts over with every new prompt
+	if chatID != "temp" || opts.ReplaceLastTurn {
+		if previous, err = chatSession.history(chatID); err != nil {
+			return nil, err
+		}
+	}
+	h := &ChatHandler{role: *opts.Role, chatID: chatID, chatSession: chatSession, opts: opts}
+	params := h.params(len(previous.Messages) > 0, prompt)
+	return messageTurns(append(marshalMessages(previous), params.Messages.Value...))
+}
+
+// messageTurns converts OpenAI messages into turns.
+func messageTurns(messages []openai.ChatCompletionMessageParamUnion) ([]Turn, error) {
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return nil, err
+	}
+	var parsed []Message
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, err
+	}
+	turns := make([]Turn, 0, len(parsed))
+	for _, m := range parsed {
+		turns = append(turns, Turn{Role: m.Role, Content: m.text()})
+	}
+	return turns, nil
+}
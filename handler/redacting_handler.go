@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"context"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+var _ Handler = (*RedactingHandler)(nil)
+var _ Streamer = (*RedactingHandler)(nil)
+var _ Recorder = (*redactingRecorder)(nil)
+
+// RedactingHandler removes secrets from prompts before they reach the wrapped handler,
+// and puts them back into the answers, where the provider only saw placeholders.
+type RedactingHandler struct {
+	next    Handler
+	redact  func(text string) string
+	restore func(text string) string
+}
+
+// NewRedactingHandler wraps next. The result is a Recorder only when next is one, so
+// that callers can tell whether a candidate can be saved to the conversation.
+func NewRedactingHandler(next Handler, redact, restore func(text string) string) Handler {
+	h := &RedactingHandler{next: next, redact: redact, restore: restore}
+	if r, ok := next.(Recorder); ok {
+		return &redactingRecorder{RedactingHandler: h, recorder: r}
+	}
+	return h
+}
+
+func (h *RedactingHandler) Handle(ctx context.Context, cmd *cli.Command, prompt string) (*Response, error) {
+	res, err := h.next.Handle(ctx, cmd, h.redact(prompt))
+	if err != nil {
+		return nil, err
+	}
+	RestoreResponse(res, h.restore)
+	return res, nil
+}
+
+func (h *RedactingHandler) Stream(ctx context.Context, cmd *cli.Command, prompt string, onDelta func(delta string) error) (*Response, error) {
+	s, ok := h.next.(Streamer)
+	if !ok {
+		res, err := h.Handle(ctx, cmd, prompt)
+		if err != nil {
+			return nil, err
+		}
+		return res, onDelta(res.Content)
+	}
+
+	// A placeholder may be split across deltas, so the text from an unclosed bracket on is
+	// held back until the bracket is closed.
+	var held string
+	res, err := s.Stream(ctx, cmd, h.redact(prompt), func(delta string) error {
+		held += delta
+		i := strings.LastIndex(held, "[")
+		if i >= 0 && !strings.Contains(held[i:], "]") && len(held)-i < maxPlaceholderLength {
+			if i == 0 {
+				return nil
+			}
+			delta, held = held[:i], held[i:]
+		} else {
+			delta, held = held, ""
+		}
+		return onDelta(h.restore(delta))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if held != "" {
+		if err := onDelta(h.restore(held)); err != nil {
+			return nil, err
+		}
+	}
+	RestoreResponse(res, h.restore)
+	return res, nil
+}
+
+// maxPlaceholderLength bounds the text held back while streaming, longer than any placeholder.
+const maxPlaceholderLength = 64
+
+// RestoreResponse puts the secrets back into the placeholders of the answers of res.
+func RestoreResponse(res *Response, restore func(text string) string) {
+	res.Content = restore(res.Content)
+	for i, c := range res.Candidates {
+		res.Candidates[i] = restore(c)
+	}
+}
+
+// redactingRecorder is a RedactingHandler wrapping a handler that keeps a conversation history.
+type redactingRecorder struct {
+	*RedactingHandler
+	recorder Recorder
+}
+
+// Record stores the content in the conversation history with its secrets removed again.
+func (h *redactingRecorder) Record(content string) error {
+	return h.recorder.Record(h.redact(content))
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+)
+
+// splitStreamer answers with the prompt, streamed in deltas of three bytes.
+type splitStreamer struct {
+	prompts []string
+}
+
+func (s *splitStreamer) Handle(ctx context.Context, cmd *cli.Command, prompt string) (*Response, error) {
+	s.prompts = append(s.prompts, prompt)
+	return &Response{Content: prompt, Candidates: []string{prompt, "no " + prompt}}, nil
+}
+
+func (s *splitStreamer) Stream(ctx context.Context, cmd *cli.Command, prompt string, onDelta func(delta string) error) (*Response, error) {
+	res, err := s.Handle(ctx, cmd, prompt)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(prompt); i += 3 {
+		if err := onDelta(prompt[i:min(i+3, len(prompt))]); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func TestRedactingHandler(t *testing.T) {
+	t.Parallel()
+	redact := strings.NewReplacer("hunter2", "[SECRET_1]").Replace
+	restore := strings.NewReplacer("[SECRET_1]", "hunter2").Replace
+	next := &splitStreamer{}
+	h := NewRedactingHandler(next, redact, restore).(*RedactingHandler)
+
+	const prompt = "is [hunter2] a good password? [x"
+	res, err := h.Handle(context.Background(), nil, prompt)
+	require.NoError(t, err)
+	assert.Equal(t, prompt, res.Content)
+	assert.Equal(t, []string{prompt, "no " + prompt}, res.Candidates)
+
+	var deltas []string
+	res, err = h.Stream(context.Background(), nil, prompt, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, prompt, res.Content)
+	assert.Equal(t, prompt, strings.Join(deltas, ""), "the placeholder split across deltas is restored")
+	assert.Equal(t, []string{"is [[SECRET_1]] a good password? [x", "is [[SECRET_1]] a good password? [x"}, next.prompts)
+}
+
+func TestRedactingHandlerRecorder(t *testing.T) {
+	t.Parallel()
+	redact := func(text string) string { return text }
+	_, ok := NewRedactingHandler(&GeminiChatHandler{}, redact, redact).(Recorder)
+	assert.False(t, ok, "gemini keeps no conversation to record to")
+	_, ok = NewRedactingHandler(&ChatHandler{}, redact, redact).(Recorder)
+	assert.True(t, ok)
+}
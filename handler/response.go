@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/openai/openai-go"
+)
+
+const (
+	ProviderOpenAI = "openai"
+	ProviderGemini = "gemini"
+	ProviderAzure  = "azure"
+	ProviderVertex = "vertex"
+	ProviderMock   = "mock"
+)
+
+// Response is the provider-agnostic result of a Handle call.
+// Every handler fills it the same way so that callers can treat providers alike.
+type Response struct {
+	Content      string `json:"content"`
+	Provider     string `json:"provider"`
+	Model        string `json:"model"`
+	FinishReason string `json:"finish_reason"`
+	Usage        Usage  `json:"usage"`
+	ChatID       string `json:"chat_id,omitempty"`
+	LatencyMS    int64  `json:"latency_ms"`
+	Role         string `json:"role"`
+	// Candidates holds every generated choice when more than one was requested.
+	// Content is always the first of them.
+	Candidates []string `json:"candidates,omitempty"`
+}
+
+// Usage reports the number of tokens consumed by a request.
+type Usage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+func newOpenAIResponse(completion *openai.ChatCompletion, latency time.Duration) *Response {
+	res := &Response{
+		Provider:  ProviderOpenAI,
+		Model:     completion.Model,
+		LatencyMS: latency.Milliseconds(),
+		Usage: Usage{
+			PromptTokens:     completion.Usage.PromptTokens,
+			CompletionTokens: completion.Usage.CompletionTokens,
+			TotalTokens:      completion.Usage.TotalTokens,
+		},
+	}
+	if len(completion.Choices) > 0 {
+		res.Content = completion.Choices[0].Message.Content
+		res.FinishReason = string(completion.Choices[0].FinishReason)
+	}
+	if len(completion.Choices) > 1 {
+		for _, choice := range completion.Choices {
+			res.Candidates = append(res.Candidates, choice.Message.Content)
+		}
+	}
+	return res
+}
+
+func newGeminiResponse(response *genai.GenerateContentResponse, model string, latency time.Duration) *Response {
+	res := &Response{
+		Provider:  ProviderGemini,
+		Model:     model,
+		LatencyMS: latency.Milliseconds(),
+	}
+	if len(response.Candidates) > 0 {
+		res.Content = geminiText(response.Candidates[0])
+		res.FinishReason = geminiFinishReason(response.Candidates[0].FinishReason)
+	}
+	if len(response.Candidates) > 1 {
+		for _, candidate := range response.Candidates {
+			res.Candidates = append(res.Candidates, geminiText(candidate))
+		}
+	}
+	if response.UsageMetadata != nil {
+		res.Usage = Usage{
+			PromptTokens:     int64(response.UsageMetadata.PromptTokenCount),
+			CompletionTokens: int64(response.UsageMetadata.CandidatesTokenCount),
+			TotalTokens:      int64(response.UsageMetadata.TotalTokenCount),
+		}
+	}
+	return res
+}
+
+func geminiText(candidate *genai.Candidate) string {
+	if candidate.Content == nil {
+		return ""
+	}
+	responseMessage := []string{}
+	for _, part := range candidate.Content.Parts {
+		if str, ok := part.(genai.Text); ok {
+			responseMessage = append(responseMessage, string(str))
+		}
+	}
+	return strings.Join(responseMessage, "\n")
+}
+
+// geminiFinishReason maps Gemini finish reasons onto the OpenAI vocabulary.
+func geminiFinishReason(reason genai.FinishReason) string {
+	switch reason {
+	case genai.FinishReasonStop:
+		return "stop"
+	case genai.FinishReasonMaxTokens:
+		return "length"
+	case genai.FinishReasonSafety, genai.FinishReasonRecitation:
+		return "content_filter"
+	case genai.FinishReasonUnspecified:
+		return ""
+	default:
+		return "other"
+	}
+}
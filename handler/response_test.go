@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/openai/openai-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewOpenAIResponse(t *testing.T) {
+	t.Parallel()
+	completion := &openai.ChatCompletion{
+		Model: "gpt-4o-2024-08-06",
+		Choices: []openai.ChatCompletionChoice{
+			{Message: openai.ChatCompletionMessage{Content: "ls"}, FinishReason: openai.ChatCompletionChoicesFinishReasonStop},
+			{Message: openai.ChatCompletionMessage{Content: "ls -la"}, FinishReason: openai.ChatCompletionChoicesFinishReasonLength},
+		},
+		Usage: openai.CompletionUsage{PromptTokens: 20, CompletionTokens: 5, TotalTokens: 25},
+	}
+	assert.Equal(t, &Response{
+		Content:      "ls",
+		Provider:     ProviderOpenAI,
+		Model:        "gpt-4o-2024-08-06",
+		FinishReason: "stop",
+		Usage:        Usage{PromptTokens: 20, CompletionTokens: 5, TotalTokens: 25},
+		LatencyMS:    1500,
+		Candidates:   []string{"ls", "ls -la"},
+	}, newOpenAIResponse(completion, 1500*time.Millisecond))
+
+	assert.Equal(t, &Response{Provider: ProviderOpenAI}, newOpenAIResponse(&openai.ChatCompletion{}, 0), "no choices")
+}
+
+func TestNewGeminiResponse(t *testing.T) {
+	t.Parallel()
+	response := &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{
+			{Content: &genai.Content{Parts: []genai.Part{genai.Text("Go is"), genai.Text("fast.")}}, FinishReason: genai.FinishReasonStop},
+			{Content: nil, FinishReason: genai.FinishReasonSafety},
+		},
+		UsageMetadata: &genai.UsageMetadata{PromptTokenCount: 4, CandidatesTokenCount: 3, TotalTokenCount: 7},
+	}
+	assert.Equal(t, &Response{
+		Content:      "Go is\nfast.",
+		Provider:     ProviderGemini,
+		Model:        "gemini-2.0-flash",
+		FinishReason: "stop",
+		Usage:        Usage{PromptTokens: 4, CompletionTokens: 3, TotalTokens: 7},
+		LatencyMS:    12,
+		Candidates:   []string{"Go is\nfast.", ""},
+	}, newGeminiResponse(response, "gemini-2.0-flash", 12*time.Millisecond))
+
+	for reason, want := range map[genai.FinishReason]string{
+		genai.FinishReasonMaxTokens:   "length",
+		genai.FinishReasonRecitation:  "content_filter",
+		genai.FinishReasonUnspecified: "",
+		genai.FinishReasonOther:       "other",
+	} {
+		assert.Equal(t, want, geminiFinishReason(reason))
+	}
+}
+
+func TestGeminiChatHandlerEnvelope(t *testing.T) {
+	t.Parallel()
+	srv, _ := newCompletionServer(t)
+	res, err := geminiStandInHandler(t, srv, Options{}).Handle(context.Background(), testCommand(t, "--shell"), "list files")
+	require.NoError(t, err)
+	res.LatencyMS = 0
+	assert.Equal(t, &Response{
+		Content:      "a",
+		Provider:     ProviderGemini,
+		Model:        "gemini-2.0-flash",
+		FinishReason: "stop",
+		Usage:        Usage{PromptTokens: 4, CompletionTokens: 2, TotalTokens: 6},
+		Role:         "Shell Command Generator",
+		Candidates:   []string{"a", "b"},
+	}, res)
+}
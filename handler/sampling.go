@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/openai/openai-go"
+)
+
+const (
+	openaiMaxStop = 4
+	geminiMaxStop = 5
+)
+
+// Sampling holds the sampling parameters of a request. Nil fields leave the provider default.
+type Sampling struct {
+	Temperature *float64
+	TopP        *float64
+	MaxTokens   *int64
+	Seed        *int64
+	Stop        []string
+}
+
+// Validate reports values the given provider does not support.
+func (s Sampling) Validate(provider string) error {
+	if s.Temperature != nil && (*s.Temperature < 0 || *s.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2, got %v", *s.Temperature)
+	}
+	if s.TopP != nil && (*s.TopP < 0 || *s.TopP > 1) {
+		return fmt.Errorf("top-p must be between 0 and 1, got %v", *s.TopP)
+	}
+	if s.MaxTokens != nil && *s.MaxTokens <= 0 {
+		return fmt.Errorf("max-tokens must be positive, got %d", *s.MaxTokens)
+	}
+
+	switch provider {
+	case ProviderGemini:
+		if s.Seed != nil {
+			return fmt.Errorf("seed is not supported by %s", provider)
+		}
+		if len(s.Stop) > geminiMaxStop {
+			return fmt.Errorf("%s supports up to %d stop sequences, got %d", provider, geminiMaxStop, len(s.Stop))
+		}
+	default:
+		if len(s.Stop) > openaiMaxStop {
+			return fmt.Errorf("%s supports up to %d stop sequences, got %d", provider, openaiMaxStop, len(s.Stop))
+		}
+	}
+	return nil
+}
+
+func (s Sampling) applyOpenAI(params *openai.ChatCompletionNewParams) {
+	if s.Temperature != nil {
+		params.Temperature = openai.F(*s.Temperature)
+	}
+	if s.TopP != nil {
+		params.TopP = openai.F(*s.TopP)
+	}
+	if s.MaxTokens != nil {
+		params.MaxCompletionTokens = openai.F(*s.MaxTokens)
+	}
+	if s.Seed != nil {
+		params.Seed = openai.F(*s.Seed)
+	}
+	if len(s.Stop) > 0 {
+		params.Stop = openai.F[openai.ChatCompletionNewParamsStopUnion](openai.ChatCompletionNewParamsStopArray(s.Stop))
+	}
+}
+
+func (s Sampling) applyGemini(model *genai.GenerativeModel) {
+	if s.Temperature != nil {
+		model.SetTemperature(float32(*s.Temperature))
+	}
+	if s.TopP != nil {
+		model.SetTopP(float32(*s.TopP))
+	}
+	if s.MaxTokens != nil {
+		model.SetMaxOutputTokens(int32(*s.MaxTokens))
+	}
+	if len(s.Stop) > 0 {
+		model.StopSequences = s.Stop
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSampling() Sampling {
+	temperature, topP, maxTokens := 0.2, 0.9, int64(64)
+	return Sampling{
+		Temperature: &temperature,
+		TopP:        &topP,
+		MaxTokens:   &maxTokens,
+		Stop:        []string{"\n\n"},
+	}
+}
+
+func TestSamplingOpenAI(t *testing.T) {
+	t.Parallel()
+	body := openAIBody(t, Options{Sampling: testSampling()})
+	assert.Equal(t, 0.2, body["temperature"])
+	assert.Equal(t, 0.9, body["top_p"])
+	assert.Equal(t, float64(64), body["max_completion_tokens"])
+	assert.Equal(t, []interface{}{"\n\n"}, body["stop"])
+	assert.NotContains(t, body, "seed")
+
+	body = openAIBody(t, Options{})
+	assert.NotContains(t, body, "temperature", "the provider default is kept")
+}
+
+func TestSamplingGemini(t *testing.T) {
+	t.Parallel()
+	model := &genai.GenerativeModel{}
+	Options{Sampling: testSampling()}.applyGemini(model)
+	require.NotNil(t, model.Temperature)
+	assert.InDelta(t, 0.2, *model.Temperature, 1e-6)
+	require.NotNil(t, model.TopP)
+	assert.InDelta(t, 0.9, *model.TopP, 1e-6)
+	require.NotNil(t, model.MaxOutputTokens)
+	assert.Equal(t, int32(64), *model.MaxOutputTokens)
+	assert.Equal(t, []string{"\n\n"}, model.StopSequences)
+}
+
+func TestSamplingValidate(t *testing.T) {
+	t.Parallel()
+	seed, temperature := int64(1), 2.5
+	assert.NoError(t, testSampling().Validate(ProviderOpenAI))
+	assert.ErrorContains(t, Sampling{Temperature: &temperature}.Validate(ProviderOpenAI), "temperature must be between 0 and 2")
+	assert.ErrorContains(t, Sampling{Seed: &seed}.Validate(ProviderGemini), "seed is not supported by gemini")
+	assert.NoError(t, Sampling{Seed: &seed}.Validate(ProviderOpenAI))
+	assert.ErrorContains(t, Sampling{Stop: []string{"a", "b", "c", "d", "e"}}.Validate(ProviderOpenAI), "up to 4 stop sequences")
+	assert.NoError(t, Sampling{Stop: []string{"a", "b", "c", "d", "e"}}.Validate(ProviderGemini))
+}
+
+func TestGeminiCandidates(t *testing.T) {
+	t.Parallel()
+	srv, requests := newCompletionServer(t)
+	h := geminiStandInHandler(t, srv, Options{N: 2, Sampling: testSampling()})
+	res, err := h.Handle(context.Background(), testCommand(t), "hi")
+	require.NoError(t, err)
+	assert.Equal(t, "a", res.Content)
+	assert.Equal(t, []string{"a", "b"}, res.Candidates)
+
+	config := requests.body()["generationConfig"].(map[string]interface{})
+	assert.Equal(t, float64(2), config["candidateCount"])
+	assert.InDelta(t, 0.2, config["temperature"], 1e-6)
+	assert.Equal(t, []interface{}{"\n\n"}, config["stopSequences"])
+}
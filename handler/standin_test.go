@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	gl "cloud.google.com/go/ai/generativelanguage/apiv1beta"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+)
+
+// standIn serves the routes of mux in place of a provider API for the duration of the test.
+func standIn(t *testing.T, mux *http.ServeMux) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// redirectTransport sends every request to a local stand-in of the provider, for
+// clients whose endpoint cannot be set.
+type redirectTransport struct {
+	to *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.to.Scheme, t.to.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// redirectClient returns an HTTP client sending every request to srv.
+func redirectClient(t *testing.T, srv *httptest.Server) *http.Client {
+	t.Helper()
+	to, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return &http.Client{Transport: redirectTransport{to: to}}
+}
+
+// bodyCapture keeps the JSON body of the last request a stand-in received.
+type bodyCapture struct {
+	mu   sync.Mutex
+	last map[string]interface{}
+}
+
+// capture records the body of r. It answers with a server error and returns false when
+// the body is not JSON, since a stand-in handler runs outside of the test goroutine.
+func (c *bodyCapture) capture(w http.ResponseWriter, r *http.Request) bool {
+	var body map[string]interface{}
+	data, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(data, &body)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = body
+	return true
+}
+
+// body returns the body of the last request.
+func (c *bodyCapture) body() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+// newCompletionServer stands in for the chat API of Gemini, answering with
+// candidates "a" and "b".
+func newCompletionServer(t *testing.T) (*httptest.Server, *bodyCapture) {
+	t.Helper()
+	requests := &bodyCapture{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1beta/models/{action}", func(w http.ResponseWriter, r *http.Request) {
+		if !requests.capture(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"candidates": [`+
+			`{"index": 0, "content": {"role": "model", "parts": [{"text": "a"}]}, "finishReason": "STOP"}, `+
+			`{"index": 1, "content": {"role": "model", "parts": [{"text": "b"}]}, "finishReason": "STOP"}], `+
+			`"usageMetadata": {"promptTokenCount": 4, "candidatesTokenCount": 2, "totalTokenCount": 6}}`)
+	})
+	return standIn(t, mux), requests
+}
+
+// geminiStandInHandler returns a Gemini handler sending its requests to srv.
+func geminiStandInHandler(t *testing.T, srv *httptest.Server, opts Options) *GeminiChatHandler {
+	t.Helper()
+	client, err := gl.NewGenerativeRESTClient(context.Background(), option.WithAPIKey("test-key"), option.WithEndpoint(srv.URL))
+	require.NoError(t, err)
+	return &GeminiChatHandler{client: client, model: "gemini-2.0-flash", opts: opts}
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	pb "cloud.google.com/go/ai/generativelanguage/apiv1beta/generativelanguagepb"
+	"github.com/google/generative-ai-go/genai"
+	"github.com/urfave/cli/v3"
+	aiplatform "google.golang.org/api/aiplatform/v1"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var _ Handler = (*VertexHandler)(nil)
+
+const vertexScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// Vertex addresses Gemini models through Vertex AI instead of the Gemini API.
+type Vertex struct {
+	// Project and Location select the Google Cloud project and region, e.g. us-central1.
+	Project  string
+	Location string
+	// CredentialsFile is a service account JSON key. Application Default Credentials
+	// are used when it is empty.
+	CredentialsFile string
+	// Endpoint overrides the regional endpoint https://LOCATION-aiplatform.googleapis.com/.
+	Endpoint string
+	// WithoutAuthentication sends the requests without credentials, e.g. to a local
+	// stand-in of Vertex AI or when replaying recorded traffic.
+	WithoutAuthentication bool
+}
+
+// VertexHandler answers with Gemini models on Vertex AI. The whole answer is returned
+// at once, since the Vertex AI REST client does not stream partial responses.
+type VertexHandler struct {
+	service  *aiplatform.Service
+	model    string
+	resource string
+	opts     Options
+}
+
+func NewVertexHandler(ctx context.Context, model string, opts Options) (*VertexHandler, error) {
+	v := opts.Vertex
+	if v.Project == "" {
+		return nil, errors.New("vertex needs a Google Cloud project")
+	}
+	if v.Location == "" {
+		return nil, errors.New("vertex needs a location such as us-central1")
+	}
+	if err := opts.Sampling.Validate(ProviderGemini); err != nil {
+		return nil, err
+	}
+
+	endpoint := v.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s-aiplatform.googleapis.com/", v.Location)
+	}
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	auth := []option.ClientOption{option.WithScopes(vertexScope)}
+	switch {
+	case v.WithoutAuthentication:
+		auth = append(auth, option.WithoutAuthentication())
+	case v.CredentialsFile != "":
+		auth = append(auth, option.WithCredentialsFile(v.CredentialsFile))
+	}
+
+	options := []option.ClientOption{option.WithEndpoint(endpoint)}
+	if opts.HTTPClient != nil {
+		base := opts.HTTPClient.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		t, err := htransport.NewTransport(ctx, base, auth...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find Google Cloud credentials: %w", err)
+		}
+		options = append(options, withHTTPClient(opts.HTTPClient, t))
+	} else {
+		options = append(options, auth...)
+	}
+	service, err := aiplatform.NewService(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find Google Cloud credentials: %w", err)
+	}
+	return &VertexHandler{
+		service:  service,
+		model:    model,
+		resource: fmt.Sprintf("projects/%s/locations/%s/publishers/google/models/%s", v.Project, v.Location, model),
+		opts:     opts,
+	}, nil
+}
+
+func (h *VertexHandler) Handle(ctx context.Context, cmd *cli.Command, prompt string) (*Response, error) {
+	role, err := h.opts.role(cmd)
+	if err != nil {
+		return nil, err
+	}
+	req, err := vertexRequest(geminiRequest(role, h.opts, prompt), h.opts.Sampling)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	response, err := h.service.Projects.Locations.Publishers.Models.GenerateContent(h.resource, req).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	converted, err := vertexResponse(response)
+	if err != nil {
+		return nil, err
+	}
+	res := newGeminiResponse(converted, h.model, time.Since(start))
+	res.Provider = ProviderVertex
+	res.Role = role.Name
+	return res, nil
+}
+
+// vertexRequest converts a Gemini API request into a Vertex AI request, whose JSON form
+// is the same. Temperature and top-p are sent even when they are zero.
+func vertexRequest(req *pb.GenerateContentRequest, s Sampling) (*aiplatform.GoogleCloudAiplatformV1GenerateContentRequest, error) {
+	data, err := protojson.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	converted := &aiplatform.GoogleCloudAiplatformV1GenerateContentRequest{}
+	if err := json.Unmarshal(data, converted); err != nil {
+		return nil, fmt.Errorf("failed to convert the request for vertex: %w", err)
+	}
+	if c := converted.GenerationConfig; c != nil {
+		if s.Temperature != nil {
+			c.ForceSendFields = append(c.ForceSendFields, "Temperature")
+		}
+		if s.TopP != nil {
+			c.ForceSendFields = append(c.ForceSendFields, "TopP")
+		}
+	}
+	return converted, nil
+}
+
+// vertexResponse converts a Vertex AI response into a Gemini response. Finish reasons
+// the Gemini API does not know are reported as other.
+func vertexResponse(response *aiplatform.GoogleCloudAiplatformV1GenerateContentResponse) (*genai.GenerateContentResponse, error) {
+	for _, c := range response.Candidates {
+		if _, ok := pb.Candidate_FinishReason_value[c.FinishReason]; !ok && c.FinishReason != "" {
+			c.FinishReason = pb.Candidate_OTHER.String()
+		}
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	converted := &pb.GenerateContentResponse{}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, converted); err != nil {
+		return nil, fmt.Errorf("failed to convert the response of vertex: %w", err)
+	}
+	return geminiProtoResponse(converted), nil
+}
@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	aiplatform "google.golang.org/api/aiplatform/v1"
+)
+
+const vertexAnswer = `{
+  "candidates": [{"content": {"role": "model", "parts": [{"text": "Go is a statically typed, compiled language designed at Google."}]}, "finishReason": "STOP"}],
+  "usageMetadata": {"promptTokenCount": 9, "candidatesTokenCount": 12, "totalTokenCount": 21}
+}`
+
+// newVertexServer stands in for Vertex AI. It hands out access tokens for service
+// accounts and records the generateContent requests it answers.
+func newVertexServer(t *testing.T) (*httptest.Server, *[]*http.Request, *[]map[string]interface{}) {
+	t.Helper()
+	var requests []*http.Request
+	var bodies []map[string]interface{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"access_token": "ya29.test", "token_type": "Bearer", "expires_in": 3600}`)
+	})
+	mux.HandleFunc("POST /v1/projects/{project}/locations/{location}/publishers/google/models/{model}", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, vertexAnswer)
+	})
+	return standIn(t, mux), &requests, &bodies
+}
+
+func TestVertexHandler(t *testing.T) {
+	t.Parallel()
+	srv, requests, bodies := newVertexServer(t)
+	cmd := testCommand(t)
+
+	temperature := 0.0
+	h, err := NewVertexHandler(context.Background(), DefaultGeminiModel, Options{
+		Vertex:   Vertex{Project: "research", Location: "europe-west4", Endpoint: srv.URL, WithoutAuthentication: true},
+		History:  []Turn{{Role: "user", Content: "Hi"}, {Role: "assistant", Content: "Hello!"}},
+		JSON:     true,
+		Sampling: Sampling{Temperature: &temperature},
+	})
+	require.NoError(t, err)
+
+	res, err := h.Handle(context.Background(), cmd, "What is Go?")
+	require.NoError(t, err)
+	assert.Equal(t, answer, res.Content)
+	assert.Equal(t, ProviderVertex, res.Provider)
+	assert.Equal(t, DefaultGeminiModel, res.Model)
+	assert.Equal(t, "stop", res.FinishReason)
+	assert.Equal(t, Usage{PromptTokens: 9, CompletionTokens: 12, TotalTokens: 21}, res.Usage)
+	assert.Equal(t, "ShellGPT", res.Role)
+
+	require.Len(t, *requests, 1)
+	assert.Equal(t, "/v1/projects/research/locations/europe-west4/publishers/google/models/gemini-2.0-flash:generateContent", (*requests)[0].URL.Path)
+	assert.Empty(t, (*requests)[0].Header.Get("Authorization"))
+	role, err := RoleFromCommand(cmd)
+	require.NoError(t, err)
+	instruction, err := json.Marshal(role.Role)
+	require.NoError(t, err)
+	body, err := json.Marshal((*bodies)[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"systemInstruction": {"parts": [{"text": `+string(instruction)+`}]},
+		"contents": [
+			{"role": "user", "parts": [{"text": "Hi"}]},
+			{"role": "model", "parts": [{"text": "Hello!"}]},
+			{"role": "user", "parts": [{"text": "What is Go?\n\n`+jsonInstruction+`"}]}
+		],
+		"generationConfig": {"responseMimeType": "application/json", "temperature": 0}
+	}`, string(body))
+}
+
+func TestVertexServiceAccount(t *testing.T) {
+	t.Parallel()
+	srv, requests, _ := newVertexServer(t)
+	cmd := testCommand(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	credentials, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "research",
+		"private_key_id": "1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		"client_email":   "sgpt@research.iam.gserviceaccount.com",
+		"token_uri":      srv.URL + "/token",
+	})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "service-account.json")
+	require.NoError(t, os.WriteFile(path, credentials, 0o600))
+
+	h, err := NewVertexHandler(context.Background(), DefaultGeminiModel, Options{
+		Vertex:     Vertex{Project: "research", Location: "us-central1", Endpoint: srv.URL, CredentialsFile: path},
+		HTTPClient: srv.Client(),
+	})
+	require.NoError(t, err)
+	_, err = h.Handle(context.Background(), cmd, "What is Go?")
+	require.NoError(t, err)
+
+	require.Len(t, *requests, 1)
+	assert.Regexp(t, `^Bearer \S+$`, (*requests)[0].Header.Get("Authorization"))
+}
+
+func TestVertexInvalidOptions(t *testing.T) {
+	t.Parallel()
+	_, err := NewVertexHandler(context.Background(), DefaultGeminiModel, Options{Vertex: Vertex{Location: "us-central1"}})
+	assert.ErrorContains(t, err, "needs a Google Cloud project")
+	_, err = NewVertexHandler(context.Background(), DefaultGeminiModel, Options{Vertex: Vertex{Project: "research"}})
+	assert.ErrorContains(t, err, "needs a location")
+
+	missing := Vertex{Project: "research", Location: "us-central1", CredentialsFile: filepath.Join(t.TempDir(), "missing.json")}
+	_, err = NewVertexHandler(context.Background(), DefaultGeminiModel, Options{Vertex: missing})
+	assert.ErrorContains(t, err, "failed to find Google Cloud credentials")
+}
+
+func TestVertexResponse(t *testing.T) {
+	t.Parallel()
+	response, err := vertexResponse(&aiplatform.GoogleCloudAiplatformV1GenerateContentResponse{
+		Candidates: []*aiplatform.GoogleCloudAiplatformV1Candidate{
+			{Index: 0, FinishReason: "MAX_TOKENS", Content: &aiplatform.GoogleCloudAiplatformV1Content{
+				Role: "model", Parts: []*aiplatform.GoogleCloudAiplatformV1Part{{Text: "a"}},
+			}},
+			{Index: 1, FinishReason: "A_NEW_REASON"},
+		},
+		UsageMetadata: &aiplatform.GoogleCloudAiplatformV1GenerateContentResponseUsageMetadata{TotalTokenCount: 3},
+	})
+	require.NoError(t, err)
+	require.Len(t, response.Candidates, 2)
+	assert.Equal(t, "a", geminiText(response.Candidates[0]))
+	assert.Equal(t, "length", geminiFinishReason(response.Candidates[0].FinishReason))
+	assert.Equal(t, "other", geminiFinishReason(response.Candidates[1].FinishReason))
+	assert.Equal(t, int32(3), response.UsageMetadata.TotalTokenCount)
+}
+
+func TestVertexRequestSchema(t *testing.T) {
+	t.Parallel()
+	role, err := RoleFromCommand(testCommand(t))
+	require.NoError(t, err)
+	req, err := vertexRequest(geminiRequest(role, Options{Schema: personSchema}, "Who?"), Sampling{})
+	require.NoError(t, err)
+	s := req.GenerationConfig.ResponseSchema
+	require.NotNil(t, s)
+	assert.Equal(t, "OBJECT", s.Type)
+	assert.Equal(t, "STRING", s.Properties["name"].Type)
+	assert.Empty(t, req.GenerationConfig.ForceSendFields)
+}
@@ -0,0 +1,166 @@
+package prompt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+const templateExt = ".tmpl"
+
+// MissingVariableError is returned when a template references variables that were not given.
+type MissingVariableError struct {
+	Template string
+	Names    []string
+}
+
+func (e *MissingVariableError) Error() string {
+	return fmt.Sprintf("template %q is missing variables: %s", e.Template, strings.Join(e.Names, ", "))
+}
+
+// Template is a text/template prompt whose fields are filled from string variables.
+type Template struct {
+	Name string
+	Text string
+	tpl  *template.Template
+}
+
+// Parse parses a prompt template.
+func Parse(name string, text string) (*Template, error) {
+	tpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %q: %w", name, err)
+	}
+	return &Template{Name: name, Text: text, tpl: tpl}, nil
+}
+
+// Variables returns the sorted names of the top-level fields the template references,
+// e.g. "scope" for {{ .scope }}.
+func (t *Template) Variables() []string {
+	seen := map[string]bool{}
+	for _, tpl := range t.tpl.Templates() {
+		if tpl.Tree != nil {
+			collectFields(tpl.Tree.Root, seen)
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render executes the template. All referenced variables must be present in vars,
+// otherwise a *MissingVariableError listing every missing one is returned.
+func (t *Template) Render(vars map[string]string) (string, error) {
+	var missing []string
+	for _, name := range t.Variables() {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", &MissingVariableError{Template: t.Name, Names: missing}
+	}
+
+	var b bytes.Buffer
+	if err := t.tpl.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("failed to render template %q: %w", t.Name, err)
+	}
+	return b.String(), nil
+}
+
+// collectFields records the fields referenced on the top-level dot.
+// The bodies of range and with are skipped because they rebind dot.
+func collectFields(node parse.Node, seen map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			collectFields(c, seen)
+		}
+	case *parse.ActionNode:
+		collectFields(n.Pipe, seen)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			collectFields(c, seen)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			collectFields(arg, seen)
+		}
+	case *parse.FieldNode:
+		seen[n.Ident[0]] = true
+	case *parse.ChainNode:
+		collectFields(n.Node, seen)
+	case *parse.IfNode:
+		collectFields(n.Pipe, seen)
+		collectFields(n.List, seen)
+		collectFields(n.ElseList, seen)
+	case *parse.RangeNode:
+		collectFields(n.Pipe, seen)
+		collectFields(n.ElseList, seen)
+	case *parse.WithNode:
+		collectFields(n.Pipe, seen)
+		collectFields(n.ElseList, seen)
+	case *parse.TemplateNode:
+		collectFields(n.Pipe, seen)
+	}
+}
+
+// Library is a directory of prompt templates, one "<name>.tmpl" file per template.
+type Library struct {
+	dir string
+}
+
+func NewLibrary(dir string) *Library {
+	return &Library{dir: dir}
+}
+
+// List returns the sorted names of the templates in the library.
+func (l *Library) List() ([]string, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != templateExt {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(e.Name(), templateExt))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Get loads and parses the named template.
+func (l *Library) Get(name string) (*Template, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("invalid template name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(l.dir, name+templateExt))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("template %q not found in %s", name, l.dir)
+		}
+		return nil, err
+	}
+	return Parse(name, string(data))
+}
@@ -0,0 +1,90 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateVariables(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		text string
+		want []string
+	}{
+		"plain": {
+			text: "no variables",
+			want: []string{},
+		},
+		"fields": {
+			text: "Write a commit for {{ .scope }}:\n{{ .Stdin }}",
+			want: []string{"Stdin", "scope"},
+		},
+		"if": {
+			text: "{{ if .breaking }}BREAKING {{ .reason }}{{ end }}",
+			want: []string{"breaking", "reason"},
+		},
+		"range body rebinds dot": {
+			text: "{{ range .items }}{{ .name }}{{ end }}",
+			want: []string{"items"},
+		},
+		"pipeline": {
+			text: `{{ .lang | printf "%s" }}`,
+			want: []string{"lang"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			tpl, err := Parse(name, tc.text)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, tpl.Variables())
+		})
+	}
+}
+
+func TestTemplateRender(t *testing.T) {
+	t.Parallel()
+	tpl, err := Parse("commit", "Write a conventional commit ({{ .scope }}) for:\n{{ .Stdin }}")
+	require.NoError(t, err)
+
+	got, err := tpl.Render(map[string]string{"scope": "api", "Stdin": "diff"})
+	require.NoError(t, err)
+	assert.Equal(t, "Write a conventional commit (api) for:\ndiff", got)
+
+	_, err = tpl.Render(map[string]string{"Stdin": "diff"})
+	var missing *MissingVariableError
+	require.ErrorAs(t, err, &missing)
+	assert.Equal(t, []string{"scope"}, missing.Names)
+	assert.Equal(t, `template "commit" is missing variables: scope`, err.Error())
+}
+
+func TestLibrary(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "commit.tmpl"), []byte("commit {{ .scope }}"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "review.tmpl"), []byte("review"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0o600))
+
+	lib := NewLibrary(dir)
+	names, err := lib.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"commit", "review"}, names)
+
+	tpl, err := lib.Get("commit")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"scope"}, tpl.Variables())
+
+	_, err = lib.Get("missing")
+	assert.Error(t, err)
+	_, err = lib.Get("../commit")
+	assert.Error(t, err)
+
+	names, err = NewLibrary(filepath.Join(dir, "none")).List()
+	require.NoError(t, err)
+	assert.Empty(t, names)
+}
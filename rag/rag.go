@@ -0,0 +1,268 @@
+// Package rag keeps a local vector index of the files of a directory, so that the
+// passages most related to a prompt can be sent along with it.
+package rag
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultFile is the name of the index written to the indexed directory.
+	DefaultFile = ".sgpt-index.json"
+
+	// chunkChars is the size a chunk grows to before it is cut at a line boundary,
+	// about 400 tokens.
+	chunkChars = 1600
+	// maxFileSize is the size of the largest file indexed.
+	maxFileSize = 1 << 20
+	// batchSize is the number of chunks embedded by a request, within the limits of
+	// the OpenAI and Gemini APIs.
+	batchSize = 64
+)
+
+// Embed returns a vector for each text, in the same order.
+type Embed func(ctx context.Context, texts []string) ([][]float32, error)
+
+// Index holds the embedded chunks of the files of a directory.
+type Index struct {
+	// Platform and Model produced the vectors; prompts must be embedded with them too.
+	Platform string `json:"platform"`
+	Model    string `json:"model"`
+	// Files maps the indexed paths to the SHA-256 of their content.
+	Files  map[string]string `json:"files"`
+	Chunks []Chunk           `json:"chunks"`
+}
+
+// Chunk is a passage of a file.
+type Chunk struct {
+	Path string `json:"path"`
+	// StartLine and EndLine are the first and last lines of the passage, from 1.
+	StartLine int       `json:"start_line"`
+	EndLine   int       `json:"end_line"`
+	Text      string    `json:"text"`
+	Vector    []float32 `json:"vector"`
+}
+
+// Source cites the chunk, e.g. cmd/sgpt.go:10-42.
+func (c Chunk) Source() string {
+	return fmt.Sprintf("%s:%d-%d", c.Path, c.StartLine, c.EndLine)
+}
+
+// Stats counts the files of an update.
+type Stats struct {
+	Embedded, Unchanged, Removed, Skipped int
+	Chunks                                int
+}
+
+// New returns an empty index of the embeddings of the model.
+func New(platform, model string) *Index {
+	return &Index{Platform: platform, Model: model, Files: map[string]string{}}
+}
+
+// Load reads an index. path may also be the indexed directory.
+func Load(path string) (*Index, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, DefaultFile)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("index %s does not exist, create it with sgpt index", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ix Index
+	if err := json.Unmarshal(data, &ix); err != nil {
+		return nil, fmt.Errorf("failed to read index %s: %w", path, err)
+	}
+	if ix.Files == nil {
+		ix.Files = map[string]string{}
+	}
+	return &ix, nil
+}
+
+// Save writes the index to path.
+func (ix *Index) Save(path string) error {
+	data, err := json.Marshal(ix)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Update indexes the files of dir at the slash separated relative paths. Only files
+// that changed since they were indexed are embedded again, and files no longer listed
+// are dropped. Binary and large files are skipped. When embedding fails, the index keeps
+// the files embedded before the failure, so that saving it does not lose them.
+func (ix *Index) Update(ctx context.Context, dir string, paths []string, embed Embed) (Stats, error) {
+	var stats Stats
+	kept := map[string]bool{}
+	var pending []Chunk
+	files := map[string]string{}
+	for _, p := range paths {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(p)))
+		if err != nil || len(data) > maxFileSize || !isText(data) {
+			// e.g. a deleted file still tracked by git
+			stats.Skipped++
+			continue
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		files[p] = hash
+		if ix.Files[p] == hash {
+			kept[p] = true
+			stats.Unchanged++
+			continue
+		}
+		pending = append(pending, chunkFile(p, string(data))...)
+		stats.Embedded++
+	}
+	for p := range ix.Files {
+		if _, ok := files[p]; !ok {
+			stats.Removed++
+		}
+	}
+
+	// pending[:done] are embedded. When embedding fails, the files embedded so far are
+	// still indexed, and the others keep their previous chunks to be embedded again.
+	done := 0
+	var embedErr error
+	for start := 0; start < len(pending); start += batchSize {
+		batch := pending[start:min(start+batchSize, len(pending))]
+		texts := make([]string, len(batch))
+		for i, c := range batch {
+			texts[i] = c.Path + "\n" + c.Text
+		}
+		vectors, err := embed(ctx, texts)
+		if err != nil {
+			embedErr = fmt.Errorf("failed to embed %s: %w", batch[0].Path, err)
+			break
+		}
+		if len(vectors) != len(batch) {
+			embedErr = fmt.Errorf("got %d embeddings for %d chunks", len(vectors), len(batch))
+			break
+		}
+		for i := range batch {
+			batch[i].Vector = vectors[i]
+		}
+		done = start + len(batch)
+	}
+	unfinished := map[string]bool{}
+	for _, c := range pending[done:] {
+		unfinished[c.Path] = true
+	}
+	for p := range unfinished {
+		if hash, ok := ix.Files[p]; ok {
+			files[p] = hash
+		} else {
+			delete(files, p)
+		}
+		stats.Embedded--
+	}
+
+	chunks := make([]Chunk, 0, len(ix.Chunks)+done)
+	for _, c := range ix.Chunks {
+		if kept[c.Path] || unfinished[c.Path] {
+			chunks = append(chunks, c)
+		}
+	}
+	for _, c := range pending[:done] {
+		if !unfinished[c.Path] {
+			chunks = append(chunks, c)
+		}
+	}
+	ix.Chunks = chunks
+	ix.Files = files
+	stats.Chunks = len(ix.Chunks)
+	return stats, embedErr
+}
+
+// Result is a chunk related to a query.
+type Result struct {
+	Chunk
+	// Score is the cosine similarity of the chunk and the query.
+	Score float64
+}
+
+// Search returns the k chunks most similar to the query vector, best first.
+func (ix *Index) Search(query []float32, k int) []Result {
+	results := make([]Result, 0, len(ix.Chunks))
+	for _, c := range ix.Chunks {
+		results = append(results, Result{Chunk: c, Score: cosine(query, c.Vector)})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// Render formats the results as numbered sources to prepend to a prompt.
+func Render(results []Result) string {
+	if len(results) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for i, r := range results {
+		fmt.Fprintf(&b, "### Source [%d]: %s\n```\n%s\n```\n\n", i+1, r.Source(), strings.TrimRight(r.Text, "\n"))
+	}
+	b.WriteString("Use the sources above where they are relevant and cite them by number, e.g. [1].\n\n")
+	return b.String()
+}
+
+// chunkFile cuts the content of a file into chunks at line boundaries.
+func chunkFile(path, content string) []Chunk {
+	lines := strings.SplitAfter(strings.TrimSuffix(content, "\n"), "\n")
+	var chunks []Chunk
+	var b strings.Builder
+	start := 1
+	for i, line := range lines {
+		b.WriteString(line)
+		if b.Len() < chunkChars && i < len(lines)-1 {
+			continue
+		}
+		if text := b.String(); strings.TrimSpace(text) != "" {
+			chunks = append(chunks, Chunk{Path: path, StartLine: start, EndLine: i + 1, Text: text})
+		}
+		b.Reset()
+		start = i + 2
+	}
+	return chunks
+}
+
+// isText reports whether data looks like UTF-8 text rather than a binary file.
+func isText(data []byte) bool {
+	return !bytes.Contains(data[:min(len(data), 8000)], []byte{0}) && utf8.Valid(data)
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
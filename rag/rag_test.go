@@ -0,0 +1,150 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// letterEmbed embeds a text as the counts of the letters a, b and c.
+func letterEmbed(calls *int) Embed {
+	return func(ctx context.Context, texts []string) ([][]float32, error) {
+		*calls++
+		vectors := make([][]float32, len(texts))
+		for i, text := range texts {
+			vectors[i] = []float32{float32(strings.Count(text, "a")), float32(strings.Count(text, "b")), float32(strings.Count(text, "c"))}
+		}
+		return vectors, nil
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+}
+
+func TestUpdateSearch(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.txt":     "aaaa\naa\n",
+		"sub/b.txt": "bbbb\n",
+		"image.png": "\x89PNG\x00\x00",
+		"empty.txt": "\n\n",
+	})
+	paths := []string{"a.txt", "sub/b.txt", "image.png", "empty.txt", "deleted.txt"}
+
+	calls := 0
+	ix := New("mock", "letters")
+	stats, err := ix.Update(context.Background(), dir, paths, letterEmbed(&calls))
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Embedded: 3, Skipped: 2, Chunks: 2}, stats)
+	assert.Equal(t, 1, calls)
+
+	results := ix.Search([]float32{1, 0, 0}, 1)
+	require.Len(t, results, 1)
+	assert.Equal(t, "a.txt:1-2", results[0].Source())
+	assert.Equal(t, "aaaa\naa", results[0].Text)
+	assert.InDelta(t, 1, results[0].Score, 1e-9)
+
+	// Only the changed file is embedded again.
+	writeFiles(t, dir, map[string]string{"sub/b.txt": "bbbb\ncc\n"})
+	stats, err = ix.Update(context.Background(), dir, []string{"a.txt", "sub/b.txt"}, letterEmbed(&calls))
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Embedded: 1, Unchanged: 1, Removed: 1, Chunks: 2}, stats)
+	assert.Equal(t, 2, calls)
+	results = ix.Search([]float32{0, 0, 1}, 5)
+	require.Len(t, results, 2)
+	assert.Equal(t, "sub/b.txt:1-2", results[0].Source())
+
+	path := filepath.Join(dir, DefaultFile)
+	require.NoError(t, ix.Save(path))
+	loaded, err := Load(dir)
+	require.NoError(t, err)
+	assert.Equal(t, ix, loaded)
+}
+
+func TestUpdateFailure(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a\n"})
+	ix := New("mock", "letters")
+	_, err := ix.Update(context.Background(), dir, []string{"a.txt"}, func(ctx context.Context, texts []string) ([][]float32, error) {
+		return nil, errors.New("rate limited")
+	})
+	assert.ErrorContains(t, err, "failed to embed a.txt: rate limited")
+	assert.Empty(t, ix.Files, "the index is unchanged")
+
+	_, err = Load(filepath.Join(dir, "missing.json"))
+	assert.ErrorContains(t, err, "create it with sgpt index")
+}
+
+func TestUpdatePartialFailure(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+	calls := 0
+	ix := New("mock", "letters")
+	_, err := ix.Update(context.Background(), dir, []string{"a.txt", "b.txt"}, letterEmbed(&calls))
+	require.NoError(t, err)
+	previous := ix.Files["b.txt"]
+
+	// The new files fill the first batch, and the changed b.txt is in the failing second one.
+	writeFiles(t, dir, map[string]string{"b.txt": "bb\n"})
+	paths := []string{"a.txt"}
+	for i := range batchSize {
+		p := fmt.Sprintf("new%02d.txt", i)
+		writeFiles(t, dir, map[string]string{p: "c\n"})
+		paths = append(paths, p)
+	}
+	paths = append(paths, "b.txt")
+	stats, err := ix.Update(context.Background(), dir, paths, func(ctx context.Context, texts []string) ([][]float32, error) {
+		if calls == 2 {
+			return nil, errors.New("rate limited")
+		}
+		return letterEmbed(&calls)(ctx, texts)
+	})
+	assert.ErrorContains(t, err, "failed to embed b.txt: rate limited")
+	assert.Equal(t, Stats{Embedded: batchSize, Unchanged: 1, Chunks: batchSize + 2}, stats)
+	assert.Len(t, ix.Files, batchSize+2)
+	assert.Equal(t, previous, ix.Files["b.txt"], "b.txt keeps its previous chunks")
+	results := ix.Search([]float32{0, 1, 0}, 1)
+	assert.Equal(t, "b", results[0].Text)
+
+	// Running again embeds what is left.
+	stats, err = ix.Update(context.Background(), dir, paths, letterEmbed(&calls))
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Embedded: 1, Unchanged: batchSize + 1, Chunks: batchSize + 2}, stats)
+	results = ix.Search([]float32{0, 1, 0}, 1)
+	assert.Equal(t, "bb", results[0].Text)
+}
+
+func TestChunkFile(t *testing.T) {
+	t.Parallel()
+	line := strings.Repeat("x", 99) + "\n"
+	chunks := chunkFile("big.txt", strings.Repeat(line, 40))
+	require.Len(t, chunks, 3)
+	assert.Equal(t, []int{1, 16}, []int{chunks[0].StartLine, chunks[0].EndLine})
+	assert.Equal(t, []int{17, 32}, []int{chunks[1].StartLine, chunks[1].EndLine})
+	assert.Equal(t, []int{33, 40}, []int{chunks[2].StartLine, chunks[2].EndLine})
+	assert.Equal(t, strings.Repeat(line, 16), chunks[0].Text)
+}
+
+func TestRender(t *testing.T) {
+	t.Parallel()
+	assert.Empty(t, Render(nil))
+	got := Render([]Result{{Chunk: Chunk{Path: "main.go", StartLine: 3, EndLine: 5, Text: "func main() {}\n"}}})
+	assert.Equal(t, "### Source [1]: main.go:3-5\n```\nfunc main() {}\n```\n\n"+
+		"Use the sources above where they are relevant and cite them by number, e.g. [1].\n\n", got)
+}
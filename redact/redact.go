@@ -0,0 +1,251 @@
+// Package redact replaces secrets in prompts with placeholders before they are sent to a provider.
+package redact
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// keySize is the size in bytes of the key placeholders are derived with.
+const keySize = 32
+
+// Rule detects one kind of secret. When Pattern has a capture group, only the
+// first group is replaced, so that the surrounding text stays readable.
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// Builtin returns the rules detecting well-known secrets.
+func Builtin() []Rule {
+	return []Rule{
+		{"private-key", regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY-----`)},
+		{"url-password", regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:([^/\s@]+)@`)},
+		{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{10,}`)},
+		{"aws-access-key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+		{"aws-secret-key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})\b`)},
+		{"gcp-api-key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+		{"github-token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`)},
+		{"openai-key", regexp.MustCompile(`\bsk-(?:proj-|svcacct-|admin-)?[A-Za-z0-9_-]{20,}\b`)},
+	}
+}
+
+// ParseRules compiles user-configured regular expressions.
+func ParseRules(patterns []string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", p, err)
+		}
+		rules = append(rules, Rule{Name: "custom", Pattern: re})
+	}
+	return rules, nil
+}
+
+// LoadRules reads one regular expression per line from path. Empty lines and lines
+// starting with # are ignored. A missing file has no rules.
+func LoadRules(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ParseRules(patterns)
+}
+
+// Finding is a secret replaced by a Redactor.
+type Finding struct {
+	Placeholder string
+	Rule        string
+	// Preview shows the start of the secret, enough to recognize it but not to use it.
+	Preview string
+}
+
+// LoadKey reads the key placeholders are derived with from path, creating a random
+// one readable only by the user if the file does not exist.
+func LoadKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) < keySize {
+			return nil, fmt.Errorf("redaction key %s is shorter than %d bytes", path, keySize)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	key = make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		// created by another process in the meantime
+		return LoadKey(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return key, f.Close()
+}
+
+// Redactor replaces secrets with placeholders. A placeholder is derived from the secret
+// with a keyed hash, so the same secret gets the same placeholder in every run with the
+// same key, e.g. across the turns of a chat, and the model can still tell secrets apart.
+type Redactor struct {
+	rules   []Rule
+	loadKey func() ([]byte, error)
+	// OnFinding, when set, is called for each secret the first time it is replaced.
+	OnFinding func(Finding)
+
+	mu           sync.Mutex
+	key          []byte
+	placeholders map[string]string
+	// secrets maps the placeholders back to the secrets they replace.
+	secrets  map[string]string
+	findings []Finding
+}
+
+// New returns a redactor deriving placeholders with the key returned by loadKey, e.g.
+// LoadKey. It is called when the first secret is found, so that prompts without secrets
+// need no key.
+func New(rules []Rule, loadKey func() ([]byte, error)) *Redactor {
+	return &Redactor{
+		rules:        rules,
+		loadKey:      loadKey,
+		placeholders: map[string]string{},
+		secrets:      map[string]string{},
+	}
+}
+
+// Redact returns text with every secret replaced by its placeholder.
+func (r *Redactor) Redact(text string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rule := range r.rules {
+		text = r.apply(rule, text)
+	}
+	return text
+}
+
+// Restore returns text with the placeholders of the redactor replaced by their secrets,
+// e.g. in an answer quoting a redacted prompt.
+func (r *Redactor) Restore(text string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.secrets) == 0 || !strings.Contains(text, "[REDACTED_") {
+		return text
+	}
+	pairs := make([]string, 0, 2*len(r.secrets))
+	for p, secret := range r.secrets {
+		pairs = append(pairs, p, secret)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// Findings returns the secrets replaced so far.
+func (r *Redactor) Findings() []Finding {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Finding(nil), r.findings...)
+}
+
+func (r *Redactor) apply(rule Rule, text string) string {
+	matches := rule.Pattern.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) >= 4 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		if start == end {
+			continue
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(r.placeholder(rule.Name, text[start:end]))
+		last = end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+func (r *Redactor) placeholder(rule, secret string) string {
+	if p, ok := r.placeholders[secret]; ok {
+		return p
+	}
+	mac := hmac.New(sha256.New, r.hashKey())
+	mac.Write([]byte(secret))
+	p := fmt.Sprintf("[REDACTED_%s_%s]", strings.ToUpper(strings.ReplaceAll(rule, "-", "_")), hex.EncodeToString(mac.Sum(nil)[:4]))
+	r.placeholders[secret] = p
+	r.secrets[p] = secret
+
+	f := Finding{Placeholder: p, Rule: rule, Preview: preview(secret)}
+	r.findings = append(r.findings, f)
+	if r.OnFinding != nil {
+		r.OnFinding(f)
+	}
+	return p
+}
+
+// hashKey returns the key placeholders are derived with. When it cannot be loaded, a
+// random key is used instead and placeholders are only stable within the process.
+func (r *Redactor) hashKey() []byte {
+	if r.key != nil {
+		return r.key
+	}
+	key, err := r.loadKey()
+	if err != nil {
+		slog.Warn("failed to load the redaction key, placeholders differ across runs", slog.Any("error", err))
+		key = make([]byte, keySize)
+		_, _ = rand.Read(key)
+	}
+	r.key = key
+	return key
+}
+
+func preview(secret string) string {
+	const shown = 4
+	if len(secret) <= 2*shown {
+		return fmt.Sprintf("(%d characters)", len(secret))
+	}
+	return fmt.Sprintf("%s… (%d characters)", secret[:shown], len(secret))
+}
@@ -0,0 +1,218 @@
+package review
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/hirosassa/sgpt/gitcommit"
+)
+
+// Severities of a finding.
+const (
+	// SeverityError is used for bugs and security issues.
+	SeverityError = "error"
+	// SeverityWarning is used for likely problems.
+	SeverityWarning = "warning"
+	// SeverityNote is used for suggestions.
+	SeverityNote = "note"
+)
+
+// DefaultChunkSize is the size in bytes above which a diff is reviewed in parts.
+const DefaultChunkSize = gitcommit.DefaultChunkSize
+
+// Schema is the JSON schema the model must answer with.
+const Schema = `{
+  "type": "object",
+  "properties": {
+    "summary": {"type": "string"},
+    "findings": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "file": {"type": "string"},
+          "line": {"type": "integer"},
+          "severity": {"type": "string", "enum": ["error", "warning", "note"]},
+          "title": {"type": "string"},
+          "message": {"type": "string"}
+        },
+        "required": ["file", "line", "severity", "title", "message"],
+        "additionalProperties": false
+      }
+    }
+  },
+  "required": ["summary", "findings"],
+  "additionalProperties": false
+}`
+
+// SchemaName is the name of Schema in provider requests.
+const SchemaName = "review_findings"
+
+const instructions = `Review the following diff as an experienced code reviewer.
+Report bugs, security issues, performance problems and unclear code introduced by the change.
+Do not report style issues a formatter or linter would catch, and do not praise the change.
+For each finding give the path of the file as shown in the diff, the line number in the new version of the file,
+a severity (error for bugs and security issues, warning for likely problems, note for suggestions),
+a short title and a message explaining the problem and how to fix it.
+Give an empty list of findings if there is nothing to report.
+Summarize the change and the review in one or two sentences.`
+
+// Finding is a single review comment.
+type Finding struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Severity string `json:"severity"`
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+}
+
+// Report is the result of a review.
+type Report struct {
+	Summary  string    `json:"summary"`
+	Findings []Finding `json:"findings"`
+}
+
+// Prompts returns the prompts asking for a review of the diff. A diff larger than
+// chunkSize bytes is split between files into several prompts, whose reports are
+// combined with Merge.
+func Prompts(diff string, chunkSize int) []string {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	chunks := gitcommit.Chunk(diff, chunkSize)
+	if len(chunks) == 1 {
+		return []string{instructions + "\n\n" + diff}
+	}
+	prompts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		prompts[i] = fmt.Sprintf("%s\nThis is part %d of %d of a larger diff, review only this part.\n\n%s",
+			instructions, i+1, len(chunks), chunk)
+	}
+	return prompts
+}
+
+// Merge combines the reports of the parts of a diff into one.
+func Merge(reports []*Report) *Report {
+	if len(reports) == 1 {
+		return reports[0]
+	}
+	merged := &Report{Findings: []Finding{}}
+	var summaries []string
+	for _, r := range reports {
+		if r.Summary != "" {
+			summaries = append(summaries, r.Summary)
+		}
+		merged.Findings = append(merged.Findings, r.Findings...)
+	}
+	merged.Summary = strings.Join(summaries, " ")
+	return merged
+}
+
+// Parse decodes the model answer into a report.
+func Parse(content string) (*Report, error) {
+	var r Report
+	if err := json.Unmarshal([]byte(content), &r); err != nil {
+		return nil, fmt.Errorf("failed to parse review: %w", err)
+	}
+	if r.Findings == nil {
+		r.Findings = []Finding{}
+	}
+	return &r, nil
+}
+
+// Diff returns the changes to review in the repository in dir: the changes since
+// the merge base with base, or the staged changes when base is empty.
+func Diff(ctx context.Context, dir string, base string) (string, error) {
+	args := []string{"diff", "--staged"}
+	if base != "" {
+		args = []string{"diff", base + "...HEAD"}
+	}
+	c := exec.CommandContext(ctx, "git", args...)
+	c.Dir = dir
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// ValidateFormat reports whether Write supports the format.
+func ValidateFormat(format string) error {
+	switch format {
+	case "text", "json", "sarif", "github":
+		return nil
+	default:
+		return fmt.Errorf("unsupported review format %q, must be one of: text, json, sarif, github", format)
+	}
+}
+
+// Write writes the report in the given format: text, json, sarif or github.
+func Write(w io.Writer, format string, r *Report) error {
+	if err := ValidateFormat(format); err != nil {
+		return err
+	}
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case "sarif":
+		return writeSARIF(w, r)
+	case "github":
+		return writeGitHub(w, r)
+	default:
+		return writeText(w, r)
+	}
+}
+
+func writeText(w io.Writer, r *Report) error {
+	var b strings.Builder
+	b.WriteString(r.Summary + "\n")
+	for _, f := range r.Findings {
+		fmt.Fprintf(&b, "\n%s:%d: [%s] %s\n", f.File, f.Line, f.Severity, f.Title)
+		for _, line := range strings.Split(strings.TrimSpace(f.Message), "\n") {
+			b.WriteString("  " + line + "\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeGitHub writes GitHub Actions workflow commands, which show up as annotations.
+func writeGitHub(w io.Writer, r *Report) error {
+	for _, f := range r.Findings {
+		level := "notice"
+		switch f.Severity {
+		case SeverityError:
+			level = "error"
+		case SeverityWarning:
+			level = "warning"
+		}
+		props := "file=" + escapeProperty(f.File)
+		if f.Line > 0 {
+			props += fmt.Sprintf(",line=%d", f.Line)
+		}
+		props += ",title=" + escapeProperty(f.Title)
+		if _, err := fmt.Fprintf(w, "::%s %s::%s\n", level, props, escapeData(f.Message)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// escapeData escapes the message of a workflow command.
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a property value of a workflow command.
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
@@ -0,0 +1,114 @@
+package review
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/hirosassa/sgpt/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const answer = `{
+  "summary": "Adds a retry loop.",
+  "findings": [
+    {"file": "handler/retry.go", "line": 12, "severity": "error", "title": "Infinite loop", "message": "The loop never exits,\nadd a limit."},
+    {"file": "README.md", "line": 0, "severity": "note", "title": "Docs", "message": "Mention the retry."}
+  ]
+}`
+
+func TestSchema(t *testing.T) {
+	t.Parallel()
+	s, err := schema.Parse(SchemaName, []byte(Schema))
+	require.NoError(t, err)
+	require.NoError(t, schema.Validate(s, answer))
+	assert.Error(t, schema.Validate(s, `{"summary": "ok", "findings": [{"file": "a.go"}]}`))
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+	report, err := Parse(answer)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		format string
+		want   string
+	}{
+		"text": {
+			format: "text",
+			want: "Adds a retry loop.\n" +
+				"\nhandler/retry.go:12: [error] Infinite loop\n  The loop never exits,\n  add a limit.\n" +
+				"\nREADME.md:0: [note] Docs\n  Mention the retry.\n",
+		},
+		"github": {
+			format: "github",
+			want: "::error file=handler/retry.go,line=12,title=Infinite loop::The loop never exits,%0Aadd a limit.\n" +
+				"::notice file=README.md,title=Docs::Mention the retry.\n",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var b bytes.Buffer
+			require.NoError(t, Write(&b, tc.format, report))
+			assert.Equal(t, tc.want, b.String())
+		})
+	}
+
+	var b bytes.Buffer
+	assert.Error(t, Write(&b, "xml", report))
+}
+
+func TestWriteSARIF(t *testing.T) {
+	t.Parallel()
+	report, err := Parse(answer)
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	require.NoError(t, Write(&b, "sarif", report))
+
+	var log sarifLog
+	require.NoError(t, json.Unmarshal(b.Bytes(), &log))
+	assert.Equal(t, "2.1.0", log.Version)
+	require.Len(t, log.Runs[0].Results, 2)
+	first := log.Runs[0].Results[0]
+	assert.Equal(t, "error", first.Level)
+	assert.Equal(t, "handler/retry.go", first.Locations[0].PhysicalLocation.ArtifactLocation.URI)
+	assert.Equal(t, 12, first.Locations[0].PhysicalLocation.Region.StartLine)
+	assert.Nil(t, log.Runs[0].Results[1].Locations[0].PhysicalLocation.Region)
+}
+
+func TestEscapeProperty(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "a%3Ab%2Cc%25", escapeProperty("a:b,c%"))
+}
+
+func TestPrompts(t *testing.T) {
+	t.Parallel()
+	diff := "diff --git a/a.go b/a.go\n+package a\n" + "diff --git a/b.go b/b.go\n+package b\n"
+
+	prompts := Prompts(diff, 0)
+	require.Len(t, prompts, 1)
+	assert.Contains(t, prompts[0], diff)
+
+	prompts = Prompts(diff, 40)
+	require.Len(t, prompts, 2)
+	assert.Contains(t, prompts[0], "part 1 of 2")
+	assert.Contains(t, prompts[0], "+package a")
+	assert.NotContains(t, prompts[0], "+package b")
+	assert.Contains(t, prompts[1], "+package b")
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+	first, err := Parse(answer)
+	require.NoError(t, err)
+	second := &Report{Summary: "Looks fine.", Findings: []Finding{}}
+
+	assert.Same(t, first, Merge([]*Report{first}))
+	merged := Merge([]*Report{first, second})
+	assert.Equal(t, "Adds a retry loop. Looks fine.", merged.Summary)
+	assert.Len(t, merged.Findings, 2)
+}
@@ -0,0 +1,140 @@
+package role
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// dialect holds the shell specific parts of the shell role.
+type dialect struct {
+	// hints are syntax rules the model tends to get wrong for the shell.
+	hints []string
+	// examples are few-shot request/command pairs.
+	examples [][2]string
+	// check is the command line that parses a script without executing it, if any.
+	check []string
+}
+
+var dialects = map[string]dialect{
+	"sh": {
+		hints: []string{
+			"Use only POSIX sh syntax: no arrays, no [[ ]], no brace expansion, no 'function' keyword.",
+			"If multiple steps required try to combine them together using &&.",
+		},
+		examples: [][2]string{
+			{"list files larger than 10MB", "find . -type f -size +10M"},
+			{"create a directory build and enter it", "mkdir -p build && cd build"},
+		},
+		check: []string{"sh", "-n", "-c"},
+	},
+	"bash": {
+		hints: []string{
+			"If multiple steps required try to combine them together using &&.",
+		},
+		examples: [][2]string{
+			{"list files larger than 10MB", "find . -type f -size +10M"},
+			{"rename all .txt files to .md", "for f in *.txt; do mv -- \"$f\" \"${f%.txt}.md\"; done"},
+		},
+		check: []string{"bash", "-n", "-c"},
+	},
+	"zsh": {
+		hints: []string{
+			"If multiple steps required try to combine them together using &&.",
+			"Glob qualifiers such as *(.) and **/ recursive globs are available.",
+		},
+		examples: [][2]string{
+			{"list files larger than 10MB", "ls -l **/*(.Lm+10)"},
+			{"rename all .txt files to .md", "for f in *.txt; do mv -- \"$f\" \"${f:r}.md\"; done"},
+		},
+		check: []string{"zsh", "-n", "-c"},
+	},
+	"fish": {
+		hints: []string{
+			"Use fish syntax: 'set VAR value' instead of VAR=value, '(cmd)' instead of $(cmd), 'end' to close blocks.",
+			"If multiple steps required combine them with '; and' so that it works with every fish version.",
+			"Use 'set -x VAR value' to export environment variables.",
+		},
+		examples: [][2]string{
+			{"set the variable GOPATH to ~/go and export it", "set -x GOPATH ~/go"},
+			{"rename all .txt files to .md", "for f in *.txt; mv -- $f (string replace -r '\\.txt$' .md $f); end"},
+		},
+		check: []string{"fish", "--no-execute", "-c"},
+	},
+	"nu": {
+		hints: []string{
+			"Use nushell syntax: commands return structured tables, filter them with where, sort-by and get.",
+			"If multiple steps required combine them with ';'. Do not use && or ||.",
+			"Use $env.VAR for environment variables.",
+		},
+		examples: [][2]string{
+			{"list files larger than 10MB", "ls **/* | where type == file and size > 10MB"},
+			{"show the PATH entries one per line", "$env.PATH"},
+		},
+	},
+}
+
+// dialectOf maps a shell name onto the dialect used to prompt for it.
+func dialectOf(shell string) (dialect, bool) {
+	switch shell {
+	case "sh", "dash", "ash", "ksh", "mksh":
+		shell = "sh"
+	}
+	d, ok := dialects[shell]
+	return d, ok
+}
+
+// shellRole returns the shell role for the given shell. Unknown shells get the generic ShellRole.
+func shellRole(shell string) string {
+	d, ok := dialectOf(shell)
+	if !ok {
+		return ShellRole
+	}
+
+	var b strings.Builder
+	b.WriteString("Provide only {{ .Shell }} commands for {{ .OS }} without any description.\n")
+	b.WriteString("{{ if .PackageManager }}Use {{ .PackageManager }} to install packages.\n{{ end }}")
+	b.WriteString("If there is a lack of details, provide most logical solution.\n")
+	b.WriteString("Ensure the output is a valid {{ .Shell }} command.\n")
+	for _, hint := range d.hints {
+		b.WriteString(hint + "\n")
+	}
+	b.WriteString("Provide only plain text without Markdown formatting.\n")
+	b.WriteString("Do not provide markdown formatting such as ```.\n")
+	b.WriteString("Examples:")
+	for _, e := range d.examples {
+		// examples are literal text, escape template actions in them
+		fmt.Fprintf(&b, "\nRequest: %s\nCommand: {{ `%s` }}", e[0], e[1])
+	}
+	return b.String()
+}
+
+// ErrNoParser is returned by CheckSyntax when no parser is available for the shell.
+var ErrNoParser = errors.New("no parser available")
+
+// CheckSyntax parses command with the given shell without executing it.
+// It returns ErrNoParser when the shell has no parse-only mode or is not installed.
+func CheckSyntax(ctx context.Context, shell string, command string) error {
+	d, ok := dialectOf(shell)
+	if !ok || len(d.check) == 0 {
+		return ErrNoParser
+	}
+	if _, err := exec.LookPath(d.check[0]); err != nil {
+		return ErrNoParser
+	}
+
+	args := append(d.check[1:len(d.check):len(d.check)], command)
+	c := exec.CommandContext(ctx, d.check[0], args...)
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("invalid %s syntax: %s", shell, msg)
+		}
+		return fmt.Errorf("invalid %s syntax: %w", shell, err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package role
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShellRole(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		shell    string
+		contains string
+	}{
+		"fish":    {shell: "fish", contains: "'; and'"},
+		"nushell": {shell: "nu", contains: "Do not use && or ||."},
+		"dash":    {shell: "dash", contains: "POSIX sh"},
+		"unknown": {shell: "rc", contains: "combine them together using &&"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			role, err := CheckGet(true, false, false, map[string]string{"Shell": tc.shell, "OS": "linux", "PackageManager": ""})
+			require.NoError(t, err)
+			assert.Contains(t, role.Role, tc.contains)
+			assert.Contains(t, role.Role, "Provide only "+tc.shell+" commands for linux")
+		})
+	}
+}
+
+func TestCheckSyntax(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+	ctx := context.Background()
+
+	require.NoError(t, CheckSyntax(ctx, "bash", "for f in *.txt; do echo \"$f\"; done"))
+	assert.Error(t, CheckSyntax(ctx, "bash", "for f in *.txt; do echo"))
+	assert.ErrorIs(t, CheckSyntax(ctx, "nu", "ls"), ErrNoParser)
+	assert.ErrorIs(t, CheckSyntax(ctx, "rc", "ls"), ErrNoParser)
+}
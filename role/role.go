@@ -3,14 +3,14 @@ package role
 import (
 	"bytes"
 	"log/slog"
-	"os"
-	"path/filepath"
-	"runtime"
 	"text/template"
+
+	"github.com/hirosassa/sgpt/prompt"
 )
 
 const (
 	ShellRole = "Provide only {{ .Shell }} commands for {{ .OS }} without any description.\n" +
+		"{{ if .PackageManager }}Use {{ .PackageManager }} to install packages.\n{{ end }}" +
 		"If there is a lack of details, provide most logical solution.\n" +
 		"Ensure the output is a valid shell command.\n" +
 		"If multiple steps required try to combine them together using &&.\n" +
@@ -29,6 +29,12 @@ APPLY MARKDOWN formatting when possible.`
 		"You are not allowed to ask for more details.\n" +
 		"For example if the prompt is \"Hello world Python\", you should return \"print('Hello world')\"."
 
+	CommitRole = "Provide only git commit messages and summaries of diffs as output without any description.\n" +
+		"Provide only plain text without Markdown formatting.\n" +
+		"Do not provide markdown formatting such as ``` and do not quote the message.\n" +
+		"{{ if .Conventional }}Write summary lines in the Conventional Commits format, e.g. \"fix(parser): handle empty input\".\n{{ end }}" +
+		"Keep summary lines to at most 72 characters in the imperative mood."
+
 	DefaultRole = `You are programming and system administration assistant.
 You are managing {{ .OS }} operating system with {{ .Shell }} shell.
 Provide short responses in about 100 words, unless you are specifically asked for more details.
@@ -45,15 +51,22 @@ const (
 	Shell         DefaultRoleName = "Shell Command Generator"
 	DescribeShell DefaultRoleName = "Shell Command Descriptor"
 	Code          DefaultRoleName = "Code Generator"
+	Commit        DefaultRoleName = "Commit Message Generator"
 )
 
 type SystemRole struct {
 	Name string
 	Role string
+	// Temperature and TopP are the default sampling parameters of the role.
+	// Nil leaves the provider default.
+	Temperature *float64
+	TopP        *float64
+	// Shell is the shell the role generates commands for; empty for other roles.
+	Shell string
 }
 
 func NewRole(name string, role string, variables map[string]string) (*SystemRole, error) {
-	roleString, err := execRole(role, variables)
+	roleString, err := execRole(name, role, variables)
 	if err != nil {
 		return nil, err
 	}
@@ -71,75 +84,82 @@ func NewRole(name string, role string, variables map[string]string) (*SystemRole
 	if err := tpl.Execute(&b, data); err != nil {
 		return nil, err
 	}
-	return &SystemRole{name, b.String()}, nil
+	return &SystemRole{Name: name, Role: b.String()}, nil
 }
 
-func execRole(role string, variables map[string]string) (string, error) {
-	if !checkVariables(variables) {
-		return role, nil
-	}
-
-	var b bytes.Buffer
-	tpl, err := template.New("role").Parse(role)
+// execRole renders the role with the same engine as prompt templates,
+// so a variable the role references but which is not given is an error.
+func execRole(name string, role string, variables map[string]string) (string, error) {
+	tpl, err := prompt.Parse(name, role)
 	if err != nil {
 		return "", err
 	}
-	if err := tpl.Execute(&b, variables); err != nil {
-		return "", err
-	}
-	return b.String(), nil
-}
-
-func osName() string {
-	// todo: add distro name if needed
-	slog.Debug(runtime.GOOS)
-	return runtime.GOOS
-}
-
-func shellName() string {
-	// todo: support windows shell
-	slog.Debug(filepath.Base(os.Getenv("SHELL")))
-	return filepath.Base(os.Getenv("SHELL"))
+	return tpl.Render(variables)
 }
 
-func checkVariables(variables map[string]string) bool {
-	_, ok := variables["Shell"]
-	if !ok {
-		return false
+// newContextRole renders a role with the built-in variables it references and the extra ones.
+func newContextRole(name DefaultRoleName, role string, extra map[string]string) (*SystemRole, error) {
+	tpl, err := prompt.Parse(string(name), role)
+	if err != nil {
+		return nil, err
 	}
-	_, ok = variables["OS"]
-	return ok
+	return NewRole(string(name), role, contextVariables(tpl.Variables(), extra))
 }
 
-func CheckGet(shell bool, describeShell bool, code bool) (*SystemRole, error) {
+// CheckGet returns the role selected by the flags. Roles are rendered with the built-in
+// variables (see builtins) and the extra ones, which take precedence.
+func CheckGet(shell bool, describeShell bool, code bool, extra map[string]string) (*SystemRole, error) {
 	if shell {
-		variables := map[string]string{"OS": osName(), "Shell": shellName()}
-		role, err := NewRole(string(Shell), ShellRole, variables)
+		// the dialect is chosen before rendering and the commands run with the shell,
+		// so resolve it eagerly
+		variables := map[string]string{"Shell": shellName()}
+		for k, v := range extra {
+			variables[k] = v
+		}
+		role, err := newContextRole(Shell, shellRole(variables["Shell"]), variables)
 		if err != nil {
 			return nil, err
 		}
+		role.Shell = variables["Shell"]
+		// generated commands are executed as is, so prefer deterministic output
+		temperature := 0.0
+		role.Temperature = &temperature
 		return role, nil
 	}
 	if describeShell {
-		role, err := NewRole(string(DescribeShell), DescribeShellRole, map[string]string{})
+		role, err := newContextRole(DescribeShell, DescribeShellRole, extra)
 		if err != nil {
 			return nil, err
 		}
 		return role, nil
 	}
 	if code {
-		role, err := NewRole(string(Code), CodeRole, map[string]string{})
+		role, err := newContextRole(Code, CodeRole, extra)
 		if err != nil {
 			return nil, err
 		}
+		temperature := 0.0
+		role.Temperature = &temperature
 		return role, nil
 	}
 
-	variables := map[string]string{"OS": osName(), "Shell": shellName()}
-	role, err := NewRole(string(Default), DefaultRole, variables)
+	role, err := newContextRole(Default, DefaultRole, extra)
 	if err != nil {
 		return nil, err
 	}
 	slog.Debug("get role", slog.String("name", role.Name), slog.String("role", role.Role))
 	return role, nil
 }
+
+// GetCommit returns the role of "sgpt commit", asking for plain commit messages in the
+// Conventional Commits format if conventional is set.
+func GetCommit(conventional bool, extra map[string]string) (*SystemRole, error) {
+	variables := map[string]string{"Conventional": ""}
+	if conventional {
+		variables["Conventional"] = "true"
+	}
+	for k, v := range extra {
+		variables[k] = v
+	}
+	return newContextRole(Commit, CommitRole, variables)
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewRole(t *testing.T) {
@@ -54,17 +55,20 @@ func TestNewRole(t *testing.T) {
 
 func TestCheckGet(t *testing.T) {
 	t.Parallel()
+	zero := 0.0
 	tests := map[string]struct {
 		shell         bool
 		describeShell bool
 		code          bool
 		want          string
+		temperature   *float64
 	}{
 		"shell": {
 			shell:         true,
 			describeShell: false,
 			code:          false,
 			want:          "Shell Command Generator",
+			temperature:   &zero,
 		},
 		"describeShell": {
 			shell:         false,
@@ -77,6 +81,7 @@ func TestCheckGet(t *testing.T) {
 			describeShell: false,
 			code:          true,
 			want:          "Code Generator",
+			temperature:   &zero,
 		},
 		"default": {
 			shell:         false,
@@ -87,7 +92,21 @@ func TestCheckGet(t *testing.T) {
 	}
 
 	for _, tc := range tests {
-		role, _ := CheckGet(tc.shell, tc.describeShell, tc.code)
+		role, _ := CheckGet(tc.shell, tc.describeShell, tc.code, map[string]string{})
 		assert.Equal(t, tc.want, role.Name)
+		assert.Equal(t, tc.temperature, role.Temperature)
 	}
 }
+
+func TestGetCommit(t *testing.T) {
+	t.Parallel()
+	role, err := GetCommit(false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Commit Message Generator", role.Name)
+	assert.Contains(t, role.Role, "without Markdown formatting")
+	assert.NotContains(t, role.Role, "Conventional Commits")
+
+	role, err = GetCommit(true, nil)
+	require.NoError(t, err)
+	assert.Contains(t, role.Role, "Conventional Commits format")
+}
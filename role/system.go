@@ -0,0 +1,163 @@
+package role
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const osReleasePath = "/etc/os-release"
+
+// knownShells are the process names accepted as the user's shell.
+var knownShells = map[string]bool{
+	"bash": true, "zsh": true, "fish": true, "sh": true, "dash": true, "ksh": true, "mksh": true,
+	"tcsh": true, "csh": true, "nu": true, "elvish": true, "xonsh": true, "pwsh": true, "ash": true,
+}
+
+// distroPackageManagers maps os-release IDs onto the package manager of the distribution.
+var distroPackageManagers = map[string]string{
+	"debian": "apt", "ubuntu": "apt", "linuxmint": "apt", "pop": "apt", "raspbian": "apt",
+	"fedora": "dnf", "rhel": "dnf", "centos": "dnf", "rocky": "dnf", "almalinux": "dnf",
+	"amzn": "yum", "arch": "pacman", "manjaro": "pacman", "endeavouros": "pacman",
+	"alpine": "apk", "opensuse": "zypper", "opensuse-leap": "zypper", "opensuse-tumbleweed": "zypper",
+	"sles": "zypper", "suse": "zypper", "gentoo": "emerge", "nixos": "nix",
+}
+
+// packageManagers are looked up in order when the distribution is unknown; the first
+// one found on PATH is reported.
+var packageManagers = []struct {
+	binary string
+	name   string
+}{
+	{"apt-get", "apt"},
+	{"dnf", "dnf"},
+	{"yum", "yum"},
+	{"pacman", "pacman"},
+	{"apk", "apk"},
+	{"zypper", "zypper"},
+	{"emerge", "emerge"},
+	{"nix-env", "nix"},
+	{"brew", "brew"},
+}
+
+// osName returns the operating system, including the distribution on Linux,
+// e.g. "Ubuntu 22.04.4 LTS (linux)".
+func osName() string {
+	name := runtime.GOOS
+	switch runtime.GOOS {
+	case "linux":
+		if distro := distroName(readOSRelease()); distro != "" {
+			name = distro + " (linux)"
+		}
+	case "darwin":
+		if out, err := exec.Command("sw_vers", "-productVersion").Output(); err == nil {
+			name = "macOS " + strings.TrimSpace(string(out)) + " (darwin)"
+		}
+	}
+	slog.Debug("detected os", slog.String("os", name))
+	return name
+}
+
+// readOSRelease returns the os-release of the system, empty if there is none.
+func readOSRelease() map[string]string {
+	f, err := os.Open(osReleasePath)
+	if err != nil {
+		return map[string]string{}
+	}
+	defer f.Close()
+	return parseOSRelease(f)
+}
+
+// parseOSRelease parses the KEY=value lines of an os-release file.
+func parseOSRelease(r io.Reader) map[string]string {
+	release := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+		release[key] = value
+	}
+	return release
+}
+
+func distroName(release map[string]string) string {
+	if name := release["PRETTY_NAME"]; name != "" {
+		return name
+	}
+	return strings.TrimSpace(release["NAME"] + " " + release["VERSION_ID"])
+}
+
+// packageManager returns the system package manager, or an empty string if none is found.
+// The distribution decides on Linux, since e.g. apt-get can be installed on Fedora.
+func packageManager() string {
+	if runtime.GOOS == "linux" {
+		if name := distroPackageManager(readOSRelease()); name != "" {
+			return name
+		}
+	}
+	for _, pm := range packageManagers {
+		if _, err := exec.LookPath(pm.binary); err == nil {
+			return pm.name
+		}
+	}
+	return ""
+}
+
+// distroPackageManager returns the package manager of the os-release ID, or of the
+// first ID_LIKE distribution known.
+func distroPackageManager(release map[string]string) string {
+	ids := append([]string{release["ID"]}, strings.Fields(release["ID_LIKE"])...)
+	for _, id := range ids {
+		if name := distroPackageManagers[id]; name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// shellName returns the shell sgpt was started from. The parent process is preferred
+// over $SHELL, which names the login shell even when e.g. fish runs inside bash.
+func shellName() string {
+	// todo: support windows shell
+	name := parentShell()
+	if name == "" {
+		name = filepath.Base(os.Getenv("SHELL"))
+	}
+	slog.Debug("detected shell", slog.String("shell", name))
+	return name
+}
+
+// parentShell reads the name of the parent process from procfs and returns it
+// if it is a known shell.
+func parentShell() string {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(os.Getppid()) + "/comm")
+	if err != nil {
+		return ""
+	}
+	return shellFromComm(string(data))
+}
+
+func shellFromComm(comm string) string {
+	name := strings.TrimPrefix(strings.TrimSpace(comm), "-") // login shells
+	if knownShells[name] {
+		return name
+	}
+	return ""
+}
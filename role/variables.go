@@ -0,0 +1,129 @@
+package role
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// builtins computes the variables every role can reference, e.g. {{ .GitBranch }}.
+// They are only evaluated when a role references them.
+var builtins = map[string]func() string{
+	"OS":             osName,
+	"Shell":          shellName,
+	"PackageManager": packageManager,
+	"Cwd":            cwd,
+	"Date":           date,
+	"User":           userName,
+	"Hostname":       hostname,
+	"GitBranch":      gitBranch,
+	"GitRoot":        gitRoot,
+	"Language":       language,
+}
+
+// languageMarkers maps files found at the root of a project to its language, in order of precedence.
+var languageMarkers = []struct {
+	file     string
+	language string
+}{
+	{"go.mod", "Go"},
+	{"Cargo.toml", "Rust"},
+	{"tsconfig.json", "TypeScript"},
+	{"package.json", "JavaScript"},
+	{"pyproject.toml", "Python"},
+	{"setup.py", "Python"},
+	{"requirements.txt", "Python"},
+	{"Gemfile", "Ruby"},
+	{"pom.xml", "Java"},
+	{"build.gradle", "Java"},
+	{"build.gradle.kts", "Kotlin"},
+	{"composer.json", "PHP"},
+	{"mix.exs", "Elixir"},
+	{"Package.swift", "Swift"},
+	{"CMakeLists.txt", "C++"},
+}
+
+// contextVariables returns the variables to render a role with: the built-ins referenced
+// by names, overridden by extra.
+func contextVariables(names []string, extra map[string]string) map[string]string {
+	variables := map[string]string{}
+	for _, name := range names {
+		if fn, ok := builtins[name]; ok {
+			variables[name] = fn()
+		}
+	}
+	for k, v := range extra {
+		variables[k] = v
+	}
+	return variables
+}
+
+func cwd() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
+func date() string {
+	return time.Now().Format(time.DateOnly)
+}
+
+func userName() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+func gitBranch() string {
+	return git("rev-parse", "--abbrev-ref", "HEAD")
+}
+
+func gitRoot() string {
+	return git("rev-parse", "--show-toplevel")
+}
+
+// git runs a git command in the current directory and returns its trimmed output,
+// or an empty string outside of a repository.
+func git(args ...string) string {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func language() string {
+	if root := gitRoot(); root != "" {
+		if lang := detectLanguage(root); lang != "" {
+			return lang
+		}
+	}
+	return detectLanguage(cwd())
+}
+
+// detectLanguage guesses the main language of the project in dir from its marker files.
+func detectLanguage(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	for _, m := range languageMarkers {
+		if _, err := os.Stat(filepath.Join(dir, m.file)); err == nil {
+			return m.language
+		}
+	}
+	return ""
+}
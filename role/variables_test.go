@@ -0,0 +1,102 @@
+package role
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContextVariables(t *testing.T) {
+	t.Parallel()
+	variables := contextVariables([]string{"Date", "Ticket"}, map[string]string{"Ticket": "ABC-1", "Date": "2024-01-01"})
+	assert.Equal(t, map[string]string{"Ticket": "ABC-1", "Date": "2024-01-01"}, variables)
+
+	variables = contextVariables([]string{"Cwd"}, nil)
+	assert.NotEmpty(t, variables["Cwd"])
+	assert.NotContains(t, variables, "GitBranch")
+}
+
+func TestDetectLanguage(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		files []string
+		want  string
+	}{
+		"go":         {files: []string{"go.mod"}, want: "Go"},
+		"typescript": {files: []string{"package.json", "tsconfig.json"}, want: "TypeScript"},
+		"python":     {files: []string{"requirements.txt"}, want: "Python"},
+		"unknown":    {files: []string{"README.md"}, want: ""},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			for _, f := range tc.files {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, f), nil, 0o600))
+			}
+			assert.Equal(t, tc.want, detectLanguage(dir))
+		})
+	}
+}
+
+func TestCheckGetVariables(t *testing.T) {
+	t.Parallel()
+	role, err := CheckGet(false, false, false, map[string]string{"OS": "plan9", "Shell": "rc"})
+	require.NoError(t, err)
+	assert.Contains(t, role.Role, "You are managing plan9 operating system with rc shell.")
+
+	_, err = NewRole("Reviewer", "Review {{ .Ticket }} on {{ .GitBranch }}", map[string]string{"GitBranch": "main"})
+	assert.EqualError(t, err, `template "Reviewer" is missing variables: Ticket`)
+}
+
+func TestParseOSRelease(t *testing.T) {
+	t.Parallel()
+	release := parseOSRelease(strings.NewReader(`# comment
+NAME="Fedora Linux"
+VERSION_ID=40
+PRETTY_NAME="Fedora Linux 40 (Workstation Edition)"
+ID=fedora
+`))
+	assert.Equal(t, "fedora", release["ID"])
+	assert.Equal(t, "Fedora Linux 40 (Workstation Edition)", distroName(release))
+
+	delete(release, "PRETTY_NAME")
+	assert.Equal(t, "Fedora Linux 40", distroName(release))
+}
+
+func TestDistroPackageManager(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		release map[string]string
+		want    string
+	}{
+		{map[string]string{"ID": "fedora"}, "dnf"},
+		{map[string]string{"ID": "ubuntu", "ID_LIKE": "debian"}, "apt"},
+		{map[string]string{"ID": "kali-custom", "ID_LIKE": "unknown debian"}, "apt"},
+		{map[string]string{"ID": "opensuse-tumbleweed", "ID_LIKE": "opensuse suse"}, "zypper"},
+		{map[string]string{"ID": "plan9"}, ""},
+		{map[string]string{}, ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, distroPackageManager(tt.release), tt.release)
+	}
+}
+
+func TestShellFromComm(t *testing.T) {
+	t.Parallel()
+	tests := map[string]string{
+		"fish\n":  "fish",
+		"-bash\n": "bash",
+		"nu":      "nu",
+		"tmux":    "",
+		"":        "",
+	}
+	for comm, want := range tests {
+		assert.Equal(t, want, shellFromComm(comm), comm)
+	}
+}
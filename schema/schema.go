@@ -0,0 +1,94 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+const maxNameLength = 64
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// Schema is a JSON schema used to request structured output from a provider
+// and to validate the response locally.
+type Schema struct {
+	// Name identifies the schema in provider requests, e.g. OpenAI's json_schema.name.
+	Name string
+	// Raw is the decoded schema document as sent to the provider.
+	Raw map[string]interface{}
+
+	compiled *jsonschema.Schema
+}
+
+// Load reads and compiles a JSON schema from the given file.
+func Load(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read json schema: %w", err)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return Parse(name, data)
+}
+
+// Parse compiles a JSON schema from raw bytes.
+func Parse(name string, data []byte) (*Schema, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse json schema: %w", err)
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse json schema: %w", err)
+	}
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource("schema.json", doc); err != nil {
+		return nil, fmt.Errorf("failed to load json schema: %w", err)
+	}
+	compiled, err := compiler.Compile("schema.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile json schema: %w", err)
+	}
+
+	return &Schema{
+		Name:     sanitizeName(name),
+		Raw:      raw,
+		compiled: compiled,
+	}, nil
+}
+
+// Validate checks that text is a JSON document. When s is not nil, the document
+// must also conform to the schema.
+func Validate(s *Schema, text string) error {
+	if !json.Valid([]byte(text)) {
+		return errors.New("response is not valid JSON")
+	}
+	if s == nil {
+		return nil
+	}
+
+	instance, err := jsonschema.UnmarshalJSON(strings.NewReader(text))
+	if err != nil {
+		return err
+	}
+	return s.compiled.Validate(instance)
+}
+
+func sanitizeName(name string) string {
+	name = invalidNameChars.ReplaceAllString(name, "_")
+	if name == "" {
+		return "response"
+	}
+	if len(name) > maxNameLength {
+		return name[:maxNameLength]
+	}
+	return name
+}
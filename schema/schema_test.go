@@ -0,0 +1,120 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const personSchema = `{
+  "type": "object",
+  "properties": {
+    "name": {"type": "string"},
+    "age": {"type": "integer"}
+  },
+  "required": ["name", "age"],
+  "additionalProperties": false
+}`
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		"valid": {
+			name: "person",
+			data: personSchema,
+			want: "person",
+		},
+		"sanitized name": {
+			name: "my schema.v1",
+			data: personSchema,
+			want: "my_schema_v1",
+		},
+		"empty name": {
+			name: "",
+			data: personSchema,
+			want: "response",
+		},
+		"broken json": {
+			name:    "broken",
+			data:    `{"type": `,
+			wantErr: true,
+		},
+		"invalid schema": {
+			name:    "invalid",
+			data:    `{"type": 1}`,
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s, err := Parse(tc.name, []byte(tc.data))
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, s.Name)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	s, err := Parse("person", []byte(personSchema))
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		schema  *Schema
+		text    string
+		wantErr bool
+	}{
+		"conforming": {
+			schema: s,
+			text:   `{"name": "gopher", "age": 15}`,
+		},
+		"missing property": {
+			schema:  s,
+			text:    `{"name": "gopher"}`,
+			wantErr: true,
+		},
+		"wrong type": {
+			schema:  s,
+			text:    `{"name": "gopher", "age": "fifteen"}`,
+			wantErr: true,
+		},
+		"not json": {
+			schema:  s,
+			text:    "name: gopher",
+			wantErr: true,
+		},
+		"json mode without schema": {
+			schema: nil,
+			text:   `{"anything": true}`,
+		},
+		"json mode with invalid json": {
+			schema:  nil,
+			text:    "```json\n{}\n```",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := Validate(tc.schema, tc.text)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hirosassa/sgpt/handler"
+)
+
+// Headers selecting sgpt features that have no counterpart in the OpenAI API.
+const (
+	HeaderRole     = "X-Sgpt-Role"
+	HeaderChat     = "X-Sgpt-Chat"
+	HeaderPlatform = "X-Sgpt-Platform"
+)
+
+// ErrBadRequest marks backend errors caused by the request rather than by the provider.
+var ErrBadRequest = errors.New("bad request")
+
+// Backend answers chat completion requests.
+type Backend interface {
+	// Complete answers the request. When onDelta is not nil, it is called with each
+	// piece of the first candidate as it is generated.
+	Complete(ctx context.Context, req *Request, onDelta func(delta string) error) (*handler.Response, error)
+	// Models lists the models that can be requested.
+	Models() []Model
+}
+
+// Model is an entry of the /v1/models list.
+type Model struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// New returns an http.Handler serving the OpenAI chat completions and models endpoints.
+// When token is not empty, requests must send it as a bearer token.
+func New(backend Backend, token string) http.Handler {
+	s := &server{backend: backend}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.chatCompletions)
+	mux.HandleFunc("GET /v1/models", s.models)
+	if token == "" {
+		return mux
+	}
+	return requireToken(token, mux)
+}
+
+type server struct {
+	backend Backend
+}
+
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid_request_error", "invalid or missing bearer token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) models(w http.ResponseWriter, r *http.Request) {
+	models := s.backend.Models()
+	for i := range models {
+		models[i].Object = "model"
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"object": "list", "data": models})
+}
+
+func (s *server) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid request body: "+err.Error())
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	req.Role = r.Header.Get(HeaderRole)
+	req.ChatID = r.Header.Get(HeaderChat)
+	req.Platform = r.Header.Get(HeaderPlatform)
+
+	id := "chatcmpl-" + randomID()
+	created := time.Now().Unix()
+	if req.Stream {
+		s.stream(w, r, &req, id, created)
+		return
+	}
+
+	res, err := s.backend.Complete(r.Context(), &req, nil)
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newCompletion(id, created, res))
+}
+
+// stream answers with server-sent events in the format of the OpenAI streaming API.
+func (s *server) stream(w http.ResponseWriter, r *http.Request, req *Request, id string, created int64) {
+	flusher, _ := w.(http.Flusher)
+	started := false
+	send := func(v any) error {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	model := req.Model
+	first := true
+	res, err := s.backend.Complete(r.Context(), req, func(delta string) error {
+		d := chunkDelta{Content: delta}
+		if first {
+			d.Role = "assistant"
+			first = false
+		}
+		return send(chunk{ID: id, Object: "chat.completion.chunk", Created: created, Model: model,
+			Choices: []chunkChoice{{Delta: d}}})
+	})
+	if err != nil {
+		if !started {
+			writeBackendError(w, err)
+			return
+		}
+		slog.Error("stream failed", slog.String("error", err.Error()))
+		_ = send(errorBody{Error: apiError{Message: err.Error(), Type: "api_error"}})
+		return
+	}
+
+	finish := res.FinishReason
+	usage := newUsage(res.Usage)
+	if err := send(chunk{ID: id, Object: "chat.completion.chunk", Created: created, Model: res.Model,
+		Choices: []chunkChoice{{Delta: chunkDelta{}, FinishReason: &finish}}, Usage: &usage}); err != nil {
+		return
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+func writeBackendError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrBadRequest) {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	writeError(w, http.StatusBadGateway, "api_error", err.Error())
+}
+
+func writeError(w http.ResponseWriter, status int, typ, message string) {
+	writeJSON(w, status, errorBody{Error: apiError{Message: message, Type: typ}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write response", slog.String("error", err.Error()))
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hirosassa/sgpt/handler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBackend answers with the prompt in upper case, one word per delta.
+type fakeBackend struct {
+	got *Request
+}
+
+func (b *fakeBackend) Complete(ctx context.Context, req *Request, onDelta func(delta string) error) (*handler.Response, error) {
+	b.got = req
+	if req.Prompt() == "fail" {
+		return nil, fmt.Errorf("%w: unsupported role", ErrBadRequest)
+	}
+	content := strings.ToUpper(req.Prompt())
+	if onDelta != nil {
+		for _, word := range strings.SplitAfter(content, " ") {
+			if err := onDelta(word); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &handler.Response{
+		Content:      content,
+		Model:        "fake-model",
+		FinishReason: "stop",
+		Usage:        handler.Usage{PromptTokens: 2, CompletionTokens: 2, TotalTokens: 4},
+	}, nil
+}
+
+func (b *fakeBackend) Models() []Model {
+	return []Model{{ID: "fake-model", OwnedBy: "sgpt"}}
+}
+
+func post(t *testing.T, srv *httptest.Server, body string, headers map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/v1/chat/completions", strings.NewReader(body))
+	require.NoError(t, err)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+func TestChatCompletions(t *testing.T) {
+	t.Parallel()
+	backend := &fakeBackend{}
+	srv := httptest.NewServer(New(backend, ""))
+	defer srv.Close()
+
+	body := `{
+		"model": "fake-model",
+		"messages": [
+			{"role": "system", "content": "be brief"},
+			{"role": "user", "content": [{"type": "text", "text": "hello world"}]}
+		],
+		"stop": "END",
+		"temperature": 0.5
+	}`
+	res := post(t, srv, body, map[string]string{HeaderRole: "shell", HeaderChat: "editor"})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var got completion
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+	assert.Equal(t, "chat.completion", got.Object)
+	require.Len(t, got.Choices, 1)
+	assert.Equal(t, message{Role: "assistant", Content: "HELLO WORLD"}, got.Choices[0].Message)
+	assert.Equal(t, "stop", got.Choices[0].FinishReason)
+	assert.Equal(t, int64(4), got.Usage.TotalTokens)
+
+	assert.Equal(t, "shell", backend.got.Role)
+	assert.Equal(t, "editor", backend.got.ChatID)
+	assert.Equal(t, Stop{"END"}, backend.got.Stop)
+	assert.Equal(t, []handler.Turn{{Role: "system", Content: "be brief"}}, backend.got.History())
+}
+
+func TestChatCompletionsStream(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(New(&fakeBackend{}, ""))
+	defer srv.Close()
+
+	res := post(t, srv, `{"stream": true, "messages": [{"role": "user", "content": "hello world"}]}`, nil)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	var events []string
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, events, 4)
+	assert.Equal(t, "[DONE]", events[3])
+
+	var content strings.Builder
+	for i, event := range events[:3] {
+		var c chunk
+		require.NoError(t, json.Unmarshal([]byte(event), &c))
+		assert.Equal(t, "chat.completion.chunk", c.Object)
+		content.WriteString(c.Choices[0].Delta.Content)
+		if i == 0 {
+			assert.Equal(t, "assistant", c.Choices[0].Delta.Role)
+		}
+		if i == 2 {
+			require.NotNil(t, c.Choices[0].FinishReason)
+			assert.Equal(t, "stop", *c.Choices[0].FinishReason)
+			require.NotNil(t, c.Usage)
+		}
+	}
+	assert.Equal(t, "HELLO WORLD", content.String())
+}
+
+func TestChatCompletionsBadRequest(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(New(&fakeBackend{}, ""))
+	defer srv.Close()
+
+	tests := map[string]string{
+		"invalid json":      `{`,
+		"no messages":       `{"messages": []}`,
+		"last not user":     `{"messages": [{"role": "user", "content": "hi"}, {"role": "assistant", "content": "hello"}]}`,
+		"stream with n":     `{"stream": true, "n": 2, "messages": [{"role": "user", "content": "hi"}]}`,
+		"schema missing":    `{"response_format": {"type": "json_schema"}, "messages": [{"role": "user", "content": "hi"}]}`,
+		"rejected by sgpt":  `{"messages": [{"role": "user", "content": "fail"}]}`,
+		"rejected streamed": `{"stream": true, "messages": [{"role": "user", "content": "fail"}]}`,
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := post(t, srv, body, nil)
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+			var got errorBody
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+			assert.NotEmpty(t, got.Error.Message)
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(New(&fakeBackend{}, "secret"))
+	defer srv.Close()
+
+	body := `{"messages": [{"role": "user", "content": "hi"}]}`
+	assert.Equal(t, http.StatusUnauthorized, post(t, srv, body, nil).StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, post(t, srv, body, map[string]string{"Authorization": "Bearer wrong"}).StatusCode)
+	assert.Equal(t, http.StatusOK, post(t, srv, body, map[string]string{"Authorization": "Bearer secret"}).StatusCode)
+}
+
+func TestModels(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(New(&fakeBackend{}, ""))
+	defer srv.Close()
+
+	res, err := srv.Client().Get(srv.URL + "/v1/models")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var got struct {
+		Object string  `json:"object"`
+		Data   []Model `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+	assert.Equal(t, "list", got.Object)
+	assert.Equal(t, []Model{{ID: "fake-model", Object: "model", OwnedBy: "sgpt"}}, got.Data)
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hirosassa/sgpt/handler"
+)
+
+// Request is the subset of an OpenAI chat completion request sgpt supports.
+type Request struct {
+	Model               string          `json:"model"`
+	Messages            []Message       `json:"messages"`
+	Stream              bool            `json:"stream"`
+	Temperature         *float64        `json:"temperature"`
+	TopP                *float64        `json:"top_p"`
+	MaxTokens           *int64          `json:"max_tokens"`
+	MaxCompletionTokens *int64          `json:"max_completion_tokens"`
+	Seed                *int64          `json:"seed"`
+	Stop                Stop            `json:"stop"`
+	N                   *int64          `json:"n"`
+	ResponseFormat      *ResponseFormat `json:"response_format"`
+
+	// Role, ChatID and Platform are read from the X-Sgpt-* headers.
+	Role     string `json:"-"`
+	ChatID   string `json:"-"`
+	Platform string `json:"-"`
+}
+
+// Message is a message of the conversation.
+type Message struct {
+	Role    string  `json:"role"`
+	Content Content `json:"content"`
+}
+
+// Content is the text of a message. It accepts both a string and a list of
+// content parts, of which only the text parts are kept.
+type Content string
+
+func (c *Content) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*c = Content(s)
+		return nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return errors.New("content must be a string or a list of content parts")
+	}
+	var texts []string
+	for _, p := range parts {
+		if p.Type == "text" {
+			texts = append(texts, p.Text)
+		}
+	}
+	*c = Content(strings.Join(texts, "\n"))
+	return nil
+}
+
+// Stop accepts both a single stop sequence and a list of them.
+type Stop []string
+
+func (s *Stop) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = Stop{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("stop must be a string or a list of strings")
+	}
+	*s = many
+	return nil
+}
+
+// ResponseFormat selects a JSON response.
+type ResponseFormat struct {
+	// Type is one of "text", "json_object" or "json_schema".
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema"`
+}
+
+// JSONSchema is the schema of a "json_schema" response format.
+type JSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+func (r *Request) validate() error {
+	if len(r.Messages) == 0 {
+		return errors.New("messages must not be empty")
+	}
+	if last := r.Messages[len(r.Messages)-1]; last.Role != "user" {
+		return fmt.Errorf("the last message must have the user role, got %q", last.Role)
+	}
+	for _, m := range r.Messages {
+		switch m.Role {
+		case "system", "developer", "user", "assistant":
+		default:
+			return fmt.Errorf("unsupported message role %q", m.Role)
+		}
+	}
+	if r.Stream && r.N != nil && *r.N > 1 {
+		return errors.New("n greater than 1 is not supported with stream")
+	}
+	if f := r.ResponseFormat; f != nil {
+		switch f.Type {
+		case "", "text", "json_object":
+		case "json_schema":
+			if f.JSONSchema == nil || len(f.JSONSchema.Schema) == 0 {
+				return errors.New("response_format.json_schema.schema is required")
+			}
+		default:
+			return fmt.Errorf("unsupported response_format type %q", f.Type)
+		}
+	}
+	return nil
+}
+
+// Prompt returns the last user message.
+func (r *Request) Prompt() string {
+	return string(r.Messages[len(r.Messages)-1].Content)
+}
+
+// History returns the messages before the prompt.
+func (r *Request) History() []handler.Turn {
+	turns := make([]handler.Turn, 0, len(r.Messages)-1)
+	for _, m := range r.Messages[:len(r.Messages)-1] {
+		role := m.Role
+		if role == "developer" {
+			role = "system"
+		}
+		turns = append(turns, handler.Turn{Role: role, Content: string(m.Content)})
+	}
+	return turns
+}
+
+type completion struct {
+	ID      string   `json:"id"`
+	Object  string   `json:"object"`
+	Created int64    `json:"created"`
+	Model   string   `json:"model"`
+	Choices []choice `json:"choices"`
+	Usage   usage    `json:"usage"`
+}
+
+type choice struct {
+	Index        int     `json:"index"`
+	Message      message `json:"message"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type usage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+type chunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []chunkChoice `json:"choices"`
+	Usage   *usage        `json:"usage,omitempty"`
+}
+
+type chunkChoice struct {
+	Index        int        `json:"index"`
+	Delta        chunkDelta `json:"delta"`
+	FinishReason *string    `json:"finish_reason"`
+}
+
+type chunkDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type errorBody struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+func newUsage(u handler.Usage) usage {
+	return usage{PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens, TotalTokens: u.TotalTokens}
+}
+
+func newCompletion(id string, created int64, res *handler.Response) completion {
+	contents := res.Candidates
+	if len(contents) == 0 {
+		contents = []string{res.Content}
+	}
+	choices := make([]choice, 0, len(contents))
+	for i, content := range contents {
+		choices = append(choices, choice{
+			Index:        i,
+			Message:      message{Role: "assistant", Content: content},
+			FinishReason: res.FinishReason,
+		})
+	}
+	return completion{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: created,
+		Model:   res.Model,
+		Choices: choices,
+		Usage:   newUsage(res.Usage),
+	}
+}
@@ -0,0 +1,105 @@
+// Package transport builds the HTTP client shared by all providers, for networks with
+// proxies, internal certificate authorities and client certificates.
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Config configures the HTTP client. The proxy is always taken from the
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
+type Config struct {
+	// CAFile is a PEM bundle of certificate authorities trusted in addition to the system ones.
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key for mutual TLS.
+	CertFile string
+	KeyFile  string
+	// Header is added to every request.
+	Header http.Header
+	// Timeout limits the time of a request including reading the response; zero means no limit.
+	Timeout time.Duration
+}
+
+// IsZero reports whether the configuration leaves every default unchanged.
+func (c Config) IsZero() bool {
+	return c.CAFile == "" && c.CertFile == "" && c.KeyFile == "" && len(c.Header) == 0 && c.Timeout == 0
+}
+
+// RoundTripper returns the transport sending requests as configured.
+func (c Config) RoundTripper() (http.RoundTripper, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyFromEnvironment
+
+	if c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" {
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig = tlsConfig
+	}
+
+	if len(c.Header) == 0 {
+		return t, nil
+	}
+	return &headerTransport{header: c.Header, next: t}, nil
+}
+
+// Client returns an HTTP client sending requests as configured.
+func (c Config) Client() (*http.Client, error) {
+	t, err := c.RoundTripper()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: t, Timeout: c.Timeout}, nil
+}
+
+func (c Config) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if c.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in CA bundle %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	switch {
+	case c.CertFile != "" && c.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	case c.CertFile != "" || c.KeyFile != "":
+		return nil, errors.New("a client certificate needs both a certificate and a key file")
+	}
+	return config, nil
+}
+
+// headerTransport adds headers to every request.
+type headerTransport struct {
+	header http.Header
+	next   http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range t.header {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	return t.next.RoundTrip(req)
+}
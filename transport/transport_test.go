@@ -0,0 +1,154 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes the certificate of a TLS test server as a PEM bundle.
+func writeCert(t *testing.T, cert *x509.Certificate) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600))
+	return path
+}
+
+// newClientCert writes a self-signed client certificate and its key.
+func newClientCert(t *testing.T) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sgpt"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return cert, certFile, keyFile
+}
+
+func get(t *testing.T, client *http.Client, url string) (string, error) {
+	t.Helper()
+	res, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return string(body), nil
+}
+
+func TestCAFileAndHeader(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("X-Tenant"))
+	}))
+	defer srv.Close()
+
+	client, err := Config{}.Client()
+	require.NoError(t, err)
+	_, err = get(t, client, srv.URL)
+	assert.Error(t, err, "the test server is not trusted without the CA bundle")
+
+	client, err = Config{
+		CAFile: writeCert(t, srv.Certificate()),
+		Header: http.Header{"X-Tenant": {"research"}},
+	}.Client()
+	require.NoError(t, err)
+	body, err := get(t, client, srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "research", body)
+}
+
+func TestClientCertificate(t *testing.T) {
+	t.Parallel()
+	clientCert, certFile, keyFile := newClientCert(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := writeCert(t, srv.Certificate())
+
+	client, err := Config{CAFile: caFile}.Client()
+	require.NoError(t, err)
+	_, err = get(t, client, srv.URL)
+	assert.Error(t, err, "the server requires a client certificate")
+
+	client, err = Config{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}.Client()
+	require.NoError(t, err)
+	body, err := get(t, client, srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "sgpt", body)
+}
+
+func TestTimeout(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	client, err := Config{Timeout: 50 * time.Millisecond}.Client()
+	require.NoError(t, err)
+	_, err = get(t, client, srv.URL)
+	assert.ErrorContains(t, err, "Client.Timeout exceeded")
+}
+
+func TestInvalidConfig(t *testing.T) {
+	t.Parallel()
+	_, err := Config{CertFile: "client.pem"}.Client()
+	assert.ErrorContains(t, err, "needs both")
+
+	_, err = Config{CAFile: filepath.Join(t.TempDir(), "missing.pem")}.Client()
+	assert.ErrorContains(t, err, "failed to read CA bundle")
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	require.NoError(t, os.WriteFile(empty, []byte("not a certificate"), 0o600))
+	_, err = Config{CAFile: empty}.Client()
+	assert.ErrorContains(t, err, "no PEM certificates")
+
+	assert.True(t, Config{}.IsZero())
+	assert.False(t, Config{Timeout: time.Second}.IsZero())
+}